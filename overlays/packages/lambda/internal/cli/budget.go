@@ -0,0 +1,171 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/configutil"
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/state"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// budgetReport is a launch evaluated against the budget.
+type budgetReport struct {
+	// BurnUSDPerHour is the hourly price of the live instances, and
+	// LaunchUSDPerHour that of the instances about to be launched.
+	BurnUSDPerHour   float64
+	LaunchUSDPerHour float64
+	// ProjectedMonthEndUSD is this month's spend if everything, the launch
+	// included, runs until the end of the month.
+	ProjectedMonthEndUSD float64
+	// GPUType is the GPU type of the launch as the GPU caps count it, with
+	// the GPUs of that type already running and about to be launched.
+	GPUType    string
+	LiveGPUs   int
+	LaunchGPUs int
+	// Violations describe the caps the launch would break.
+	Violations []string
+}
+
+// gpuTypeOf returns the GPU type of an instance type as
+// --max-instances-per-type counts it, e.g. h100 for gpu_8x_h100_sxm5.
+func gpuTypeOf(typeName string) string {
+	m := specRe.FindStringSubmatch(specFromTypeName(typeName))
+	if m == nil {
+		return ""
+	}
+	gpuType, _, _ := strings.Cut(strings.ToLower(m[2]), "_")
+	return gpuType
+}
+
+// evaluateBudget checks a launch of count instances of launch against b,
+// given the live instances and the history of the account they belong to
+// (see accountHistory).
+func evaluateBudget(b configutil.Budget, history map[string]*state.Instance, instances []api.Instance, launch api.InstanceType, count int, now time.Time) budgetReport {
+	costs := buildCostReport(history, instances, startOfMonth(now), now, "type")
+	r := budgetReport{
+		BurnUSDPerHour:   costs.BurnRateUSDPerHour,
+		LaunchUSDPerHour: float64(launch.PriceCentsPerHour*count) / 100,
+		GPUType:          gpuTypeOf(launch.Name),
+		LaunchGPUs:       gpusOfType(launch.Name) * count,
+	}
+	burn := r.BurnUSDPerHour + r.LaunchUSDPerHour
+	remaining := startOfMonth(now).AddDate(0, 1, 0).Sub(now).Hours()
+	r.ProjectedMonthEndUSD = roundCents(costs.MonthToDateUSD + burn*remaining)
+	for _, inst := range instances {
+		if inst.Status != "terminated" && gpuTypeOf(inst.InstanceType.Name) == r.GPUType {
+			r.LiveGPUs += gpusOfType(inst.InstanceType.Name)
+		}
+	}
+
+	if b.MaxUSDPerHour > 0 && burn > b.MaxUSDPerHour {
+		r.Violations = append(r.Violations, fmt.Sprintf("concurrent spend would reach $%.2f/h ($%.2f/h running + $%.2f/h launching), over the $%.2f/h cap",
+			burn, r.BurnUSDPerHour, r.LaunchUSDPerHour, b.MaxUSDPerHour))
+	}
+	if limit, ok := b.MaxGPUs(r.GPUType); ok && r.LiveGPUs+r.LaunchGPUs > limit {
+		r.Violations = append(r.Violations, fmt.Sprintf("%s GPUs would reach %d (%d running + %d launching), over the cap of %d",
+			r.GPUType, r.LiveGPUs+r.LaunchGPUs, r.LiveGPUs, r.LaunchGPUs, limit))
+	}
+	if b.MonthlyCapUSD > 0 && r.ProjectedMonthEndUSD > b.MonthlyCapUSD {
+		r.Violations = append(r.Violations, fmt.Sprintf("this month's spend is projected to reach $%.2f, over the $%.2f monthly cap",
+			r.ProjectedMonthEndUSD, b.MonthlyCapUSD))
+	}
+	return r
+}
+
+// budgetGuard holds the launches of one command to the budget in the
+// config file.
+type budgetGuard struct {
+	Budget configutil.Budget
+	// Profile is the active profile, whose launches count toward the
+	// monthly spend.
+	Profile string
+	Client  *api.Client
+	Types   map[string]api.InstanceTypeDetails
+	Count   int
+	// Override is the reason given with --override-budget, if any.
+	Override string
+
+	// violations are those of the last check that was overridden.
+	violations []string
+}
+
+// newBudgetGuard returns the guard for a launch of count instances by cmd,
+// which has an --override-budget flag.
+func newBudgetGuard(cmd *cobra.Command, client *api.Client, types map[string]api.InstanceTypeDetails, count int) (*budgetGuard, error) {
+	_, cfg, err := loadConfig(cmd)
+	if err != nil {
+		return nil, err
+	}
+	profileName, _, err := ActiveProfile(cmd)
+	if err != nil {
+		return nil, err
+	}
+	override, _ := cmd.Flags().GetString("override-budget")
+	if cmd.Flags().Changed("override-budget") && strings.TrimSpace(override) == "" {
+		return nil, fmt.Errorf("--override-budget needs a reason, e.g. --override-budget 'deadline for the eval run'")
+	}
+	return &budgetGuard{Budget: cfg.Budget, Profile: profileName, Client: client, Types: types, Count: count, Override: strings.TrimSpace(override)}, nil
+}
+
+// check is the launchPlan.Check of the guarded launch. It prints the
+// projected burn and fails if the launch breaks a cap without an override.
+func (g *budgetGuard) check(ctx context.Context, choice *placement) error {
+	// Capacity may have taken hours to free up, so look at what runs now.
+	instances, err := g.Client.ListInstances(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching instances for the budget check: %w", err)
+	}
+	launch := g.Types[choice.InstanceType].InstanceType
+	now := time.Now()
+	history := accountHistory(loadHistory(), instances, g.Profile, g.Client.BaseURL(), now)
+	r := evaluateBudget(g.Budget, history, instances, launch, g.Count, now)
+
+	burn := fmt.Sprintf("$%.2f/h", r.BurnUSDPerHour+r.LaunchUSDPerHour)
+	if g.Budget.MaxUSDPerHour > 0 {
+		burn += fmt.Sprintf(" of $%.2f/h", g.Budget.MaxUSDPerHour)
+	}
+	monthEnd := fmt.Sprintf("$%.2f", r.ProjectedMonthEndUSD)
+	if g.Budget.MonthlyCapUSD > 0 {
+		monthEnd += fmt.Sprintf(" of $%.2f", g.Budget.MonthlyCapUSD)
+	}
+	log.Infof("Projected burn with this launch: %s ($%.2f/h running + $%.2f/h launching); month end %s.", burn, r.BurnUSDPerHour, r.LaunchUSDPerHour, monthEnd)
+
+	if len(r.Violations) == 0 {
+		g.violations = nil
+		return nil
+	}
+	for _, v := range r.Violations {
+		log.Warnf("Over budget: %s.", v)
+	}
+	if g.Override == "" {
+		return fmt.Errorf("launching %d x %s would exceed the budget in the config file. Pass --override-budget <reason> to launch anyway", g.Count, choice.InstanceType)
+	}
+	log.Warnf("Launching over budget (--override-budget): %s", g.Override)
+	g.violations = r.Violations
+	return nil
+}
+
+// recordOverride records the override that let the launch of ids through,
+// if one did.
+func (g *budgetGuard) recordOverride(ids []string) {
+	if len(g.violations) == 0 {
+		return
+	}
+	user := configutil.LocalUser()
+	events := make([]state.Event, 0, len(ids))
+	for _, id := range ids {
+		events = append(events, state.Event{
+			Kind:       state.BudgetOverride,
+			InstanceID: id,
+			User:       user,
+			Reason:     g.Override,
+			Violations: g.violations,
+		})
+	}
+	recordEvents(events...)
+}
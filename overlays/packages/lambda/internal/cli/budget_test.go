@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/configutil"
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/state"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+)
+
+func TestEvaluateBudget(t *testing.T) {
+	now := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	history := state.Fold([]state.Event{
+		{Time: now.AddDate(0, 0, -1), Kind: state.Launched, InstanceID: "i-1", InstanceType: "gpu_8x_h100_sxm5", PriceCentsPerHour: 2000},
+	})
+	live := []api.Instance{{ID: "i-1", Status: "active"}, {ID: "i-2", Status: "terminated"}}
+	live[0].InstanceType.Name, live[0].InstanceType.PriceCentsPerHour = "gpu_8x_h100_sxm5", 2000
+	live[1].InstanceType.Name, live[1].InstanceType.PriceCentsPerHour = "gpu_8x_h100_pcie", 1500
+	launch := api.InstanceType{Name: "gpu_8x_h100_sxm5", PriceCentsPerHour: 2000}
+	budget := configutil.Budget{MaxUSDPerHour: 30, MaxGPUsPerType: map[string]int{"H100": 16}, MonthlyCapUSD: 20000}
+
+	if r := evaluateBudget(configutil.Budget{}, history, live, launch, 5, now); len(r.Violations) != 0 {
+		t.Errorf("no budget: violations %v", r.Violations)
+	}
+
+	// $20/h running + $20/h launching; 24h so far, then 16 days at $40/h.
+	r := evaluateBudget(budget, history, live, launch, 1, now)
+	if r.BurnUSDPerHour != 20 || r.LaunchUSDPerHour != 20 || r.ProjectedMonthEndUSD != 480+40*24*16 {
+		t.Errorf("report = %+v", r)
+	}
+	if r.GPUType != "h100" || r.LiveGPUs != 8 || r.LaunchGPUs != 8 {
+		t.Errorf("GPUs = %s %d+%d", r.GPUType, r.LiveGPUs, r.LaunchGPUs)
+	}
+	if len(r.Violations) != 1 || !strings.Contains(r.Violations[0], "$40.00/h") {
+		t.Errorf("violations = %v", r.Violations)
+	}
+
+	r = evaluateBudget(budget, history, live, launch, 2, now)
+	if len(r.Violations) != 3 || !strings.Contains(r.Violations[1], "h100 GPUs would reach 24") {
+		t.Errorf("violations = %v", r.Violations)
+	}
+}
+
+func TestEvaluateBudgetGoneInstances(t *testing.T) {
+	now := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	launched := now.AddDate(0, 0, -5)
+	history := state.Fold([]state.Event{
+		// Terminated by its own TTL timer after a day; lm never saw it go.
+		{Time: launched, Kind: state.Launched, InstanceID: "i-1", InstanceType: "gpu_1x_a10", PriceCentsPerHour: 100, Profile: "default"},
+		{Time: launched, Kind: state.TTL, InstanceID: "i-1", ExpiresAt: launched.Add(24 * time.Hour)},
+	})
+	launch := api.InstanceType{Name: "gpu_1x_a10", PriceCentsPerHour: 100}
+	budget := configutil.Budget{MonthlyCapUSD: 420}
+
+	scoped := accountHistory(history, nil, "default", api.DefaultBaseURL, now)
+	// $24 spent, then $1/h for the 16 days left.
+	r := evaluateBudget(budget, scoped, nil, launch, 1, now)
+	if r.ProjectedMonthEndUSD != 24+24*16 || len(r.Violations) != 0 {
+		t.Errorf("report = %+v", r)
+	}
+	if r := evaluateBudget(budget, history, nil, launch, 1, now); len(r.Violations) != 1 {
+		t.Errorf("without reconciling, i-1 counts until now: %+v", r)
+	}
+}
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"fmt"
+
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// newAPIClient builds an API client from the root persistent flags: the
+// endpoint from --api-url and the retry policy from --max-retries and
+// --retry-budget. Without --api-key or LAMBDA_API_KEY the key comes from
+// the active profile.
+func newAPIClient(cmd *cobra.Command) (*api.Client, error) {
+	flags := cmd.Root().PersistentFlags()
+	apiKey, _ := flags.GetString("api-key")
+	apiURL, _ := flags.GetString("api-url")
+	if apiKey == "" {
+		_, profile, err := ActiveProfile(cmd)
+		if err != nil {
+			return nil, err
+		}
+		if apiKey, err = profile.ResolveAPIKey(); err != nil {
+			return nil, err
+		}
+	}
+	if apiKey == "" {
+		log.Warn("API key not provided via --api-key, LAMBDA_API_KEY or the active config profile.")
+	}
+
+	policy := api.DefaultRetryPolicy()
+	if maxRetries, err := flags.GetInt("max-retries"); err == nil {
+		if maxRetries < 0 {
+			return nil, fmt.Errorf("--max-retries must be >= 0, got %d", maxRetries)
+		}
+		policy.MaxAttempts = maxRetries + 1
+	}
+	if budget, err := flags.GetDuration("retry-budget"); err == nil {
+		policy.Budget = budget
+	}
+	return api.NewClient(apiKey,
+		api.WithBaseURL(apiURL),
+		api.WithRetryPolicy(policy),
+		api.WithLogger(log.StandardLogger()),
+		api.WithUserAgent("detach-lambda-cli/0.1"),
+	)
+}
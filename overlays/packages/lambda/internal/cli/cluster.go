@@ -0,0 +1,736 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/sshutil"
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/state"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+//go:embed cluster_bootstrap.sh.in
+var clusterBootstrapScriptTemplate string
+
+// torchrunPort is the rendezvous port written to torchrun.env.
+const torchrunPort = 29500
+
+var (
+	clusterNameRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+	// Cluster nodes are named <cluster>-node-<rank>. Membership comes from
+	// the local history, though: the name alone proves nothing.
+	clusterNodeRe = regexp.MustCompile(`^(.+)-node-([0-9]+)$`)
+)
+
+var ClusterCmd = &cobra.Command{
+	Use:   "cluster",
+	Short: "Manage multi-node clusters for distributed training",
+	Long: `Manage multi-node clusters for distributed training.
+
+A cluster is a set of instances of one type in one region, named
+<cluster>-node-<rank>. 'lm cluster create' launches them, records them as the
+cluster's nodes in the local history (see 'lm list'), lets the nodes SSH into
+each other and writes the files distributed launchers expect to
+~/.lm/cluster/<cluster>/ on every node:
+
+  hosts          the /etc/hosts entries for the nodes (also installed)
+  hostfile       an MPI/DeepSpeed hostfile with one slot per GPU
+  torchrun.env   MASTER_ADDR, NNODES, NODE_RANK and the rendezvous settings`,
+}
+
+// clusterNode is one instance of a cluster.
+type clusterNode struct {
+	Rank     int          `json:"rank"`
+	Instance api.Instance `json:"instance"`
+}
+
+// Addr is the address other nodes use to reach n.
+func (n clusterNode) Addr() string {
+	if n.Instance.PrivateIP != "" {
+		return n.Instance.PrivateIP
+	}
+	return n.Instance.IP
+}
+
+// clusterView is a cluster as shown by list.
+type clusterView struct {
+	Name         string        `json:"name"`
+	InstanceType string        `json:"instance_type"`
+	Region       string        `json:"region"`
+	Nodes        []clusterNode `json:"nodes"`
+}
+
+func clusterNodeName(cluster string, rank int) string {
+	return fmt.Sprintf("%s-node-%d", cluster, rank)
+}
+
+// groupClusters collects the instances recorded as cluster nodes in history
+// by cluster, ignoring terminated ones. Nodes are sorted by rank.
+func groupClusters(instances []api.Instance, history map[string]*state.Instance) map[string][]clusterNode {
+	clusters := make(map[string][]clusterNode)
+	for _, inst := range instances {
+		if inst.Status == "terminated" {
+			continue
+		}
+		rec, ok := history[inst.ID]
+		if !ok || rec.Cluster == "" {
+			continue
+		}
+		clusters[rec.Cluster] = append(clusters[rec.Cluster], clusterNode{Rank: rec.ClusterRank, Instance: inst})
+	}
+	for _, nodes := range clusters {
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].Rank < nodes[j].Rank })
+	}
+	return clusters
+}
+
+// findCluster returns the nodes of the named cluster.
+func findCluster(ctx context.Context, client *api.Client, name string) ([]clusterNode, error) {
+	instances, err := client.ListInstances(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching instances: %w", err)
+	}
+	nodes := groupClusters(instances, loadHistory())[name]
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("cluster '%s' not found", name)
+	}
+	return nodes, nil
+}
+
+// clusterFiles are the files shared by every node of a cluster. torchrun.env
+// differs per node in NODE_RANK and comes from torchrunEnv.
+type clusterFiles struct {
+	Hosts    string
+	Hostfile string
+}
+
+func renderClusterFiles(nodes []clusterNode, cluster string, gpusPerNode int) clusterFiles {
+	var hosts, hostfile strings.Builder
+	for _, n := range nodes {
+		name := clusterNodeName(cluster, n.Rank)
+		fmt.Fprintf(&hosts, "%s\t%s\n", n.Addr(), name)
+		fmt.Fprintf(&hostfile, "%s slots=%d\n", name, gpusPerNode)
+	}
+	return clusterFiles{Hosts: hosts.String(), Hostfile: hostfile.String()}
+}
+
+// torchrunEnv renders the torchrun rendezvous settings for the node of the
+// given rank. Rank 0 is the rendezvous host.
+func torchrunEnv(nodes []clusterNode, cluster string, gpusPerNode, rank int) string {
+	master := nodes[0].Addr()
+	var b strings.Builder
+	fmt.Fprintf(&b, "# torchrun settings for cluster %s, written by lm\n", cluster)
+	fmt.Fprintf(&b, "MASTER_ADDR=%s\n", master)
+	fmt.Fprintf(&b, "MASTER_PORT=%d\n", torchrunPort)
+	fmt.Fprintf(&b, "NNODES=%d\n", len(nodes))
+	fmt.Fprintf(&b, "NPROC_PER_NODE=%d\n", gpusPerNode)
+	fmt.Fprintf(&b, "NODE_RANK=%d\n", rank)
+	fmt.Fprintf(&b, "WORLD_SIZE=%d\n", len(nodes)*gpusPerNode)
+	fmt.Fprintf(&b, "RDZV_ID=%s\n", cluster)
+	fmt.Fprintf(&b, "RDZV_BACKEND=c10d\n")
+	fmt.Fprintf(&b, "RDZV_ENDPOINT=%s:%d\n", master, torchrunPort)
+	return b.String()
+}
+
+type clusterBootstrapParams struct {
+	Name  string
+	Dir   string
+	User  string
+	Hosts []string
+}
+
+func renderBootstrapScript(params clusterBootstrapParams) ([]byte, error) {
+	tmpl, err := template.New("clusterBootstrap").Parse(clusterBootstrapScriptTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cluster bootstrap template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, params); err != nil {
+		return nil, fmt.Errorf("failed to execute cluster bootstrap template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// clusterSSH holds what is needed to reach the nodes from this machine.
+type clusterSSH struct {
+	KeyPath string
+	KeyName string
+	User    string
+}
+
+func (c clusterSSH) dial(ctx context.Context, n clusterNode) (*ssh.Client, error) {
+	if n.Instance.Status != "active" || n.Instance.IP == "" {
+		return nil, fmt.Errorf("node %s is not reachable (status: '%s')", n.Instance.Name, n.Instance.Status)
+	}
+	return dialInstance(ctx, &n.Instance, c.KeyPath, c.User, c.KeyName)
+}
+
+// forEachNode runs fn on every node concurrently with an SSH connection to
+// it. The errors are in the order of nodes.
+func forEachNode(ctx context.Context, conn clusterSSH, nodes []clusterNode, fn func(ctx context.Context, client *ssh.Client, n clusterNode) error) []error {
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, err := conn.dial(ctx, n)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer client.Close()
+			errs[i] = fn(ctx, client, n)
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// nodeErrors joins the per-node errors of forEachNode, or returns nil.
+func nodeErrors(nodes []clusterNode, errs []error) error {
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			log.Errorf("Node %s: %v", nodes[i].Instance.Name, err)
+			failed = append(failed, nodes[i].Instance.Name)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d node(s) failed: %s", len(failed), len(nodes), strings.Join(failed, ", "))
+}
+
+// bootstrapCluster gives the nodes a shared intra-cluster key pair, writes
+// the hosts, hostfile and torchrun files and checks that every node can SSH
+// into every other node.
+func bootstrapCluster(ctx context.Context, conn clusterSSH, cluster string, nodes []clusterNode, gpusPerNode int) error {
+	privatePEM, authorizedKey, err := generateEd25519("lm-cluster-" + cluster)
+	if err != nil {
+		return err
+	}
+	files := renderClusterFiles(nodes, cluster, gpusPerNode)
+	dir := "~/.lm/cluster/" + cluster
+	params := clusterBootstrapParams{Name: cluster, Dir: "$HOME/.lm/cluster/" + cluster, User: conn.User}
+	for _, n := range nodes {
+		params.Hosts = append(params.Hosts, clusterNodeName(cluster, n.Rank), n.Addr())
+	}
+	script, err := renderBootstrapScript(params)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Distributing the intra-cluster key and host files to %d node(s)", len(nodes))
+	errs := forEachNode(ctx, conn, nodes, func(ctx context.Context, client *ssh.Client, n clusterNode) error {
+		copies := []struct {
+			content []byte
+			name    string
+			perms   string
+		}{
+			{privatePEM, "id_ed25519", "0600"},
+			{authorizedKey, "id_ed25519.pub", "0644"},
+			{[]byte(files.Hosts), "hosts", "0644"},
+			{[]byte(files.Hostfile), "hostfile", "0644"},
+			{[]byte(torchrunEnv(nodes, cluster, gpusPerNode, n.Rank)), "torchrun.env", "0644"},
+			{script, "bootstrap.sh", "0755"},
+		}
+		for _, c := range copies {
+			if err := sshutil.CopyContentToRemote(ctx, client, c.content, dir+"/"+c.name, c.perms); err != nil {
+				return fmt.Errorf("failed to copy %s: %w", c.name, err)
+			}
+		}
+		return sshutil.StreamRemoteCommand(ctx, client, "bash "+dir+"/bootstrap.sh", io.Discard, os.Stderr)
+	})
+	if err := nodeErrors(nodes, errs); err != nil {
+		return fmt.Errorf("bootstrapping cluster '%s': %w", cluster, err)
+	}
+
+	log.Info("Verifying node-to-node SSH")
+	var peers []string
+	for _, n := range nodes {
+		peers = append(peers, clusterNodeName(cluster, n.Rank))
+	}
+	verify := fmt.Sprintf(`rc=0; for h in %s; do ssh -o BatchMode=yes -o ConnectTimeout=10 "$h" true </dev/null >/dev/null 2>&1 || { echo "$h"; rc=1; }; done; exit $rc`, strings.Join(peers, " "))
+	errs = forEachNode(ctx, conn, nodes, func(ctx context.Context, client *ssh.Client, n clusterNode) error {
+		var out bytes.Buffer
+		if err := sshutil.StreamRemoteCommand(ctx, client, verify, &out, io.Discard); err != nil {
+			if unreachable := strings.Fields(out.String()); len(unreachable) > 0 {
+				return fmt.Errorf("cannot SSH to %s", strings.Join(unreachable, ", "))
+			}
+			return err
+		}
+		return nil
+	})
+	if err := nodeErrors(nodes, errs); err != nil {
+		return fmt.Errorf("verifying node-to-node SSH in cluster '%s': %w", cluster, err)
+	}
+	log.Infof("All %d node(s) can reach each other over SSH", len(nodes))
+	return nil
+}
+
+// prefixWriter prefixes every line written to w, so the output of several
+// nodes can share a terminal. Partial lines are held until they complete or
+// Flush is called.
+type prefixWriter struct {
+	prefix string
+	w      io.Writer
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		if err := p.emit(p.buf[:i+1]); err != nil {
+			return len(b), err
+		}
+		p.buf = p.buf[i+1:]
+	}
+}
+
+// Flush writes a trailing partial line, if any.
+func (p *prefixWriter) Flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	line := append(p.buf, '\n')
+	p.buf = nil
+	return p.emit(line)
+}
+
+func (p *prefixWriter) emit(line []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := fmt.Fprintf(p.w, "%s%s", p.prefix, line)
+	return err
+}
+
+var clusterCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Launch and bootstrap a multi-node cluster",
+	Long: `Launch --nodes instances of --type in one region and bootstrap them for
+distributed training: generate an intra-cluster SSH key pair, install it on
+every node, write the hosts, hostfile and torchrun.env files and check that
+every node can SSH into every other node.
+
+--type accepts the same comma-separated fallbacks as 'lm create'. All nodes are
+launched together, so the first type and region with room for the whole
+cluster is used.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		if !clusterNameRe.MatchString(name) {
+			return fmt.Errorf("invalid cluster name '%s'. Use lowercase letters, digits and dashes", name)
+		}
+		jsonOutput, err := jsonOutputFlag(cmd)
+		if err != nil {
+			return err
+		}
+		spec, _ := cmd.Flags().GetString("type")
+		if spec == "" {
+			return fmt.Errorf("--type is required, e.g. --type 8xH100_SXM5")
+		}
+		numNodes, _ := cmd.Flags().GetInt("nodes")
+		if numNodes < 1 {
+			return fmt.Errorf("--nodes must be at least 1, got %d", numNodes)
+		}
+		onInterrupt, _ := cmd.Flags().GetString("on-interrupt")
+		switch onInterrupt {
+		case onInterruptPrompt, onInterruptTerminate, onInterruptKeep:
+		default:
+			return fmt.Errorf("invalid --on-interrupt policy: %s. Supported policies: 'prompt', 'terminate', 'keep'", onInterrupt)
+		}
+		profileName, profile, err := ActiveProfile(cmd)
+		if err != nil {
+			return err
+		}
+		flags := cmd.Root().PersistentFlags()
+		sshKeyName, _ := flags.GetString("ssh-key-name")
+		sshKeyPath, _ := flags.GetString("ssh-key-path")
+		hostKeyWait, _ := cmd.Flags().GetDuration("host-key-wait")
+		if hostKeyWait <= 0 {
+			return fmt.Errorf("--host-key-wait must be positive, got %s; the nodes' host keys are needed to bootstrap them", hostKeyWait)
+		}
+		sshTimeout, _ := cmd.Flags().GetDuration("ssh-timeout")
+		if sshTimeout <= 0 {
+			return fmt.Errorf("--ssh-timeout must be positive, got %s", sshTimeout)
+		}
+		signer, err := sshutil.LoadSSHKey(sshKeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to get SSH key from '%s': %w", sshKeyPath, err)
+		}
+
+		candidates, err := parseSpecs(spec)
+		if err != nil {
+			return err
+		}
+		regions, _ := cmd.Flags().GetString("regions")
+		if regions == "" {
+			regions = strings.Join(profile.Regions, ",")
+		}
+		patterns, err := parseRegionPatterns(regions)
+		if err != nil {
+			return err
+		}
+		if len(patterns) == 0 {
+			return fmt.Errorf("no regions to choose from. Pass --regions or set 'regions' in the profile")
+		}
+
+		ctx := cmd.Context()
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error initializing API client: %w", err)
+		}
+		instances, err := client.ListInstances(ctx)
+		if err != nil {
+			return fmt.Errorf("error fetching instances: %w", err)
+		}
+		if existing := groupClusters(instances, loadHistory())[name]; len(existing) > 0 {
+			return fmt.Errorf("cluster '%s' already exists with %d node(s). Delete it with 'lm cluster delete %s' or pick another name", name, len(existing), name)
+		}
+		instanceTypes, err := client.ListInstanceTypes(ctx)
+		if err != nil {
+			return fmt.Errorf("error fetching instance types: %w", err)
+		}
+		if err := checkInstanceTypes(instanceTypes, candidates); err != nil {
+			return err
+		}
+
+		budget, err := newBudgetGuard(cmd, client, instanceTypes, numNodes)
+		if err != nil {
+			return err
+		}
+
+		// 1. Launch all nodes in one request so they land in the same region
+		waitForCapacity, _ := cmd.Flags().GetBool("wait-for-capacity")
+		waitTimeout, _ := cmd.Flags().GetDuration("timeout")
+		waitPoll, _ := cmd.Flags().GetDuration("poll")
+		baseName := name + "-node"
+		choice, instanceIDs, err := launchWithPlacement(ctx, client, instanceTypes, launchPlan{
+			Spec:       spec,
+			Candidates: candidates,
+			Patterns:   patterns,
+			Count:      numNodes,
+			SSHKeyName: sshKeyName,
+			Name:       func(*placement) string { return baseName },
+			FileSystem: func(region string) (string, error) {
+				return profile.FilesystemName(profileName, region)
+			},
+			Check: func(choice *placement) error {
+				return budget.check(ctx, choice)
+			},
+			WaitForCapacity: waitForCapacity,
+			WaitTimeout:     waitTimeout,
+			Poll:            waitPoll,
+		})
+		if err != nil {
+			return err
+		}
+		log.Infof("Cluster '%s' launch initiated with ID(s): %s. Waiting for the nodes to accept SSH", name, strings.Join(instanceIDs, ", "))
+		names := renameInstances(ctx, client, instanceIDs, baseName, func(i int) string {
+			return clusterNodeName(name, i)
+		})
+		recordLaunch(instanceIDs, names, choice, instanceTypes[choice.InstanceType].InstanceType.PriceCentsPerHour, profileName, client.BaseURL(), "")
+		recordClusterNodes(name, instanceIDs, names)
+		budget.recordOverride(instanceIDs)
+
+		// 2. Wait until every node accepts SSH; a partial cluster is of no use
+		results := waitForActive(ctx, client, instanceIDs, readiness{
+			HostKeyWait: hostKeyWait,
+			Signer:      signer,
+			User:        profile.RemoteUser,
+			SSHTimeout:  sshTimeout,
+		})
+		var nodes []clusterNode
+		var running []api.Instance
+		var failed int
+		for i, res := range results {
+			if !res.Terminal {
+				running = append(running, res.known(names[res.ID]))
+			}
+			if res.Err != nil {
+				if ctx.Err() == nil {
+					log.Errorf("Node '%s' (ID: %s) failed: %v", names[res.ID], res.ID, res.Err)
+				}
+				failed++
+				continue
+			}
+			nodes = append(nodes, clusterNode{Rank: i, Instance: res.Instance})
+		}
+		if ctx.Err() != nil {
+			return handleAbandonedLaunch(client, running, onInterrupt, fmt.Errorf("interrupted while waiting for cluster '%s': %w", name, ctx.Err()))
+		}
+		if failed > 0 {
+			cause := fmt.Errorf("%d of %d node(s) of cluster '%s' did not become ready", failed, len(results), name)
+			if len(running) == 0 {
+				return cause
+			}
+			return handleAbandonedLaunch(client, running, onInterrupt, cause)
+		}
+
+		// 3. Bootstrap the nodes, checking the host keys pinned while waiting
+		gpusPerNode := instanceTypes[choice.InstanceType].InstanceType.Specs.Gpus
+		if gpusPerNode == 0 {
+			gpusPerNode, _ = strconv.Atoi(choice.Candidate.NumGPUs)
+		}
+		conn := clusterSSH{KeyPath: sshKeyPath, KeyName: sshKeyName, User: profile.RemoteUser}
+		if err := bootstrapCluster(ctx, conn, name, nodes, gpusPerNode); err != nil {
+			log.Warnf("The nodes of cluster '%s' are running. Delete them with: lm cluster delete %s", name, name)
+			return err
+		}
+
+		if jsonOutput {
+			return printJSON(map[string]any{
+				"cluster": clusterView{
+					Name:         name,
+					InstanceType: choice.InstanceType,
+					Region:       choice.Region,
+					Nodes:        nodes,
+				},
+				"choice": choice,
+				"files":  "~/.lm/cluster/" + name,
+			})
+		}
+		log.Println("--------------------------------------------------")
+		log.Infof("Cluster '%s' is ready: %d x %s in %s", name, len(nodes), choice.InstanceType, choice.Region)
+		for _, n := range nodes {
+			log.Infof("  %s  %s (private %s)  host key %s", names[n.Instance.ID], n.Instance.IP, n.Addr(), results[n.Rank].HostKeyFingerprint)
+		}
+		log.Infof("Files are in ~/.lm/cluster/%s on every node.", name)
+		log.Infof("To run a command on every node:")
+		log.Infof("  lm cluster exec %s -- nvidia-smi -L", name)
+		return nil
+	},
+}
+
+var clusterListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List clusters and their nodes",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonOutput, err := jsonOutputFlag(cmd)
+		if err != nil {
+			return err
+		}
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error initializing API client: %w", err)
+		}
+		instances, err := client.ListInstances(cmd.Context())
+		if err != nil {
+			return fmt.Errorf("error fetching instances: %w", err)
+		}
+		clusters := groupClusters(instances, loadHistory())
+		names := make([]string, 0, len(clusters))
+		for name := range clusters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		views := make([]clusterView, 0, len(names))
+		for _, name := range names {
+			nodes := clusters[name]
+			views = append(views, clusterView{
+				Name:         name,
+				InstanceType: nodes[0].Instance.InstanceType.Name,
+				Region:       nodes[0].Instance.Region.Name,
+				Nodes:        nodes,
+			})
+		}
+
+		if jsonOutput {
+			return printJSON(views)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tNODES\tTYPE\tREGION\tSTATUS\tHEAD_IP")
+		for _, v := range views {
+			head := v.Nodes[0].Instance.IP
+			if head == "" {
+				head = "-"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", v.Name, len(v.Nodes), v.InstanceType, v.Region, clusterStatus(v.Nodes), head)
+		}
+		return w.Flush()
+	},
+}
+
+// clusterStatus summarizes node statuses, e.g. "active" or "active:3,booting:1".
+func clusterStatus(nodes []clusterNode) string {
+	counts := make(map[string]int)
+	for _, n := range nodes {
+		counts[n.Instance.Status]++
+	}
+	if len(counts) == 1 {
+		return nodes[0].Instance.Status
+	}
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for i, status := range statuses {
+		statuses[i] = fmt.Sprintf("%s:%d", status, counts[status])
+	}
+	return strings.Join(statuses, ",")
+}
+
+var clusterDeleteCmd = &cobra.Command{
+	Use:               "delete <name>",
+	Short:             "Terminate every node of a cluster",
+	Aliases:           []string{"rm"},
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeClusterNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		jsonOutput, err := jsonOutputFlag(cmd)
+		if err != nil {
+			return err
+		}
+		yes, _ := cmd.Flags().GetBool("yes")
+		ctx := cmd.Context()
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error initializing API client: %w", err)
+		}
+		nodes, err := findCluster(ctx, client, name)
+		if err != nil {
+			return err
+		}
+		ids := make([]string, len(nodes))
+		descriptions := make([]string, len(nodes))
+		for i, n := range nodes {
+			ids[i] = n.Instance.ID
+			descriptions[i] = fmt.Sprintf("  - %s (ID: %s, IP: %s)", n.Instance.Name, n.Instance.ID, n.Instance.IP)
+		}
+		if !yes {
+			if !term.IsTerminal(int(os.Stdin.Fd())) {
+				return fmt.Errorf("not running interactively; pass --yes to terminate the %d node(s) of cluster '%s'", len(nodes), name)
+			}
+			log.Infof("Cluster '%s' has %d node(s):\n%s", name, len(nodes), strings.Join(descriptions, "\n"))
+			if !confirm(fmt.Sprintf("Terminate the %d node(s) of cluster '%s'?", len(nodes), name)) {
+				return fmt.Errorf("cluster '%s' was not deleted", name)
+			}
+		}
+		terminated, err := client.Terminate(ctx, ids...)
+		if err != nil {
+			return fmt.Errorf("error terminating cluster '%s' (instances %s): %w", name, strings.Join(ids, " "), err)
+		}
+		done := make(map[string]bool, len(terminated))
+		for _, inst := range terminated {
+			done[inst.ID] = true
+		}
+		var confirmed, unconfirmed []string
+		for _, n := range nodes {
+			if !done[n.Instance.ID] {
+				unconfirmed = append(unconfirmed, n.Instance.ID)
+				continue
+			}
+			confirmed = append(confirmed, n.Instance.ID)
+			forgetHostKey(n.Instance)
+			recordTerminated(n.Instance)
+		}
+
+		if jsonOutput {
+			if err := printJSON(map[string]any{
+				"cluster":      name,
+				"instance_ids": confirmed,
+				"unconfirmed":  unconfirmed,
+				"action":       "terminate",
+				"status":       "initiated",
+			}); err != nil {
+				return err
+			}
+		} else {
+			log.Infof("Termination initiated for %d of the %d node(s) of cluster '%s'.", len(confirmed), len(nodes), name)
+		}
+		if len(unconfirmed) > 0 {
+			return fmt.Errorf("failed to confirm termination of the node(s) %s of cluster '%s'", strings.Join(unconfirmed, " "), name)
+		}
+		return nil
+	},
+}
+
+var clusterExecCmd = &cobra.Command{
+	Use:   "exec <name> -- <command> [args...]",
+	Short: "Run a command on every node of a cluster",
+	Long: `Run a command on every node of a cluster at once. Each output line is
+prefixed with the node name. Host keys are checked against the keys pinned by
+'lm cluster create' and ~/.ssh/known_hosts.`,
+	Args:              cobra.MinimumNArgs(2),
+	ValidArgsFunction: completeClusterNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, command := args[0], strings.Join(args[1:], " ")
+		_, profile, err := ActiveProfile(cmd)
+		if err != nil {
+			return err
+		}
+		flags := cmd.Root().PersistentFlags()
+		sshKeyName, _ := flags.GetString("ssh-key-name")
+		sshKeyPath, _ := flags.GetString("ssh-key-path")
+
+		ctx := cmd.Context()
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error initializing API client: %w", err)
+		}
+		nodes, err := findCluster(ctx, client, name)
+		if err != nil {
+			return err
+		}
+
+		conn := clusterSSH{KeyPath: sshKeyPath, KeyName: sshKeyName, User: profile.RemoteUser}
+		var mu sync.Mutex
+		errs := forEachNode(ctx, conn, nodes, func(ctx context.Context, client *ssh.Client, n clusterNode) error {
+			prefix := fmt.Sprintf("[%s] ", n.Instance.Name)
+			stdout := &prefixWriter{prefix: prefix, w: os.Stdout, mu: &mu}
+			stderr := &prefixWriter{prefix: prefix, w: os.Stderr, mu: &mu}
+			err := sshutil.StreamRemoteCommand(ctx, client, command, stdout, stderr)
+			stdout.Flush()
+			stderr.Flush()
+			return err
+		})
+		return nodeErrors(nodes, errs)
+	},
+}
+
+func init() {
+	clusterCreateCmd.Flags().String("type", "", "Instance type of every node as <gpus>x<type>, comma-separated for fallbacks (required)")
+	clusterCreateCmd.Flags().Int("nodes", 2, "Number of nodes")
+	clusterCreateCmd.Flags().String("regions", "", "Comma-separated regions or globs to try in order (default from the active profile)")
+	clusterCreateCmd.Flags().Bool("wait-for-capacity", false, "Keep polling until the whole cluster fits in an allowed region, then launch")
+	clusterCreateCmd.Flags().Duration("timeout", 6*time.Hour, "Give up waiting for capacity after this long, 0 to wait forever (with --wait-for-capacity)")
+	clusterCreateCmd.Flags().Duration("poll", 20*time.Second, "How often to check for capacity (with --wait-for-capacity)")
+	clusterCreateCmd.Flags().Duration("host-key-wait", 3*time.Minute, "How long to wait for each node's SSH server to pin its host key")
+	clusterCreateCmd.Flags().Duration("ssh-timeout", 5*time.Minute, "How long to wait for an SSH login once a node is active")
+	clusterCreateCmd.Flags().String("override-budget", "", "Launch even if it exceeds the budget in the config file; the reason is recorded in the local history")
+	clusterCreateCmd.Flags().String("on-interrupt", onInterruptPrompt, "What to do with the nodes if create is interrupted or a node fails. One of: prompt|terminate|keep")
+	clusterDeleteCmd.Flags().BoolP("yes", "y", false, "Terminate the nodes without asking for confirmation")
+	clusterCreateCmd.RegisterFlagCompletionFunc("type", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return completeGpuSpec(cmd, nil, toComplete)
+	})
+
+	ClusterCmd.AddCommand(clusterCreateCmd)
+	ClusterCmd.AddCommand(clusterListCmd)
+	ClusterCmd.AddCommand(clusterDeleteCmd)
+	ClusterCmd.AddCommand(clusterExecCmd)
+}
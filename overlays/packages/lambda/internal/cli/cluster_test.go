@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/fakecloud"
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/state"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+)
+
+func testClusterNodes() []clusterNode {
+	mk := func(rank int, ip, privateIP string) clusterNode {
+		inst := api.Instance{Name: clusterNodeName("train", rank), Status: "active", IP: ip, PrivateIP: privateIP}
+		return clusterNode{Rank: rank, Instance: inst}
+	}
+	return []clusterNode{mk(0, "192.0.2.10", "10.0.0.10"), mk(1, "192.0.2.11", "")}
+}
+
+func TestGroupClusters(t *testing.T) {
+	instances := []api.Instance{
+		{ID: "a", Name: "train-node-1", Status: "active"},
+		{ID: "b", Name: "train", Status: "booting"},
+		{ID: "c", Name: "train-node-2", Status: "terminated"},
+		{ID: "d", Name: "my-test-node-0", Status: "active"},
+		{ID: "e", Name: "generic-1_a10-abcd1234-1", Status: "active"},
+		{ID: "f", Name: "stray-node-0", Status: "active"},
+	}
+	history := state.Fold([]state.Event{
+		{Kind: state.ClusterNode, InstanceID: "a", Cluster: "train", ClusterRank: 1},
+		// b kept the launch name because the rename to train-node-0 failed
+		{Kind: state.ClusterNode, InstanceID: "b", Cluster: "train", ClusterRank: 0},
+		{Kind: state.ClusterNode, InstanceID: "c", Cluster: "train", ClusterRank: 2},
+		{Kind: state.ClusterNode, InstanceID: "d", Cluster: "my-test", ClusterRank: 0},
+		{Kind: state.Launched, InstanceID: "f", InstanceName: "stray-node-0"},
+	})
+	clusters := groupClusters(instances, history)
+	if len(clusters) != 2 {
+		t.Fatalf("got %d clusters, want 2: %v", len(clusters), clusters)
+	}
+	train := clusters["train"]
+	if len(train) != 2 || train[0].Instance.ID != "b" || train[1].Instance.ID != "a" {
+		t.Errorf("train nodes = %+v, want b then a", train)
+	}
+	if got := clusterStatus(train); got != "active:1,booting:1" {
+		t.Errorf("clusterStatus = %q", got)
+	}
+	if len(clusters["my-test"]) != 1 {
+		t.Errorf("my-test nodes = %+v", clusters["my-test"])
+	}
+}
+
+func TestClusterDelete(t *testing.T) {
+	httpSrv := httptest.NewServer(fakecloud.New(fakecloud.Options{SSHKeyNames: []string{"laptop"}}))
+	defer httpSrv.Close()
+	apiURL := httpSrv.URL + fakecloud.BasePath
+	root := newTestRoot(t, apiURL, ClusterCmd)
+	t.Cleanup(func() { clusterDeleteCmd.Flags().Set("yes", "false") })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	client, err := api.NewClient("test-key", api.WithBaseURL(apiURL))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	ids, err := client.Launch(ctx, api.LaunchRequest{RegionName: "us-east-1", InstanceTypeName: "gpu_1x_a10", SSHKeyNames: []string{"laptop"}, Name: "train", Quantity: 2})
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	// Not a member despite the name.
+	stray, err := client.Launch(ctx, api.LaunchRequest{RegionName: "us-east-1", InstanceTypeName: "gpu_1x_a10", SSHKeyNames: []string{"laptop"}, Name: "train-node-2"})
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	recordClusterNodes("train", ids, map[string]string{ids[0]: "train-node-0", ids[1]: "train-node-1"})
+
+	root.SetArgs([]string{"cluster", "delete", "train"})
+	if err := root.ExecuteContext(ctx); err == nil || !strings.Contains(err.Error(), "--yes") {
+		t.Fatalf("lm cluster delete without a terminal or --yes: %v", err)
+	}
+	root.SetArgs([]string{"cluster", "delete", "train", "--yes"})
+	if err := root.ExecuteContext(ctx); err != nil {
+		t.Fatalf("lm cluster delete --yes: %v", err)
+	}
+
+	instances, err := client.ListInstances(ctx)
+	if err != nil {
+		t.Fatalf("ListInstances: %v", err)
+	}
+	history := loadHistory()
+	for _, inst := range instances {
+		terminated := inst.Status == "terminated" || inst.Status == "terminating"
+		if inst.ID == stray[0] {
+			if _, recorded := history[inst.ID]; terminated || recorded {
+				t.Errorf("%s is not a node of train but was terminated", inst.Name)
+			}
+			continue
+		}
+		if rec := history[inst.ID]; !terminated || rec == nil || rec.TerminatedAt.IsZero() {
+			t.Errorf("node %s: status %s, record %+v; want terminated", inst.Name, inst.Status, rec)
+		}
+	}
+}
+
+func TestRenderClusterFiles(t *testing.T) {
+	nodes := testClusterNodes()
+	files := renderClusterFiles(nodes, "train", 8)
+	if want := "10.0.0.10\ttrain-node-0\n192.0.2.11\ttrain-node-1\n"; files.Hosts != want {
+		t.Errorf("Hosts = %q, want %q", files.Hosts, want)
+	}
+	if want := "train-node-0 slots=8\ntrain-node-1 slots=8\n"; files.Hostfile != want {
+		t.Errorf("Hostfile = %q, want %q", files.Hostfile, want)
+	}
+
+	env := torchrunEnv(nodes, "train", 8, 1)
+	for _, line := range []string{"MASTER_ADDR=10.0.0.10", "NNODES=2", "NPROC_PER_NODE=8", "NODE_RANK=1", "WORLD_SIZE=16", "RDZV_ENDPOINT=10.0.0.10:29500"} {
+		if !strings.Contains(env, line+"\n") {
+			t.Errorf("torchrun env missing %q:\n%s", line, env)
+		}
+	}
+}
+
+func TestRenderBootstrapScript(t *testing.T) {
+	script, err := renderBootstrapScript(clusterBootstrapParams{
+		Name:  "train",
+		Dir:   "$HOME/.lm/cluster/train",
+		User:  "ubuntu",
+		Hosts: []string{"train-node-0", "10.0.0.10"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(script, []byte("Host train-node-0 10.0.0.10\n")) {
+		t.Errorf("script has no Host line:\n%s", script)
+	}
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not installed")
+	}
+	path := filepath.Join(t.TempDir(), "bootstrap.sh")
+	if err := os.WriteFile(path, script, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("bash", "-n", path).CombinedOutput(); err != nil {
+		t.Errorf("bash -n: %v\n%s", err, out)
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	w := &prefixWriter{prefix: "[n0] ", w: &buf, mu: &mu}
+	w.Write([]byte("one\ntw"))
+	w.Write([]byte("o\nthree"))
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "[n0] one\n[n0] two\n[n0] three\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
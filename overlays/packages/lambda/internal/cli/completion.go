@@ -6,7 +6,6 @@ import (
 	"regexp"
 	"strings"
 
-	api "github.com/aarnphm/detachtools/overlays/packages/lambda/internal/apiclient"
 	"github.com/spf13/cobra"
 )
 
@@ -17,20 +16,19 @@ func completeInstanceNames(cmd *cobra.Command, args []string, toComplete string)
 		return nil, cobra.ShellCompDirectiveNoFileComp
 	}
 
-	apiKey, _ := cmd.Root().PersistentFlags().GetString("api-key")
-	client, err := api.NewAPIClient(apiKey)
+	ctx := cmd.Context()
+	client, err := newAPIClient(cmd)
 	if err != nil {
 		return nil, cobra.ShellCompDirectiveError
 	}
 
-	var instancesResp api.InstancesResponse
-	err = client.Request("GET", "/instances", nil, &instancesResp)
+	instances, err := client.ListInstances(ctx)
 	if err != nil {
 		return nil, cobra.ShellCompDirectiveError
 	}
 
 	var names []string
-	for _, inst := range instancesResp.Data {
+	for _, inst := range instances {
 		// Simple prefix matching for completion
 		if strings.HasPrefix(inst.Name, toComplete) {
 			names = append(names, inst.Name)
@@ -40,6 +38,58 @@ func completeInstanceNames(cmd *cobra.Command, args []string, toComplete string)
 	return names, cobra.ShellCompDirectiveNoFileComp
 }
 
+// Function to fetch SSH key names for completion
+func completeSSHKeyNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	client, err := newAPIClient(cmd)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	keys, err := client.ListSSHKeys(cmd.Context())
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	var names []string
+	for _, key := range keys {
+		if strings.HasPrefix(key.Name, toComplete) {
+			names = append(names, key.Name)
+		}
+	}
+
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// Function to fetch file system names for completion
+func completeFileSystemNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	client, err := newAPIClient(cmd)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	fileSystems, err := client.ListFileSystems(cmd.Context())
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	var names []string
+	for _, fs := range fileSystems {
+		if strings.HasPrefix(fs.Name, toComplete) {
+			names = append(names, fs.Name)
+		}
+	}
+
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
 // Function to fetch GPU types for completion
 func completeGpuSpec(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 	// Only complete the first argument
@@ -47,14 +97,19 @@ func completeGpuSpec(cmd *cobra.Command, args []string, toComplete string) ([]st
 		return nil, cobra.ShellCompDirectiveNoFileComp
 	}
 
-	apiKey, _ := cmd.Root().PersistentFlags().GetString("api-key")
-	client, err := api.NewAPIClient(apiKey)
+	// Only the last entry of a comma-separated fallback list is completed
+	completed := ""
+	if i := strings.LastIndex(toComplete, ","); i >= 0 {
+		completed, toComplete = toComplete[:i+1], toComplete[i+1:]
+	}
+
+	ctx := cmd.Context()
+	client, err := newAPIClient(cmd)
 	if err != nil {
 		return nil, cobra.ShellCompDirectiveError
 	}
 
-	var typesResp api.InstanceTypesResponse
-	err = client.Request("GET", "/instance-types", nil, &typesResp)
+	instanceTypes, err := client.ListInstanceTypes(ctx)
 	if err != nil {
 		return nil, cobra.ShellCompDirectiveError
 	}
@@ -68,7 +123,7 @@ func completeGpuSpec(cmd *cobra.Command, args []string, toComplete string) ([]st
 
 	if toComplete == "" {
 		// If nothing is typed, suggest all possible specs
-		for name := range typesResp.Data {
+		for name := range instanceTypes {
 			matches := apiNameRe.FindStringSubmatch(name)
 			if len(matches) == 3 {
 				numGPUs := matches[1]
@@ -85,7 +140,7 @@ func completeGpuSpec(cmd *cobra.Command, args []string, toComplete string) ([]st
 			gpuPrefix := specMatches[2]
 			expectedInstancePrefix := fmt.Sprintf("gpu_%sx_%s", numGPUs, gpuPrefix)
 
-			for name := range typesResp.Data {
+			for name := range instanceTypes {
 				if strings.HasPrefix(name, expectedInstancePrefix) {
 					// Extract the full GPU type from the API name
 					apiMatches := apiNameRe.FindStringSubmatch(name)
@@ -99,7 +154,7 @@ func completeGpuSpec(cmd *cobra.Command, args []string, toComplete string) ([]st
 			// User typed only a number like "1" or "8"
 			numGPUs := numPartMatch[1]
 			expectedInstancePrefix := fmt.Sprintf("gpu_%sx_", numGPUs)
-			for name := range typesResp.Data {
+			for name := range instanceTypes {
 				if strings.HasPrefix(name, expectedInstancePrefix) {
 					apiMatches := apiNameRe.FindStringSubmatch(name)
 					if len(apiMatches) == 3 {
@@ -111,7 +166,7 @@ func completeGpuSpec(cmd *cobra.Command, args []string, toComplete string) ([]st
 			// User typed a number followed by 'x', like "1x"
 			numGPUs := numXPartMatch[1]
 			expectedInstancePrefix := fmt.Sprintf("gpu_%sx_", numGPUs)
-			for name := range typesResp.Data {
+			for name := range instanceTypes {
 				if strings.HasPrefix(name, expectedInstancePrefix) {
 					apiMatches := apiNameRe.FindStringSubmatch(name)
 					if len(apiMatches) == 3 {
@@ -124,6 +179,9 @@ func completeGpuSpec(cmd *cobra.Command, args []string, toComplete string) ([]st
 		// leading to default shell completion.
 	}
 
+	for i := range suggestions {
+		suggestions[i] = completed + suggestions[i]
+	}
 	return suggestions, cobra.ShellCompDirectiveNoFileComp
 }
 
@@ -188,3 +246,29 @@ PowerShell:
 		return nil
 	},
 }
+
+// Function to fetch cluster names for completion
+func completeClusterNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	client, err := newAPIClient(cmd)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	instances, err := client.ListInstances(cmd.Context())
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	var names []string
+	for name := range groupClusters(instances, loadHistory()) {
+		if strings.HasPrefix(name, toComplete) {
+			names = append(names, name)
+		}
+	}
+
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,196 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/configutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// loadConfig reads the config file selected by --config or LM_CONFIG.
+func loadConfig(cmd *cobra.Command) (string, *configutil.Config, error) {
+	explicit, _ := cmd.Root().PersistentFlags().GetString("config")
+	path, err := configutil.ConfigPath(explicit)
+	if err != nil {
+		return "", nil, fmt.Errorf("resolving config path: %w", err)
+	}
+	cfg, err := configutil.LoadConfig(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return path, cfg, nil
+}
+
+// explicitProfile returns the profile requested via --profile or LM_PROFILE.
+func explicitProfile(cmd *cobra.Command) string {
+	name, _ := cmd.Root().PersistentFlags().GetString("profile")
+	if name == "" {
+		name = configutil.GetEnvWithDefault("LM_PROFILE", "")
+	}
+	return name
+}
+
+// ActiveProfile returns the name and settings of the profile selected by
+// --profile, LM_PROFILE or the config file, merged over the built-in
+// defaults. Naming a profile that does not exist is an error.
+func ActiveProfile(cmd *cobra.Command) (string, configutil.Profile, error) {
+	_, cfg, err := loadConfig(cmd)
+	if err != nil {
+		return "", configutil.Profile{}, err
+	}
+	explicit := explicitProfile(cmd)
+	name := cfg.ProfileName(explicit)
+	profile, err := cfg.Profile(name, explicit != "" || cfg.CurrentProfile != "")
+	if err != nil {
+		return "", configutil.Profile{}, err
+	}
+	return name, profile, nil
+}
+
+var ConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "View and edit the lm configuration file",
+	Long: `View and edit the lm configuration file.
+
+The file lives at $XDG_CONFIG_HOME/lm/config.json (or ~/.config/lm/config.json)
+unless --config or LM_CONFIG point elsewhere. It holds named profiles; the
+active one is picked by --profile, LM_PROFILE, then 'current_profile'.
+Flags and environment variables take precedence over profile values.`,
+	// Skip the root hook so a broken or missing profile can still be fixed.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+}
+
+var configViewCmd = &cobra.Command{
+	Use:   "view",
+	Short: "Print the configuration file",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, cfg, err := loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+		for _, p := range cfg.Profiles {
+			if p.APIKey != "" {
+				p.APIKey = "<redacted>"
+			}
+		}
+		data, err := json.MarshalIndent(cfg, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal config: %w", err)
+		}
+		log.Debugf("Config file: %s", path)
+		fmt.Println(string(data))
+		return nil
+	},
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print the value of a key stored in the active profile",
+	Long: `Print the value of a key stored in the active profile, or its built-in
+default if the profile leaves it unset. Flags and environment variables that
+override the key at run time, such as --api-url or LAMBDA_API_URL, are not
+applied. api_key is redacted as in 'lm config view'.`,
+	Args: cobra.ExactArgs(1),
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return configutil.ProfileKeys(), cobra.ShellCompDirectiveNoFileComp
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, profile, err := ActiveProfile(cmd)
+		if err != nil {
+			return err
+		}
+		value, err := profile.Get(args[0])
+		if err != nil {
+			return err
+		}
+		if args[0] == "api_key" && value != "" {
+			value = "<redacted>"
+		}
+		fmt.Println(value)
+		return nil
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Set a key in the active profile",
+	Long: `Set a key in the active profile, creating the profile if needed.
+Lists such as 'regions' and 'instance_types' are comma-separated, in order of
+preference. An empty value unsets the key.`,
+	Args: cobra.ExactArgs(2),
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) != 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return configutil.ProfileKeys(), cobra.ShellCompDirectiveNoFileComp
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, cfg, err := loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+		name := cfg.ProfileName(explicitProfile(cmd))
+		profile, ok := cfg.Profiles[name]
+		if !ok {
+			profile = &configutil.Profile{}
+			cfg.Profiles[name] = profile
+		}
+		if err := profile.Set(args[0], args[1]); err != nil {
+			return err
+		}
+		if err := cfg.Save(path); err != nil {
+			return err
+		}
+		log.Infof("Set '%s' in profile '%s' (%s)", args[0], name, path)
+		return nil
+	},
+}
+
+var configUseProfileCmd = &cobra.Command{
+	Use:   "use-profile <name>",
+	Short: "Make a profile the default for future commands",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, cfg, err := loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+		name := args[0]
+		if _, ok := cfg.Profiles[name]; !ok {
+			log.Infof("Profile '%s' does not exist yet, creating it", name)
+			cfg.Profiles[name] = &configutil.Profile{}
+		}
+		cfg.CurrentProfile = name
+		if err := cfg.Save(path); err != nil {
+			return err
+		}
+		log.Infof("Switched to profile '%s'", name)
+		return nil
+	},
+}
+
+var configPathCmd = &cobra.Command{
+	Use:   "path",
+	Short: "Print the path of the configuration file",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		explicit, _ := cmd.Root().PersistentFlags().GetString("config")
+		path, err := configutil.ConfigPath(explicit)
+		if err != nil {
+			return fmt.Errorf("resolving config path: %w", err)
+		}
+		fmt.Println(path)
+		return nil
+	},
+}
+
+func init() {
+	ConfigCmd.AddCommand(configViewCmd)
+	ConfigCmd.AddCommand(configGetCmd)
+	ConfigCmd.AddCommand(configSetCmd)
+	ConfigCmd.AddCommand(configUseProfileCmd)
+	ConfigCmd.AddCommand(configPathCmd)
+}
@@ -0,0 +1,362 @@
+package cli
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/state"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var CostCmd = &cobra.Command{
+	Use:   "cost",
+	Short: "Report spend by GPU type, region, prefix or user",
+	Long: `Report spend by GPU type, region, prefix or user.
+
+Costs come from the local instance history (see 'lm list') at the price
+recorded at launch, up to now for live instances. Only instances launched
+with the active profile against the current API count. Instances lm did not
+launch have no recorded start, so they only count toward the burn rate.
+The month-end projection adds the current burn rate for the rest of the
+month to the spend since the first of the month.
+
+An instance that is gone although its termination was never recorded (e.g.
+it was deleted in the web console) counts until its TTL expired, or else
+until the last time lm saw it running.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputFormat, _ := cmd.Root().PersistentFlags().GetString("output")
+		outputFormat = strings.ToLower(outputFormat)
+		switch outputFormat {
+		case "", "table", "json", "csv":
+		default:
+			return fmt.Errorf("invalid output format: %s. Supported formats: 'table', 'json', 'csv'", outputFormat)
+		}
+		groupBy, _ := cmd.Flags().GetString("group-by")
+		if _, ok := costGroupKeys[groupBy]; !ok {
+			return fmt.Errorf("invalid --group-by: %s. Supported groupings: %s", groupBy, strings.Join(costGroupNames(), ", "))
+		}
+		now := time.Now()
+		since := startOfMonth(now)
+		if s, _ := cmd.Flags().GetString("since"); s != "" {
+			var err error
+			if since, err = parseSince(s); err != nil {
+				return err
+			}
+		}
+
+		profileName, _, err := ActiveProfile(cmd)
+		if err != nil {
+			return err
+		}
+		ctx := cmd.Context()
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error initializing API client: %w", err)
+		}
+		live, err := client.ListInstances(ctx)
+		if err != nil {
+			return fmt.Errorf("error fetching instances: %w", err)
+		}
+		history := accountHistory(loadHistory(), live, profileName, client.BaseURL(), now)
+		report := buildCostReport(history, live, since, now, groupBy)
+		switch outputFormat {
+		case "json":
+			return printJSON(report)
+		case "csv":
+			w := csv.NewWriter(os.Stdout)
+			w.Write([]string{groupBy, "instances", "gpu_hours", "cost_usd"})
+			for _, g := range report.Groups {
+				w.Write([]string{g.Key, strconv.Itoa(g.Instances), strconv.FormatFloat(g.GPUHours, 'f', 2, 64), strconv.FormatFloat(g.CostUSD, 'f', 2, 64)})
+			}
+			w.Flush()
+			return w.Error()
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "%s\tINSTANCES\tGPU_HOURS\tCOST\n", strings.ToUpper(groupBy))
+		for _, g := range append(report.Groups, report.Total) {
+			fmt.Fprintf(w, "%s\t%d\t%.1f\t$%.2f\n", g.Key, g.Instances, g.GPUHours, g.CostUSD)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		log.Infof("Since %s: $%.2f over %.1f GPU-hours.", report.Since.Format(time.DateOnly), report.Total.CostUSD, report.Total.GPUHours)
+		log.Infof("Burn rate $%.2f/h; month to date $%.2f; projected month end $%.2f.", report.BurnRateUSDPerHour, report.MonthToDateUSD, report.ProjectedMonthEndUSD)
+		if report.UnrecordedLive > 0 {
+			log.Warnf("%d live instance(s) were not launched by lm; they count toward the burn rate only.", report.UnrecordedLive)
+		}
+		return nil
+	},
+}
+
+// costGroup is one row of a cost report.
+type costGroup struct {
+	Key       string  `json:"key"`
+	Instances int     `json:"instances"`
+	GPUHours  float64 `json:"gpu_hours"`
+	CostUSD   float64 `json:"cost_usd"`
+}
+
+// costReport is the output of 'lm cost'.
+type costReport struct {
+	Since   time.Time   `json:"since"`
+	Until   time.Time   `json:"until"`
+	GroupBy string      `json:"group_by"`
+	Groups  []costGroup `json:"groups"`
+	Total   costGroup   `json:"total"`
+	// BurnRateUSDPerHour is the hourly price of every live instance.
+	BurnRateUSDPerHour   float64 `json:"burn_rate_usd_per_hour"`
+	MonthToDateUSD       float64 `json:"month_to_date_usd"`
+	ProjectedMonthEndUSD float64 `json:"projected_month_end_usd"`
+	// UnrecordedLive counts the live instances without a recorded launch.
+	UnrecordedLive int `json:"unrecorded_live"`
+}
+
+// costUsage is the part of an instance's run inside a report window.
+type costUsage struct {
+	Instance          *state.Instance
+	GPUs              int
+	PriceCentsPerHour int
+	Hours             float64
+}
+
+// costGroupKeys maps each --group-by value to the key it groups usages by.
+var costGroupKeys = map[string]func(u costUsage) string{
+	"type":   func(u costUsage) string { return u.Instance.InstanceType },
+	"region": func(u costUsage) string { return u.Instance.Region },
+	"prefix": func(u costUsage) string { return namePrefix(u.Instance.Name) },
+	"user":   func(u costUsage) string { return u.Instance.User },
+}
+
+func costGroupNames() []string {
+	names := make([]string, 0, len(costGroupKeys))
+	for name := range costGroupKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// createdNameRe matches the names create gives instances:
+// <prefix>-<gpus>_<gpu>-<suffix>, with -<n> appended for --count.
+var createdNameRe = regexp.MustCompile(`^(.+)-[0-9]+_[a-z0-9_]+-[0-9a-f]{8}(-[0-9]+)?$`)
+
+// namePrefix returns the --prefix of an instance created by 'lm create', the
+// cluster of a cluster node, or else the whole name.
+func namePrefix(name string) string {
+	if m := createdNameRe.FindStringSubmatch(name); m != nil {
+		return m[1]
+	}
+	if m := clusterNodeRe.FindStringSubmatch(name); m != nil {
+		return m[1]
+	}
+	return name
+}
+
+// gpusOfType returns the number of GPUs of an instance type such as
+// gpu_8x_h100_sxm5, or 0 if the name does not say.
+func gpusOfType(name string) int {
+	m := specRe.FindStringSubmatch(specFromTypeName(name))
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+// costUsages returns how long each recorded instance ran between since and
+// now. Missing launch details are filled in from the live instance.
+func costUsages(history map[string]*state.Instance, live map[string]api.Instance, since, now time.Time) []costUsage {
+	var usages []costUsage
+	for _, rec := range history {
+		if rec.LaunchedAt.IsZero() {
+			continue
+		}
+		start, end := rec.LaunchedAt, now
+		if !rec.TerminatedAt.IsZero() && rec.TerminatedAt.Before(end) {
+			end = rec.TerminatedAt
+		}
+		if start.Before(since) {
+			start = since
+		}
+		if !end.After(start) {
+			continue
+		}
+		u := costUsage{Instance: rec, PriceCentsPerHour: rec.PriceCentsPerHour, Hours: end.Sub(start).Hours()}
+		if inst, ok := live[rec.ID]; ok {
+			if u.PriceCentsPerHour == 0 {
+				u.PriceCentsPerHour = inst.InstanceType.PriceCentsPerHour
+			}
+			if rec.InstanceType == "" {
+				filled := *rec
+				filled.InstanceType, filled.Region = inst.InstanceType.Name, inst.Region.Name
+				u.Instance = &filled
+			}
+		}
+		u.GPUs = gpusOfType(u.Instance.InstanceType)
+		usages = append(usages, u)
+	}
+	return usages
+}
+
+// buildCostReport sums the recorded usage between since and now by groupBy
+// and projects the spend to the end of the month.
+func buildCostReport(history map[string]*state.Instance, instances []api.Instance, since, now time.Time, groupBy string) costReport {
+	live := make(map[string]api.Instance)
+	report := costReport{Since: since, Until: now, GroupBy: groupBy, Groups: []costGroup{}, Total: costGroup{Key: "TOTAL"}}
+	burnCents := 0
+	for _, inst := range instances {
+		if inst.Status == "terminated" {
+			continue
+		}
+		live[inst.ID] = inst
+		burnCents += inst.InstanceType.PriceCentsPerHour
+		if rec, ok := history[inst.ID]; !ok || rec.LaunchedAt.IsZero() {
+			report.UnrecordedLive++
+		}
+	}
+
+	keyOf := costGroupKeys[groupBy]
+	groups := make(map[string]*costGroup)
+	for _, u := range costUsages(history, live, since, now) {
+		key := keyOf(u)
+		if key == "" {
+			key = "unknown"
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &costGroup{Key: key}
+			groups[key] = g
+		}
+		for _, g := range []*costGroup{g, &report.Total} {
+			g.Instances++
+			g.GPUHours += u.Hours * float64(u.GPUs)
+			g.CostUSD += u.Hours * float64(u.PriceCentsPerHour) / 100
+		}
+	}
+	for _, g := range groups {
+		report.Groups = append(report.Groups, roundCostGroup(*g))
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		if report.Groups[i].CostUSD != report.Groups[j].CostUSD {
+			return report.Groups[i].CostUSD > report.Groups[j].CostUSD
+		}
+		return report.Groups[i].Key < report.Groups[j].Key
+	})
+	report.Total = roundCostGroup(report.Total)
+
+	monthStart := startOfMonth(now)
+	var monthToDate float64
+	for _, u := range costUsages(history, live, monthStart, now) {
+		monthToDate += u.Hours * float64(u.PriceCentsPerHour) / 100
+	}
+	burn := float64(burnCents) / 100
+	remaining := monthStart.AddDate(0, 1, 0).Sub(now).Hours()
+	report.BurnRateUSDPerHour = burn
+	report.MonthToDateUSD = roundCents(monthToDate)
+	report.ProjectedMonthEndUSD = roundCents(monthToDate + burn*remaining)
+	return report
+}
+
+func roundCostGroup(g costGroup) costGroup {
+	g.GPUHours = math.Round(g.GPUHours*100) / 100
+	g.CostUSD = roundCents(g.CostUSD)
+	return g
+}
+
+func roundCents(usd float64) float64 {
+	return math.Round(usd*100) / 100
+}
+
+// accountHistory returns the records of the instances launched with profile
+// against the API at apiURL, whose live instances are instances. Records
+// written before launches noted their API belong to the public one.
+//
+// An instance that is gone although its termination was never recorded, e.g.
+// because its TTL timer or the web console deleted it, is returned as
+// terminated at the best guess lm has: when its TTL expired, or else the last
+// time lm saw it running. Only the returned copies are changed; the history
+// is not written to.
+func accountHistory(history map[string]*state.Instance, instances []api.Instance, profile, apiURL string, now time.Time) map[string]*state.Instance {
+	live := make(map[string]bool, len(instances))
+	for _, inst := range instances {
+		if inst.Status != "terminated" {
+			live[inst.ID] = true
+		}
+	}
+	scoped := make(map[string]*state.Instance)
+	for id, rec := range history {
+		recURL := rec.APIURL
+		if recURL == "" {
+			recURL = api.DefaultBaseURL
+		}
+		if rec.LaunchedAt.IsZero() || rec.Profile != profile || recURL != apiURL {
+			continue
+		}
+		if rec.TerminatedAt.IsZero() && !live[id] {
+			gone := *rec
+			gone.TerminatedAt = lastSeenRunning(rec, now)
+			rec = &gone
+		}
+		scoped[id] = rec
+	}
+	return scoped
+}
+
+// lastSeenRunning estimates when an instance that vanished without a
+// recorded termination ended.
+func lastSeenRunning(rec *state.Instance, now time.Time) time.Time {
+	if !rec.ExpiresAt.IsZero() && rec.ExpiresAt.Before(now) {
+		return rec.ExpiresAt
+	}
+	last := rec.LaunchedAt
+	for _, t := range rec.Restarts {
+		last = latest(last, t)
+	}
+	for _, run := range rec.Setups {
+		last = latest(last, run.Time)
+	}
+	return latest(last, rec.SampledAt)
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+func startOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// parseSince parses a --since date, YYYY-MM-DD in local time or RFC 3339.
+func parseSince(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --since '%s': use YYYY-MM-DD or RFC 3339", s)
+	}
+	return t, nil
+}
+
+func init() {
+	CostCmd.Flags().String("since", "", "Start of the report, YYYY-MM-DD or RFC 3339 (default: first of this month)")
+	CostCmd.Flags().String("group-by", "type", "Break costs down by: type|region|prefix|user")
+	CostCmd.RegisterFlagCompletionFunc("group-by", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return costGroupNames(), cobra.ShellCompDirectiveNoFileComp
+	})
+}
@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/state"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+)
+
+func TestBuildCostReport(t *testing.T) {
+	now := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	history := state.Fold([]state.Event{
+		// Ran 10h in September and 24h in October, still live.
+		{Time: now.AddDate(0, 0, -16).Add(-10 * time.Hour), Kind: state.Launched, InstanceID: "i-1", InstanceName: "train-8_h100_sxm5-0123abcd", InstanceType: "gpu_8x_h100_sxm5", Region: "us-east-1", PriceCentsPerHour: 2000, User: "alice"},
+		// Ran 2h last week.
+		{Time: now.AddDate(0, 0, -7), Kind: state.Launched, InstanceID: "i-2", InstanceName: "run-node-0", InstanceType: "gpu_1x_a10", Region: "us-west-1", PriceCentsPerHour: 75, User: "bob"},
+		{Time: now.AddDate(0, 0, -7).Add(2 * time.Hour), Kind: state.Terminated, InstanceID: "i-2"},
+		// Setup of an instance lm did not launch: no cost.
+		{Time: now.AddDate(0, 0, -1), Kind: state.Setup, InstanceID: "i-3"},
+	})
+	live := []api.Instance{{ID: "i-1", Status: "active"}, {ID: "i-3", Status: "active"}}
+	live[0].InstanceType.PriceCentsPerHour = 2000
+	live[1].InstanceType.PriceCentsPerHour = 100
+
+	// Since October 15: i-1 ran 24h.
+	report := buildCostReport(history, live, now.AddDate(0, 0, -1), now, "type")
+	if len(report.Groups) != 1 || report.Groups[0].Key != "gpu_8x_h100_sxm5" {
+		t.Fatalf("groups = %+v", report.Groups)
+	}
+	if g := report.Total; g.Instances != 1 || g.GPUHours != 8*24 || g.CostUSD != 20*24 {
+		t.Errorf("total = %+v", g)
+	}
+	if report.UnrecordedLive != 1 || report.BurnRateUSDPerHour != 21 {
+		t.Errorf("unrecorded %d, burn %v", report.UnrecordedLive, report.BurnRateUSDPerHour)
+	}
+	// October so far: i-1 15 days, i-2 2h; then 16 days at $21/h.
+	wantMTD := 20.0*24*15 + 0.75*2
+	if report.MonthToDateUSD != wantMTD {
+		t.Errorf("month to date = %v, want %v", report.MonthToDateUSD, wantMTD)
+	}
+	if want := wantMTD + 21*24*16; report.ProjectedMonthEndUSD != want {
+		t.Errorf("projected = %v, want %v", report.ProjectedMonthEndUSD, want)
+	}
+
+	byPrefix := buildCostReport(history, live, startOfMonth(now), now, "prefix")
+	if len(byPrefix.Groups) != 2 || byPrefix.Groups[0].Key != "train" || byPrefix.Groups[1].Key != "run" {
+		t.Errorf("prefix groups = %+v", byPrefix.Groups)
+	}
+}
+
+func TestAccountHistory(t *testing.T) {
+	launch := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	now := launch.Add(24 * time.Hour)
+	const url = "https://example.test/api/v1"
+	history := state.Fold([]state.Event{
+		{Time: launch, Kind: state.Launched, InstanceID: "i-live", Profile: "work", APIURL: url},
+		{Time: launch, Kind: state.Launched, InstanceID: "i-gone", Profile: "work", APIURL: url},
+		{Time: launch.Add(2 * time.Hour), Kind: state.Setup, InstanceID: "i-gone"},
+		{Time: launch, Kind: state.Launched, InstanceID: "i-expired", Profile: "work", APIURL: url},
+		{Time: launch, Kind: state.TTL, InstanceID: "i-expired", ExpiresAt: launch.Add(5 * time.Hour)},
+		{Time: launch, Kind: state.Launched, InstanceID: "i-deleted", Profile: "work", APIURL: url},
+		{Time: launch.Add(time.Hour), Kind: state.Terminated, InstanceID: "i-deleted"},
+		{Time: launch, Kind: state.Launched, InstanceID: "i-other-profile", Profile: "home", APIURL: url},
+		{Time: launch, Kind: state.Launched, InstanceID: "i-fake-cloud", Profile: "work", APIURL: "http://127.0.0.1:8787/api/v1"},
+		{Time: launch, Kind: state.Launched, InstanceID: "i-public", Profile: "work"},
+		{Time: launch, Kind: state.Setup, InstanceID: "i-unrecorded"},
+	})
+	scoped := accountHistory(history, []api.Instance{{ID: "i-live", Status: "active"}}, "work", url, now)
+
+	want := map[string]time.Time{
+		"i-live":    {},
+		"i-gone":    launch.Add(2 * time.Hour),
+		"i-expired": launch.Add(5 * time.Hour),
+		"i-deleted": launch.Add(time.Hour),
+	}
+	if len(scoped) != len(want) {
+		t.Fatalf("scoped history has %d records, want %d: %v", len(scoped), len(want), scoped)
+	}
+	for id, end := range want {
+		if rec, ok := scoped[id]; !ok || !rec.TerminatedAt.Equal(end) {
+			t.Errorf("%s: record %+v, want terminated at %v", id, rec, end)
+		}
+	}
+	if !history["i-gone"].TerminatedAt.IsZero() {
+		t.Error("accountHistory changed the history it was given")
+	}
+
+	public := accountHistory(history, nil, "work", api.DefaultBaseURL, now)
+	if _, ok := public["i-public"]; !ok || len(public) != 1 {
+		t.Errorf("records without an API URL belong to the public API, got %v", public)
+	}
+}
+
+func TestNamePrefix(t *testing.T) {
+	for name, want := range map[string]string{
+		"team-a-8_h100_sxm5-0123abcd": "team-a",
+		"generic-1_a10-82ff9228-3":    "generic",
+		"my-run-node-12":              "my-run",
+		"hand-made":                   "hand-made",
+	} {
+		if got := namePrefix(name); got != want {
+			t.Errorf("namePrefix(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -1,36 +1,95 @@
 package cli
 
 import (
+	"bufio"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	api "github.com/aarnphm/detachtools/overlays/packages/lambda/internal/apiclient"
 	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/configutil"
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/sshutil"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
 )
 
 var CreateCmd = &cobra.Command{
-	Use:               "create <gpus>x<type> [region]",
-	Short:             "Create a new Lambda Cloud instance",
-	Args:              cobra.RangeArgs(1, 2),
+	Use:   "create [<gpus>x<type>[,<gpus>x<type>...] [region]]",
+	Short: "Create a new Lambda Cloud instance",
+	Long: `Create a new Lambda Cloud instance.
+
+The spec may list several instance types in order of preference, e.g.
+8xH100_SXM5,8xA100_80GB_SXM4. Without one, the template's spec or the
+profile's 'instance_types' is used. Regions come from the region argument, --regions
+or the profile's 'regions', and may use globs such as us-*. The first type
+with capacity in any allowed region wins; for that type, regions are tried in
+the order given.
+
+With --setup and/or --connect, create goes on to run 'lm setup' and open a
+shell on the new instance over a single SSH connection. If a step fails the
+instance keeps running and the command to resume is printed.
+
+--template starts from a saved launch template (see 'lm template'); the spec
+and flags given on the command line override it. --save-template stores the
+command line as a template instead of launching.
+
+Launches that would exceed the 'budget' in the config file are refused;
+--override-budget <reason> launches anyway and records the reason.
+
+--ttl terminates the instances after the given time (see 'lm ttl').`,
+	Args:              cobra.RangeArgs(0, 2),
 	ValidArgsFunction: completeGpuSpec,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		instanceSpec := args[0]
-		userRegion := ""
+		instanceSpec, userRegion := "", ""
+		if len(args) > 0 {
+			instanceSpec = args[0]
+		}
 		if len(args) == 2 {
 			userRegion = args[1]
 		}
-
-		apiKey, _ := cmd.Root().PersistentFlags().GetString("api-key")
+		templateName, _ := cmd.Flags().GetString("template")
+		if templateName != "" {
+			tmpl, err := loadTemplate(cmd, templateName)
+			if err != nil {
+				return err
+			}
+			if err := applyTemplate(cmd, tmpl, userRegion != ""); err != nil {
+				return fmt.Errorf("template '%s': %w", templateName, err)
+			}
+			if instanceSpec == "" {
+				instanceSpec = tmpl.Spec
+			}
+		}
+		if saveName, _ := cmd.Flags().GetString("save-template"); saveName != "" {
+			return saveTemplate(cmd, saveName, templateFromFlags(cmd, instanceSpec, userRegion))
+		}
+		profileName, profile, err := ActiveProfile(cmd)
+		if err != nil {
+			return err
+		}
+		if instanceSpec == "" {
+			instanceSpec = strings.Join(profile.InstanceTypes, ",")
+		}
+		if instanceSpec == "" {
+			return fmt.Errorf("no instance spec: pass one such as 1xA10, a --template that sets 'spec', or set the profile's 'instance_types'")
+		}
 		prefix, _ := cmd.Flags().GetString("prefix")
+		if !cmd.Flags().Changed("prefix") {
+			prefix = profile.Prefix
+		}
 		sshKeyName, _ := cmd.Root().PersistentFlags().GetString("ssh-key-name")
 		outputFormat, _ := cmd.Root().PersistentFlags().GetString("output")
 		outputFormat = strings.ToLower(outputFormat)
@@ -39,308 +98,944 @@ var CreateCmd = &cobra.Command{
 		if outputFormat != "" && outputFormat != "json" && outputFormat != "table" {
 			return fmt.Errorf("invalid output format: %s. Supported formats: 'table', 'json'", outputFormat)
 		}
+		onInterrupt, _ := cmd.Flags().GetString("on-interrupt")
+		switch onInterrupt {
+		case onInterruptPrompt, onInterruptTerminate, onInterruptKeep:
+		default:
+			return fmt.Errorf("invalid --on-interrupt policy: %s. Supported policies: 'prompt', 'terminate', 'keep'", onInterrupt)
+		}
+		waitMode, _ := cmd.Flags().GetString("wait")
+		hostKeyWait, _ := cmd.Flags().GetDuration("host-key-wait")
+		ready := readiness{HostKeyWait: hostKeyWait}
+		switch waitMode {
+		case waitNone, waitAPI:
+		case waitSSH:
+			if hostKeyWait <= 0 {
+				return fmt.Errorf("--wait=ssh checks the pinned host key and needs a positive --host-key-wait; use --wait=api to skip the SSH check")
+			}
+			sshTimeout, _ := cmd.Flags().GetDuration("ssh-timeout")
+			if sshTimeout <= 0 {
+				return fmt.Errorf("--ssh-timeout must be positive, got %s", sshTimeout)
+			}
+			// Load the key before launching: a missing key should not cost
+			// an instance, and an encrypted one asks for its passphrase once.
+			sshKeyPath, _ := cmd.Root().PersistentFlags().GetString("ssh-key-path")
+			ready.Signer, err = sshutil.LoadSSHKey(sshKeyPath)
+			if err != nil {
+				return fmt.Errorf("failed to get SSH key from '%s': %w", sshKeyPath, err)
+			}
+			ready.User, ready.SSHTimeout = profile.RemoteUser, sshTimeout
+		default:
+			return fmt.Errorf("invalid --wait mode: %s. Supported modes: 'api', 'ssh', 'none'", waitMode)
+		}
 		log.Debugf("Using SSH key name: %s", sshKeyName)
-		client, err := api.NewAPIClient(apiKey)
+		ctx := cmd.Context()
+		client, err := newAPIClient(cmd)
 		if err != nil {
 			return fmt.Errorf("error initializing API client: %w", err)
 		}
 
-		// 1. Parse instance spec
-		re := regexp.MustCompile(`^([1-9][0-9]*)x([a-zA-Z0-9_]+)$`)
-		matches := re.FindStringSubmatch(instanceSpec)
-		if len(matches) != 3 {
-			return fmt.Errorf("invalid instance specification format. Use <number_gpus>x<gpu_type> (e.g., 1xA100, 2xH100_SXM5)")
+		// 1. Parse instance specs and region preferences
+		candidates, err := parseSpecs(instanceSpec)
+		if err != nil {
+			return err
+		}
+		regionsFlag, _ := cmd.Flags().GetString("regions")
+		var patterns []string
+		switch {
+		case userRegion != "" && regionsFlag != "":
+			return fmt.Errorf("pass either a region argument or --regions, not both")
+		case userRegion != "":
+			patterns, err = parseRegionPatterns(userRegion)
+		case regionsFlag != "":
+			patterns, err = parseRegionPatterns(regionsFlag)
+		default:
+			patterns, err = parseRegionPatterns(strings.Join(profile.Regions, ","))
 		}
-		numGPUs := matches[1]
-		gpuType := matches[2]
-		requestedInstanceTypeName := fmt.Sprintf("gpu_%sx_%s", numGPUs, gpuType)
-		// Generate random suffix
-		suffixBytes := make([]byte, 4)
-		_, err = rand.Read(suffixBytes)
 		if err != nil {
-			// Handle error appropriately, maybe return it or log fatal
-			// For now, just log and continue, but this might not be ideal
-			log.Warnf("Failed to generate random suffix: %v", err)
+			return err
+		}
+		if len(patterns) == 0 {
+			return fmt.Errorf("no regions to choose from. Pass --regions or set 'regions' in the profile")
 		}
-		randomSuffix := hex.EncodeToString(suffixBytes)
-		instanceName := fmt.Sprintf("%s-%s_%s-%s", prefix, numGPUs, gpuType, randomSuffix)
-
-		log.Infof("Requesting instance type: %s, Name: %s", requestedInstanceTypeName, instanceName)
 
+		// 2. Drop types where the new batch would exceed the per-GPU-type limit
+		count, _ := cmd.Flags().GetInt("count")
+		if count < 1 {
+			return fmt.Errorf("--count must be at least 1, got %d", count)
+		}
+		pipeline, err := createPipelineFromFlags(cmd, profile, waitMode, count, jsonOutput)
+		if err != nil {
+			return err
+		}
+		var ttl time.Duration
+		var timer *ttlTimer
+		ttlArg, _ := cmd.Flags().GetString("ttl")
+		if ttlArg != "" {
+			if ttl, err = parseTTL(ttlArg, false); err != nil {
+				return err
+			}
+			if timer, err = newTTLTimer(cmd, client, profile); err != nil {
+				return err
+			}
+			if timer.APIKey == "" {
+				log.Warnf("ttl_api_key_command is not set, so the instance(s) cannot terminate themselves after %s; run 'lm reap' to enforce the TTL.", ttlArg)
+			}
+		}
 		maxInstancesPerType, _ := cmd.Flags().GetInt("max-instances-per-type")
-		log.Debugf("Checking max instances limit (%d) for GPU type '%s'", maxInstancesPerType, gpuType)
-		var instancesResp api.InstancesResponse
-		err = client.Request("GET", "/instances", nil, &instancesResp)
+		instances, err := client.ListInstances(ctx)
 		if err != nil {
 			return fmt.Errorf("error fetching instances: %w", err)
 		}
-		existingSameTypeCount := 0
-		var existingInstancesInfo []string
-		for _, inst := range instancesResp.Data {
-			// Only consider active instances
-			if inst.Status != "active" {
+		var allowed []typeCandidate
+		var blocked []string
+		for _, c := range candidates {
+			log.Debugf("Checking max instances limit (%d) for GPU type '%s'", maxInstancesPerType, c.GPUType)
+			existing := activeInstancesOfGPU(instances, c.GPUType)
+			if len(existing)+count > maxInstancesPerType {
+				log.Warnf("Maximum number of active instances (%d) reached for GPU type '%s' (%d running, %d requested).", maxInstancesPerType, c.GPUType, len(existing), count)
+				if len(existing) > 0 {
+					log.Warnf("Found %d existing active instance(s):", len(existing))
+					for _, info := range existing {
+						log.Warn(info)
+					}
+				}
+				blocked = append(blocked, c.GPUType)
 				continue
 			}
-			// Extract GPU type from existing instance's type name (e.g., "gpu_1x_a100" -> "a100")
-			parts := strings.SplitN(inst.InstanceType.Name, "x_", 2)
-			if len(parts) == 2 {
-				existingGpuType := parts[1]
+			log.Debugf("Found %d active instances of type '%s'. Limit (%d) not reached.", len(existing), c.GPUType, maxInstancesPerType)
+			allowed = append(allowed, c)
+		}
+		if len(allowed) == 0 {
+			return fmt.Errorf("--max-instances-per-type=%d reached for GPU type(s) %s; connect to a running instance with 'lm connect <instance_name>', delete one or raise the limit", maxInstancesPerType, strings.Join(blocked, ", "))
+		}
+		candidates = allowed
 
-				compareExistingType := existingGpuType
-				compareRequestedType := gpuType
+		// 3. Find the requested instance types and pick a type and region
+		log.Debugf("Fetching details for instance types '%s'", instanceSpec)
+		instanceTypes, err := client.ListInstanceTypes(ctx)
+		if err != nil {
+			return fmt.Errorf("error fetching instance types: %w", err)
+		}
 
-				parts = strings.SplitN(existingGpuType, "_", 2)
-				if len(parts) > 0 {
-					compareExistingType = parts[0]
-				}
+		if err := checkInstanceTypes(instanceTypes, candidates); err != nil {
+			return err
+		}
 
-				parts = strings.SplitN(gpuType, "_", 2)
-				if len(parts) > 0 {
-					compareRequestedType = parts[0]
-				}
+		waitForCapacity, _ := cmd.Flags().GetBool("wait-for-capacity")
+		waitTimeout, _ := cmd.Flags().GetDuration("timeout")
+		waitPoll, _ := cmd.Flags().GetDuration("poll")
 
-				if compareExistingType == compareRequestedType {
-					existingSameTypeCount++
-					existingInstancesInfo = append(existingInstancesInfo,
-						fmt.Sprintf("  - Name: %s, ID: %s, IP: %s, Region: %s",
-							inst.Name, inst.ID, inst.IP, inst.Region.Name))
-				}
-			}
+		budget, err := newBudgetGuard(cmd, client, instanceTypes, count)
+		if err != nil {
+			return err
 		}
 
-		if existingSameTypeCount >= maxInstancesPerType {
-			log.Warnf("Maximum number of active instances (%d) reached for GPU type '%s'.", maxInstancesPerType, gpuType)
-			log.Warnf("Found %d existing active instance(s):", existingSameTypeCount)
-			for _, info := range existingInstancesInfo {
-				log.Warn(info)
-			}
-			log.Warnf("To connect, use: lm connect <instance_name>")
-			return nil
+		// Generate random suffix
+		suffixBytes := make([]byte, 4)
+		_, err = rand.Read(suffixBytes)
+		if err != nil {
+			// Handle error appropriately, maybe return it or log fatal
+			// For now, just log and continue, but this might not be ideal
+			log.Warnf("Failed to generate random suffix: %v", err)
 		}
-		log.Debugf("Found %d active instances of type '%s'. Limit (%d) not reached.", existingSameTypeCount, gpuType, maxInstancesPerType)
+		randomSuffix := hex.EncodeToString(suffixBytes)
 
-		// 3. Find the requested instance type and available regions
-		log.Debugf("Fetching details for instance type '%s'", requestedInstanceTypeName)
-		var typesResp api.InstanceTypesResponse
-		err = client.Request("GET", "/instance-types", nil, &typesResp)
+		// 4. Create the file system if needed and launch
+		fsProfile := profile
+		if fs, _ := cmd.Flags().GetString("filesystem"); fs != "" {
+			fsProfile.FilesystemTemplate = fs
+		}
+		var baseName string
+		choice, instanceIDs, err := launchWithPlacement(ctx, client, instanceTypes, launchPlan{
+			Spec:       instanceSpec,
+			Candidates: candidates,
+			Patterns:   patterns,
+			Count:      count,
+			SSHKeyName: sshKeyName,
+			Name: func(choice *placement) string {
+				baseName = fmt.Sprintf("%s-%s_%s-%s", prefix, choice.Candidate.NumGPUs, choice.Candidate.GPUType, randomSuffix)
+				return baseName
+			},
+			FileSystem: func(region string) (string, error) {
+				return fsProfile.FilesystemName(profileName, region)
+			},
+			Check: func(choice *placement) error {
+				return budget.check(ctx, choice)
+			},
+			WaitForCapacity: waitForCapacity,
+			WaitTimeout:     waitTimeout,
+			Poll:            waitPoll,
+		})
 		if err != nil {
-			return fmt.Errorf("error fetching instance types: %w", err)
+			return err
 		}
 
-		instanceTypeDetails, ok := typesResp.Data[requestedInstanceTypeName]
-		if !ok {
-			log.Warnf("Instance type '%s' not found. Available instance types:", requestedInstanceTypeName)
-			for name, details := range typesResp.Data {
-				var regionNames []string
-				for _, r := range details.RegionsWithCapacity {
-					regionNames = append(regionNames, r.Name)
+		log.Infof("Instance launch initiated with ID(s): %s", strings.Join(instanceIDs, ", "))
+
+		// Instances of one launch share a name; give each a stable index suffix
+		names := renameInstances(ctx, client, instanceIDs, baseName, func(i int) string {
+			if len(instanceIDs) == 1 {
+				return baseName
+			}
+			return fmt.Sprintf("%s-%d", baseName, i+1)
+		})
+		recordLaunch(instanceIDs, names, choice, instanceTypes[choice.InstanceType].InstanceType.PriceCentsPerHour, profileName, client.BaseURL(), templateName)
+		budget.recordOverride(instanceIDs)
+		var expiresAt time.Time
+		if ttl > 0 {
+			expiresAt = time.Now().Add(ttl)
+			launched := make([]api.Instance, 0, len(instanceIDs))
+			for _, id := range instanceIDs {
+				launched = append(launched, api.Instance{ID: id, Name: names[id]})
+			}
+			recordTTL(expiresAt, launched...)
+		}
+
+		if waitMode == waitNone {
+			launched := make([]map[string]any, 0, len(instanceIDs))
+			for _, id := range instanceIDs {
+				launched = append(launched, map[string]any{"instance_id": id, "instance_name": names[id]})
+			}
+			if jsonOutput {
+				output := map[string]any{"instances": launched}
+				if count == 1 {
+					output = launched[0]
 				}
-				if len(regionNames) != 0 {
-					log.Warnf("  %s: %d GPUs (%s), Available in: %s",
-						name,
-						details.InstanceType.Specs.Gpus,
-						details.InstanceType.GpuDescription,
-						strings.Join(regionNames, ", "))
+				output["instance_type"] = choice.InstanceType
+				output["region"] = choice.Region
+				output["choice"] = choice
+				return printJSON(output)
+			}
+			log.Infof("Not waiting for the instance(s) to boot (--wait=none). Check on them with: lm list")
+			if timer != nil && timer.APIKey != "" {
+				log.Warn("The TTL timer is only installed once an instance is up; install it then with 'lm ttl set <name> <ttl>'. 'lm reap' enforces the TTL meanwhile.")
+			}
+			return nil
+		}
+
+		// 5. Poll for Active Status and IP, then for SSH
+		log.Infof("Waiting for the instance(s) to become active")
+		results := waitForActive(ctx, client, instanceIDs, ready)
+		if ctx.Err() != nil {
+			// Ready instances are left alone. Those still on their way,
+			// including active ones waiting for SSH, go by --on-interrupt.
+			var pending []api.Instance
+			for _, res := range results {
+				if res.Err != nil && !res.Terminal {
+					pending = append(pending, res.known(names[res.ID]))
 				}
 			}
-			return fmt.Errorf("instance type '%s' not found", requestedInstanceTypeName)
+			cause := fmt.Errorf("interrupted while waiting for instances to become ready: %w", ctx.Err())
+			if len(pending) == 0 {
+				return cause
+			}
+			return handleAbandonedLaunch(client, pending, onInterrupt, cause)
 		}
 
-		// 4. Determine target region
-		targetRegion := ""
-		availableRegions := instanceTypeDetails.RegionsWithCapacity
-		availableRegionMap := make(map[string]bool)
-		for _, r := range availableRegions {
-			availableRegionMap[r.Name] = true
+		homeDir, _ := os.UserHomeDir()
+		_, bwErr := os.Stat(filepath.Join(homeDir, "bw.pass"))
+		canSetup := bwErr == nil
+		succeeded := []map[string]any{}
+		failed := []map[string]any{}
+		var abandoned []api.Instance
+		for _, res := range results {
+			if res.Err != nil {
+				log.Errorf("Instance '%s' (ID: %s) failed: %v", names[res.ID], res.ID, res.Err)
+				failed = append(failed, map[string]any{
+					"instance_id":   res.ID,
+					"instance_name": names[res.ID],
+					"error":         res.Err.Error(),
+				})
+				if !res.Terminal {
+					abandoned = append(abandoned, res.known(names[res.ID]))
+				}
+				continue
+			}
+			succeeded = append(succeeded, instanceSummary(res, profile.RemoteUser, canSetup))
 		}
 
-		if userRegion != "" {
-			if availableRegionMap[userRegion] {
-				targetRegion = userRegion
-				log.Debugf("Using user-specified region: %s (available for %s)", targetRegion, requestedInstanceTypeName)
+		if jsonOutput {
+			var output map[string]any
+			if count == 1 && len(succeeded) == 1 {
+				output = succeeded[0]
 			} else {
-				log.Errorf("Requested instance type '%s' is not available in the specified region '%s'.", requestedInstanceTypeName, userRegion)
-				var availableNames []string
-				for _, r := range availableRegions {
-					availableNames = append(availableNames, r.Name)
-				}
-				if len(availableNames) > 0 {
-					log.Debugf("Available regions: %s", strings.Join(availableNames, ", "))
+				output = map[string]any{"instances": succeeded, "failed": failed}
+			}
+			output["instance_type"] = choice.InstanceType
+			output["region"] = choice.Region
+			output["choice"] = choice
+			if len(succeeded) > 0 || count > 1 {
+				jsonBytes, err := json.Marshal(output)
+				if err != nil {
+					return fmt.Errorf("failed to marshal JSON output: %w", err)
 				}
-				return fmt.Errorf("instance type '%s' unavailable in region '%s'", requestedInstanceTypeName, userRegion)
+				fmt.Println(string(jsonBytes))
 			}
 		} else {
-			if availableRegionMap[configutil.DefaultRegion] {
-				targetRegion = configutil.DefaultRegion
-				log.Debugf("Using default region: %s (available for %s)", targetRegion, requestedInstanceTypeName)
-			} else {
-				// Find first available US region
-				for _, r := range availableRegions {
-					if strings.HasPrefix(r.Name, "us-") {
-						targetRegion = r.Name
-						log.Warnf("Default region '%s' not available for '%s'. Using first available US region: %s", configutil.DefaultRegion, requestedInstanceTypeName, targetRegion)
-						break
-					}
+			for _, summary := range succeeded {
+				log.Println("--------------------------------------------------")
+				if count > 1 {
+					log.Infof("Instance '%s' (%s) is ready", summary["instance_name"], summary["ip"])
+				}
+				if fingerprint, ok := summary["host_key_fingerprint"]; ok {
+					log.Infof("Host key pinned: %s", fingerprint)
+				} else {
+					log.Warnf("Host key not pinned within --host-key-wait, so connect, setup and cluster refuse the instance. Create it with a longer --host-key-wait, or verify its key and add it to the file 'lm known-hosts path' prints:")
+				}
+				log.Infof("  %s", summary["ssh"])
+				log.Infof("To connect:")
+				log.Infof("  %s", summary["connect"])
+				if setupCmd, ok := summary["setup"]; ok {
+					log.Infof("To setup:")
+					log.Infof("  %s", setupCmd)
 				}
-				if targetRegion == "" { // Still not found
-					log.Errorf("Requested instance type '%s' is not available in the default region ('%s') or any US region.", requestedInstanceTypeName, configutil.DefaultRegion)
-					var availableNames []string
-					for _, r := range availableRegions {
-						availableNames = append(availableNames, r.Name)
+			}
+		}
+
+		if timer != nil && timer.APIKey != "" {
+			for _, res := range results {
+				if res.Err == nil {
+					if err := timer.apply(ctx, &res.Instance, expiresAt); err != nil {
+						log.Warnf("Could not install the TTL timer on '%s': %v. 'lm reap' enforces the TTL meanwhile.", res.Instance.Name, err)
 					}
-					log.Infof("Available regions: %s", strings.Join(availableNames, ", "))
-					return fmt.Errorf("instance type '%s' unavailable in default/US regions", requestedInstanceTypeName)
 				}
 			}
 		}
 
-		// 5. Check/Create Filesystem
-		filesystemName := fmt.Sprintf("aaron-%s", targetRegion)
-		log.Infof("Checking for filesystem '%s' in region '%s'", filesystemName, targetRegion)
-		var filesystemsResp api.FileSystemsResponse
-		foundFS := false
-		err = client.Request("GET", "/file-systems", nil, &filesystemsResp)
+		if len(failed) == 0 {
+			if pipeline != nil {
+				return pipeline.run(ctx, &results[0].Instance)
+			}
+			return nil
+		}
+		cause := fmt.Errorf("%d of %d instance(s) did not become ready", len(failed), len(results))
+		if len(results) == 1 {
+			cause = fmt.Errorf("%s", failed[0]["error"])
+		} else {
+			log.Warnf("%d of %d instance(s) are ready.", len(succeeded), len(results))
+		}
+		if len(abandoned) > 0 {
+			return handleAbandonedLaunch(client, abandoned, onInterrupt, cause)
+		}
+		return cause
+	},
+}
+
+// createPipeline is what create runs on a new instance once it accepts SSH:
+// the setup script, then an interactive shell, over one SSH connection.
+type createPipeline struct {
+	// Setup, if set, runs the setup script with these options.
+	Setup   *setupOptions
+	Secrets setupSecrets
+	Connect bool
+
+	SSHKeyPath string
+	SSHKeyName string
+	User       string
+}
+
+// createPipelineFromFlags returns the pipeline selected by create's --setup
+// and --connect flags, or nil. Bitwarden secrets are fetched here, before
+// anything is launched.
+func createPipelineFromFlags(cmd *cobra.Command, profile configutil.Profile, waitMode string, count int, jsonOutput bool) (*createPipeline, error) {
+	doSetup, _ := cmd.Flags().GetBool("setup")
+	doConnect, _ := cmd.Flags().GetBool("connect")
+	detach, _ := cmd.Flags().GetBool("detach")
+	engine, _ := cmd.Flags().GetBool("engine")
+	if !doSetup && (detach || engine) {
+		return nil, fmt.Errorf("--detach and --engine only apply with --setup")
+	}
+	if !doSetup && !doConnect {
+		return nil, nil
+	}
+	switch {
+	case waitMode != waitSSH:
+		return nil, fmt.Errorf("--setup and --connect need --wait=ssh")
+	case count != 1:
+		return nil, fmt.Errorf("--setup and --connect work on a single instance, not --count %d", count)
+	case jsonOutput:
+		return nil, fmt.Errorf("--setup and --connect are interactive and do not support -o json")
+	case doConnect && !term.IsTerminal(int(os.Stdin.Fd())):
+		return nil, fmt.Errorf("--connect needs an interactive terminal")
+	}
+
+	flags := cmd.Root().PersistentFlags()
+	p := &createPipeline{Connect: doConnect, User: profile.RemoteUser}
+	p.SSHKeyPath, _ = flags.GetString("ssh-key-path")
+	p.SSHKeyName, _ = flags.GetString("ssh-key-name")
+	if doSetup {
+		secrets, err := fetchSetupSecrets(cmd.Context(), profile)
 		if err != nil {
-			return fmt.Errorf("error fetching filesystems: %w", err)
+			return nil, err
 		}
-		for _, fs := range filesystemsResp.Data {
-			if fs.Name == filesystemName && fs.Region.Name == targetRegion {
-				log.Debugf("Using existing filesystem: %s", filesystemName)
-				foundFS = true
-				break
-			}
+		p.Setup = &setupOptions{Detach: detach, Engine: engine, NixUser: defaultNixUser}
+		p.Secrets = secrets
+	}
+	return p, nil
+}
+
+// run sets up and connects to inst. If a step fails the instance is left
+// running and the commands that finish the job are printed.
+func (p *createPipeline) run(ctx context.Context, inst *api.Instance) error {
+	sshClient, err := dialInstance(ctx, inst, p.SSHKeyPath, p.User, p.SSHKeyName)
+	if err != nil {
+		return p.abort(inst, fmt.Errorf("failed to establish SSH connection to %s: %w", inst.IP, err))
+	}
+	defer sshClient.Close()
+
+	if p.Setup != nil {
+		log.Println("--------------------------------------------------")
+		log.Infof("Setting up '%s'", inst.Name)
+		if err := runSetup(ctx, sshClient, inst, p.User, p.Secrets, *p.Setup); err != nil {
+			return p.abort(inst, fmt.Errorf("setup of '%s' failed: %w", inst.Name, err))
 		}
+		log.Infof("Setup of '%s' finished.", inst.Name)
+	}
+	if !p.Connect {
+		log.Infof("To connect:")
+		log.Infof("  lm connect %s", inst.Name)
+		return nil
+	}
+	log.Infof("Connecting to '%s'", inst.Name)
+	return interactiveShell(ctx, sshClient, inst)
+}
 
-		if !foundFS {
-			log.Infof("Filesystem '%s' not found. Creating", filesystemName)
-			createFsReq := api.CreateFilesystemRequest{
-				RegionName: targetRegion,
-				Name:       filesystemName,
-			}
-			var createFsResp api.CreateFilesystemResponse
-			err = client.Request("POST", "/filesystems", createFsReq, &createFsResp) // Endpoint was /filesystems in bash?
+// abort reports that the pipeline stopped on inst, which keeps running, and
+// how to resume it. It returns err.
+func (p *createPipeline) abort(inst *api.Instance, err error) error {
+	log.Errorf("%v", err)
+	log.Warnf("Instance '%s' (ID: %s) is still running. To resume:", inst.Name, inst.ID)
+	log.Warnf("  %s", p.resumeCommand(inst.Name))
+	log.Warnf("Or delete it with: lm delete %s", inst.Name)
+	return err
+}
+
+// resumeCommand returns the shell command that runs the steps of p by hand.
+func (p *createPipeline) resumeCommand(name string) string {
+	var steps []string
+	if p.Setup != nil {
+		steps = append(steps, strings.TrimSpace("lm setup "+name+" "+p.Setup.args()))
+	}
+	if p.Connect {
+		steps = append(steps, "lm connect "+name)
+	}
+	return strings.Join(steps, " && ")
+}
+
+// launchPlan describes a launch for launchWithPlacement.
+type launchPlan struct {
+	// Spec is the spec as the user wrote it, for messages.
+	Spec       string
+	Candidates []typeCandidate
+	Patterns   []string
+	Count      int
+	SSHKeyName string
+	// Name returns the instance name for the chosen placement.
+	Name func(choice *placement) string
+	// FileSystem returns the file system to attach in region.
+	FileSystem func(region string) (string, error)
+	// Check, if set, vets the chosen placement before anything is created;
+	// an error aborts the launch.
+	Check           func(choice *placement) error
+	WaitForCapacity bool
+	// WaitTimeout bounds the wait for capacity, 0 for no limit.
+	WaitTimeout time.Duration
+	Poll        time.Duration
+}
+
+// launchWithPlacement picks a type and region for plan, creates the file
+// system there if needed and launches plan.Count instances. With
+// WaitForCapacity it waits for capacity first, and again if the capacity
+// disappears before the launch lands.
+func launchWithPlacement(ctx context.Context, client *api.Client, instanceTypes map[string]api.InstanceTypeDetails, plan launchPlan) (*placement, []string, error) {
+	if plan.WaitForCapacity && plan.Poll <= 0 {
+		return nil, nil, fmt.Errorf("--poll must be positive, got %s", plan.Poll)
+	}
+	var deadline time.Time
+	if plan.WaitForCapacity && plan.WaitTimeout > 0 {
+		deadline = time.Now().Add(plan.WaitTimeout)
+	}
+	regions := strings.Join(plan.Patterns, ",")
+
+	choice := choosePlacement(instanceTypes, plan.Candidates, plan.Patterns)
+	if choice == nil && !plan.WaitForCapacity {
+		log.Errorf("None of %s has capacity in %s.", plan.Spec, regions)
+		log.Infof("Capacity elsewhere: %s", capacitySummary(instanceTypes, plan.Candidates))
+		log.Info("Use --wait-for-capacity to wait until it frees up.")
+		return nil, nil, fmt.Errorf("no capacity for '%s' in regions %s", plan.Spec, regions)
+	}
+
+	for {
+		if choice == nil {
+			var err error
+			choice, err = waitForPlacement(ctx, client, plan.Candidates, plan.Patterns, plan.Poll, deadline)
 			if err != nil {
-				return fmt.Errorf("error creating filesystem '%s': %w", filesystemName, err)
+				return nil, nil, err
 			}
-			// API seems inconsistent here, response gives 'name', not ID? Assuming name is sufficient.
-			if createFsResp.Data.Name != filesystemName {
-				log.Warnf("Filesystem creation response name mismatch (expected %s, got %s), proceeding", filesystemName, createFsResp.Data.Name)
+		}
+		log.Infof("Chose %s in %s: %s", choice.InstanceType, choice.Region, choice.Reason)
+		if plan.Check != nil {
+			if err := plan.Check(choice); err != nil {
+				return nil, nil, err
 			}
-			log.Infof("Filesystem '%s' created successfully.", filesystemName)
 		}
+		name := plan.Name(choice)
 
-		// 6. Launch Instance
-		log.Infof("Launching instance '%s' (%s) in region '%s' with filesystem '%s'",
-			instanceName, requestedInstanceTypeName, targetRegion, filesystemName)
+		filesystemName, err := plan.FileSystem(choice.Region)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := ensureFileSystem(ctx, client, filesystemName, choice.Region); err != nil {
+			return nil, nil, err
+		}
+
+		log.Infof("Launching %d instance(s) '%s' (%s) in region '%s' with filesystem '%s'",
+			plan.Count, name, choice.InstanceType, choice.Region, filesystemName)
 		launchReq := api.LaunchRequest{
-			RegionName:       targetRegion,
-			InstanceTypeName: requestedInstanceTypeName,
-			SSHKeyNames:      []string{sshKeyName},
+			RegionName:       choice.Region,
+			InstanceTypeName: choice.InstanceType,
+			SSHKeyNames:      []string{plan.SSHKeyName},
 			FileSystemNames:  []string{filesystemName},
-			Name:             instanceName,
+			Name:             name,
+			Quantity:         plan.Count,
 		}
-		var launchResp api.LaunchResponse
-		err = client.Request("POST", "/instance-operations/launch", launchReq, &launchResp)
-		if err != nil {
-			return fmt.Errorf("error launching instance: %w", err)
+		instanceIDs, err := client.Launch(ctx, launchReq)
+		if err == nil {
+			return choice, instanceIDs, nil
 		}
-
-		if len(launchResp.Data.InstanceIDs) == 0 {
-			return fmt.Errorf("instance launch initiated, but no instance ID returned")
+		if ctx.Err() != nil {
+			// The launch may have been accepted before the request was cancelled.
+			log.Warnf("Launch request interrupted. Instances named '%s' may still have been created; check 'lm list'.", name)
 		}
-		instanceID := launchResp.Data.InstanceIDs[0]
-		log.Debugf("Instance launch initiated with ID: %s. Waiting for it to become active", instanceID)
-
-		// 7. Poll for Active Status and IP
-		const maxRetries = 40 // 40 * 30s = 20 minutes
-		var finalInstance api.Instance
-		for attempt := range maxRetries {
-			time.Sleep(30 * time.Second)
-			var currentInstances api.InstancesResponse
-			err = client.Request("GET", "/instances", nil, &currentInstances)
-			if err != nil {
-				log.Warnf("Error fetching instances during poll: %v. Retrying", err)
+		if api.IsCapacityError(err) {
+			// Capacity reported by /instance-types can disappear before the launch lands.
+			if plan.WaitForCapacity && ctx.Err() == nil {
+				log.Warnf("Capacity for '%s' in region '%s' ran out before the launch went through. Waiting again.", choice.InstanceType, choice.Region)
+				choice = nil
 				continue
 			}
+			log.Warnf("Capacity for '%s' in region '%s' ran out before the launch went through. Try again, pick another region or use --wait-for-capacity.", choice.InstanceType, choice.Region)
+			return nil, nil, fmt.Errorf("no capacity for instance type '%s' in region '%s': %w", choice.InstanceType, choice.Region, err)
+		}
+		if api.IsQuotaError(err) {
+			log.Warnf("Account quota reached. Delete unused instances with 'lm delete' or request a quota increase.")
+		}
+		return nil, nil, fmt.Errorf("error launching instance: %w", err)
+	}
+}
 
-			found := false
-			for _, inst := range currentInstances.Data {
-				if inst.ID == instanceID {
-					ipDisplay := inst.IP
-					if ipDisplay == "" || ipDisplay == "null" {
-						ipDisplay = "xxx.xxx.xxx.xxx"
-					}
-					log.Infof("Polling instance %s: Status=%-7s, IP=%-12s (%02d/%d)", instanceID, inst.Status, ipDisplay, attempt+1, maxRetries)
-					if inst.Status == "active" && inst.IP != "" && inst.IP != "null" {
-						finalInstance = inst
-						found = true
-						break
-					}
-					// If status is failed, stop polling
-					if inst.Status == "terminated" || inst.Status == "failed" { // Assuming these are terminal states
-						return fmt.Errorf("instance %s entered status '%s'. Aborting", instanceID, inst.Status)
-					}
-					found = true
-					break
-				}
+// checkInstanceTypes fails if a candidate is not a known instance type,
+// listing the types that have capacity.
+func checkInstanceTypes(instanceTypes map[string]api.InstanceTypeDetails, candidates []typeCandidate) error {
+	for _, c := range candidates {
+		if _, ok := instanceTypes[c.TypeName]; ok {
+			continue
+		}
+		log.Warnf("Instance type '%s' not found. Available instance types:", c.TypeName)
+		for name, details := range instanceTypes {
+			var regionNames []string
+			for _, r := range details.RegionsWithCapacity {
+				regionNames = append(regionNames, r.Name)
 			}
-
-			if finalInstance.ID != "" {
-				break
+			if len(regionNames) != 0 {
+				log.Warnf("  %s: %d GPUs (%s), Available in: %s",
+					name,
+					details.InstanceType.Specs.Gpus,
+					details.InstanceType.GpuDescription,
+					strings.Join(regionNames, ", "))
 			}
+		}
+		log.Warn("Run 'lm types' for the full list with prices.")
+		return fmt.Errorf("instance type '%s' not found", c.TypeName)
+	}
+	return nil
+}
 
-			if !found {
-				log.Warnf("Instance %s not found in list yet. Retrying (%d/%d)", instanceID, attempt+1, maxRetries)
-			}
+// renameInstances gives the instances of one launch, which share baseName,
+// the names returned by name(i). It returns the name of each instance by ID;
+// instances that could not be renamed keep baseName.
+func renameInstances(ctx context.Context, client *api.Client, ids []string, baseName string, name func(i int) string) map[string]string {
+	names := make(map[string]string, len(ids))
+	for i, id := range ids {
+		names[id] = baseName
+		newName := name(i)
+		if newName == baseName {
+			continue
 		}
+		if _, err := client.RenameInstance(ctx, id, newName); err != nil {
+			log.Warnf("Failed to rename instance %s to '%s', keeping '%s': %v", id, newName, baseName, err)
+			continue
+		}
+		names[id] = newName
+	}
+	return names
+}
 
-		if finalInstance.ID == "" {
-			return fmt.Errorf("instance %s did not become active or get an IP address after %d retries", instanceID, maxRetries)
+// instanceSummary describes how to reach an active instance, as printed by
+// create. setup is only suggested when the detach setup files are present.
+func instanceSummary(res launchResult, remoteUser string, setup bool) map[string]any {
+	inst := res.Instance
+	connectCmd := fmt.Sprintf("lm connect %s", inst.Name)
+	summary := map[string]any{
+		"instance_id":   inst.ID,
+		"instance_name": inst.Name,
+		"ip":            inst.IP,
+		"ssh":           fmt.Sprintf("ssh %s@%s", remoteUser, inst.IP),
+		"connect":       connectCmd,
+		"next":          connectCmd,
+	}
+	if res.HostKeyFingerprint != "" {
+		summary["host_key_fingerprint"] = res.HostKeyFingerprint
+		if path, err := configutil.KnownHostsPath(); err == nil {
+			summary["ssh"] = fmt.Sprintf("ssh -o UserKnownHostsFile=%s %s@%s", path, remoteUser, inst.IP)
 		}
+	}
+	if setup {
+		setupCmd := fmt.Sprintf("lm setup %s --detach", inst.Name)
+		summary["setup"] = setupCmd
+		summary["next"] = setupCmd
+	}
+	return summary
+}
 
-		// Build command suggestions
-		sshCmd := fmt.Sprintf("ssh %s@%s", configutil.RemoteUser, finalInstance.IP)
-		connectCmd := fmt.Sprintf("lm connect %s", finalInstance.Name)
-		homeDir, _ := os.UserHomeDir()
-		bwPassPath := filepath.Join(homeDir, "bw.pass")
-		setupCmd := fmt.Sprintf("lm setup %s --detach", finalInstance.Name)
+// activePollInterval and activePollAttempts bound how long create waits for
+// a launched instance: 40 * 30s = 20 minutes.
+var (
+	activePollInterval = 30 * time.Second
+	activePollAttempts = 40
+)
 
-		if jsonOutput {
-			output := map[string]interface{}{
-				"instance_id":   instanceID,
-				"instance_name": finalInstance.Name,
-				"ip":            finalInstance.IP,
-				"ssh":           sshCmd,
-				"connect":       connectCmd,
+// launchResult is the outcome of waiting for one launched instance.
+type launchResult struct {
+	ID       string
+	Instance api.Instance
+	Err      error
+	// Terminal is set when the instance ended up terminated or failed, so
+	// there is nothing left to clean up.
+	Terminal bool
+	// HostKeyFingerprint is the SHA256 fingerprint of the pinned host key,
+	// empty if it could not be captured.
+	HostKeyFingerprint string
+}
+
+// known returns what is known of the instance of res, named name: all of it
+// once it became active, else its ID and name.
+func (res launchResult) known(name string) api.Instance {
+	if res.Instance.ID != "" {
+		return res.Instance
+	}
+	return api.Instance{ID: res.ID, Name: name}
+}
+
+// Modes of create's --wait flag: how far create waits before it reports the
+// instances.
+const (
+	// waitNone returns as soon as the launch is accepted.
+	waitNone = "none"
+	// waitAPI waits until the API reports the instance active with an IP.
+	waitAPI = "api"
+	// waitSSH also waits until an SSH login with the configured key works.
+	waitSSH = "ssh"
+)
+
+// sshProbeInterval and sshProbeTimeout pace the SSH logins attempted by
+// waitForSSH.
+var (
+	sshProbeInterval = 5 * time.Second
+	sshProbeTimeout  = 10 * time.Second
+)
+
+// readiness says what waitForActive waits for once an instance is active.
+type readiness struct {
+	// HostKeyWait bounds the wait for the host key to pin, 0 to skip pinning.
+	HostKeyWait time.Duration
+	// Signer, if set, makes waitForActive also wait up to SSHTimeout until
+	// User can log in with it.
+	Signer     ssh.Signer
+	User       string
+	SSHTimeout time.Duration
+}
+
+// waitForActive polls the instances concurrently until each is active with
+// an IP address, enters a terminal status or runs out of attempts. Active
+// instances then get up to ready.HostKeyWait to present their SSH host key,
+// which is pinned, and, with ready.Signer, must accept an SSH login. Results
+// are in the order of ids.
+func waitForActive(ctx context.Context, client *api.Client, ids []string, ready readiness) []launchResult {
+	results := make([]launchResult, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = waitForInstance(ctx, client, id)
+			if results[i].Err != nil {
+				return
+			}
+			active := time.Now()
+			if ready.HostKeyWait > 0 {
+				results[i].HostKeyFingerprint = pinHostKey(ctx, results[i].Instance, ready.HostKeyWait)
 			}
-			nextCmd := connectCmd
-			if _, err := os.Stat(bwPassPath); err == nil {
-				output["setup"] = setupCmd
-				nextCmd = setupCmd
+			if ready.Signer != nil {
+				results[i].Err = waitForSSH(ctx, results[i].Instance, ready, active.Add(ready.SSHTimeout))
 			}
-			output["next"] = nextCmd
+		}()
+	}
+	wg.Wait()
+	return results
+}
 
-			jsonBytes, err := json.Marshal(output)
-			if err != nil {
-				return fmt.Errorf("failed to marshal JSON output: %w", err)
+// waitForSSH attempts SSH logins to inst until one succeeds or deadline
+// passes. sshd typically starts accepting connections a minute or two after
+// the API reports the instance active. A rejected host key is not retried.
+func waitForSSH(ctx context.Context, inst api.Instance, ready readiness, deadline time.Time) error {
+	log.Infof("Waiting for SSH login to '%s' (%s)", inst.Name, inst.IP)
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	for attempt := 1; ; attempt++ {
+		err := sshutil.ProbeSSH(ctx, inst.IP, ready.Signer, ready.User, sshProbeTimeout)
+		if err == nil {
+			log.Infof("SSH login to '%s' (%s) works", inst.Name, inst.IP)
+			return nil
+		}
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			if len(keyErr.Want) == 0 {
+				return fmt.Errorf("instance %s is active but its host key is not pinned, so SSH cannot be checked: %w", inst.ID, err)
 			}
-			fmt.Println(string(jsonBytes))
-		} else {
-			log.Println("--------------------------------------------------")
-			log.Infof("IMPORTANT: Connect manually once to add host key:")
-			log.Infof("  %s", sshCmd)
-			log.Infof("To connect:")
-			log.Infof("  %s", connectCmd)
-			if _, err := os.Stat(bwPassPath); err == nil {
-				log.Infof("To setup:")
-				log.Infof("  %s", setupCmd)
+			return fmt.Errorf("instance %s is active but presented a host key other than the pinned one: %w", inst.ID, err)
+		}
+		log.Debugf("SSH login to %s failed (attempt %d): %v", inst.IP, attempt, err)
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("instance %s is active but did not accept an SSH login within %s: %w", inst.ID, ready.SSHTimeout, err)
 			}
+			return fmt.Errorf("interrupted while waiting for SSH on instance %s: %w", inst.ID, ctx.Err())
+		case <-time.After(sshProbeInterval):
 		}
-		return nil
-	},
+	}
+}
+
+// pinHostKey captures and pins the host key of a freshly launched instance
+// and returns its fingerprint, or "" if sshd did not answer in time.
+func pinHostKey(ctx context.Context, inst api.Instance, wait time.Duration) string {
+	log.Infof("Waiting for the SSH host key of '%s' (%s)", inst.Name, inst.IP)
+	key, err := sshutil.CaptureHostKey(ctx, inst, wait)
+	if err != nil {
+		log.Warnf("Could not pin the host key of '%s': %v. 'lm connect' will refuse it until its host key is in ~/.ssh/known_hosts.", inst.Name, err)
+		return ""
+	}
+	fingerprint := ssh.FingerprintSHA256(key)
+	log.Infof("Pinned host key of '%s' (%s): %s %s", inst.Name, inst.IP, key.Type(), fingerprint)
+	return fingerprint
+}
+
+func waitForInstance(ctx context.Context, client *api.Client, id string) launchResult {
+	res := launchResult{ID: id}
+	for attempt := range activePollAttempts {
+		select {
+		case <-ctx.Done():
+			res.Err = fmt.Errorf("interrupted while waiting for instance %s to become active: %w", id, ctx.Err())
+			return res
+		case <-time.After(activePollInterval):
+		}
+		inst, err := client.GetInstance(ctx, id)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			if api.IsNotFound(err) {
+				log.Warnf("Instance %s not found yet. Retrying (%d/%d)", id, attempt+1, activePollAttempts)
+				continue
+			}
+			log.Warnf("Error fetching instance %s during poll: %v. Retrying", id, err)
+			continue
+		}
+
+		ipDisplay := inst.IP
+		if ipDisplay == "" || ipDisplay == "null" {
+			ipDisplay = "xxx.xxx.xxx.xxx"
+		}
+		log.Infof("Polling instance %s: Status=%-7s, IP=%-12s (%02d/%d)", id, inst.Status, ipDisplay, attempt+1, activePollAttempts)
+		if inst.Status == "active" && inst.IP != "" && inst.IP != "null" {
+			res.Instance = *inst
+			return res
+		}
+		if inst.Status == "terminated" || inst.Status == "failed" { // Assuming these are terminal states
+			res.Err = fmt.Errorf("instance %s entered status '%s'. Aborting", id, inst.Status)
+			res.Terminal = true
+			return res
+		}
+	}
+	res.Err = fmt.Errorf("instance %s did not become active or get an IP address after %d retries", id, activePollAttempts)
+	return res
 }
 
 func init() {
-	CreateCmd.Flags().String("prefix", "generic", "Prefix for the instance name")
+	CreateCmd.Flags().String("prefix", configutil.DefaultProfile().Prefix, "Prefix for the instance name (default from the active profile)")
 	CreateCmd.Flags().Int("max-instances-per-type", 2, "Maximum number of active instances allowed for the same GPU type")
+	CreateCmd.Flags().IntP("count", "n", 1, "Number of instances to launch; they are named <name>-1 ... <name>-N")
+	CreateCmd.Flags().String("regions", "", "Comma-separated regions or globs to try in order, e.g. us-east-1,us-* (default from the active profile)")
+	CreateCmd.Flags().Bool("wait-for-capacity", false, "Keep polling until the instance type has capacity in an allowed region, then launch")
+	CreateCmd.Flags().Duration("timeout", 6*time.Hour, "Give up waiting for capacity after this long, 0 to wait forever (with --wait-for-capacity)")
+	CreateCmd.Flags().Duration("poll", 20*time.Second, "How often to check for capacity (with --wait-for-capacity)")
+	CreateCmd.Flags().Duration("host-key-wait", 3*time.Minute, "How long to wait for a new instance's SSH server to pin its host key, 0 to skip")
+	CreateCmd.Flags().String("wait", waitSSH, "How far to wait before returning. One of: ssh (an SSH login works)|api (active with an IP)|none")
+	CreateCmd.Flags().Duration("ssh-timeout", 5*time.Minute, "How long to wait for an SSH login once an instance is active (with --wait=ssh)")
+	CreateCmd.Flags().Bool("setup", false, "Run 'lm setup' on the instance once it accepts SSH (needs an unlocked Bitwarden vault)")
+	CreateCmd.Flags().Bool("detach", false, "With --setup, perform aarnphm/detachtools's specific setup steps")
+	CreateCmd.Flags().Bool("engine", false, "With --setup, also set up engines (vllm, sglang)")
+	CreateCmd.Flags().Bool("connect", false, "Open a shell on the instance once it is ready (after --setup, if given)")
+	CreateCmd.Flags().String("filesystem", "", "File system to attach; may use the fields of filesystem_template, e.g. {{.User}}-{{.Region}} (default from the active profile)")
+	CreateCmd.Flags().String("ttl", "", "Terminate the instance(s) this long after launch, e.g. 6h (see 'lm ttl')")
+	CreateCmd.Flags().String("override-budget", "", "Launch even if it exceeds the budget in the config file; the reason is recorded in the local history")
+	CreateCmd.Flags().String("template", "", "Start from this launch template; flags given here override it")
+	CreateCmd.Flags().String("save-template", "", "Save the spec and flags given as a launch template with this name instead of launching")
+	CreateCmd.RegisterFlagCompletionFunc("template", completeTemplateNames)
+	CreateCmd.Flags().String("on-interrupt", onInterruptPrompt, "What to do with a launched instance if create is interrupted or times out. One of: prompt|terminate|keep")
+}
+
+// activeInstancesOfGPU describes the active instances whose GPU family
+// matches gpuType, e.g. h100 for both 1x_h100_pcie and 8x_h100_sxm5.
+func activeInstancesOfGPU(instances []api.Instance, gpuType string) []string {
+	requested, _, _ := strings.Cut(gpuType, "_")
+	var found []string
+	for _, inst := range instances {
+		// Only consider active instances
+		if inst.Status != "active" {
+			continue
+		}
+		// Extract GPU type from existing instance's type name (e.g., "gpu_1x_a100" -> "a100")
+		_, existingGpuType, ok := strings.Cut(inst.InstanceType.Name, "x_")
+		if !ok {
+			continue
+		}
+		existing, _, _ := strings.Cut(existingGpuType, "_")
+		if existing == requested {
+			found = append(found, fmt.Sprintf("  - Name: %s, ID: %s, IP: %s, Region: %s",
+				inst.Name, inst.ID, inst.IP, inst.Region.Name))
+		}
+	}
+	return found
+}
+
+// ensureFileSystem creates the named file system in region unless it exists.
+func ensureFileSystem(ctx context.Context, client *api.Client, name, region string) error {
+	log.Infof("Checking for filesystem '%s' in region '%s'", name, region)
+	fileSystems, err := client.ListFileSystems(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching filesystems: %w", err)
+	}
+	for _, fs := range fileSystems {
+		if fs.Name == name && fs.Region.Name == region {
+			log.Debugf("Using existing filesystem: %s", name)
+			return nil
+		}
+	}
+
+	log.Infof("Filesystem '%s' not found. Creating", name)
+	createdFS, err := client.CreateFileSystem(ctx, name, region)
+	if err != nil {
+		return fmt.Errorf("error creating filesystem '%s': %w", name, err)
+	}
+	if createdFS.Name != name {
+		log.Warnf("Filesystem creation response name mismatch (expected %s, got %s), proceeding", name, createdFS.Name)
+	}
+	log.Infof("Filesystem '%s' created successfully.", name)
+	return nil
+}
+
+// Policies for --on-interrupt.
+const (
+	onInterruptPrompt    = "prompt"
+	onInterruptTerminate = "terminate"
+	onInterruptKeep      = "keep"
+)
+
+// handleAbandonedLaunch deals with instances that were launched but never
+// reached a usable state because create was interrupted or timed out. Without
+// this the instances would keep running (and billing) with nothing pointing at them.
+// The returned error always wraps cause.
+func handleAbandonedLaunch(client *api.Client, instances []api.Instance, policy string, cause error) error {
+	instanceIDs := make([]string, len(instances))
+	for i, inst := range instances {
+		instanceIDs[i] = inst.ID
+	}
+	ids := strings.Join(instanceIDs, " ")
+	log.Warnf("Instance(s) %s were launched but create did not complete.", ids)
+
+	terminate := false
+	switch policy {
+	case onInterruptTerminate:
+		terminate = true
+	case onInterruptKeep:
+	default:
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			log.Warn("Not running interactively, keeping the instances. Use --on-interrupt=terminate to clean up automatically.")
+			break
+		}
+		terminate = confirm(fmt.Sprintf("Terminate instance(s) %s now?", ids))
+	}
+
+	if !terminate {
+		log.Warnf("Instance(s) %s still running. Delete with: lm delete <id>", ids)
+		return fmt.Errorf("%w (instances %s left running)", cause, ids)
+	}
+
+	// The command context is typically already cancelled at this point, so
+	// the cleanup gets its own deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	log.Infof("Terminating instance(s) %s", ids)
+	terminated, err := client.Terminate(ctx, instanceIDs...)
+	if err != nil {
+		log.Errorf("Failed to terminate instance(s) %s: %v", ids, err)
+		log.Errorf("Delete them manually with: lm delete <id>")
+		return fmt.Errorf("%w (terminating instances %s also failed: %v)", cause, ids, err)
+	}
+	done := make(map[string]bool, len(terminated))
+	for _, inst := range terminated {
+		done[inst.ID] = true
+	}
+	var gone []api.Instance
+	for _, inst := range instances {
+		if done[inst.ID] {
+			// An instance that got as far as pinning its host key must not
+			// leave the pin behind for the next instance at its IP.
+			forgetHostKey(inst)
+			gone = append(gone, inst)
+		}
+	}
+	recordTerminated(gone...)
+	if len(gone) < len(instances) {
+		log.Errorf("Termination of only %d of %d instance(s) was confirmed. Check with: lm list", len(gone), len(instances))
+		return fmt.Errorf("%w (termination of instances %s not confirmed)", cause, ids)
+	}
+	log.Infof("Termination initiated for instance(s) %s.", ids)
+	return fmt.Errorf("%w (instances %s terminated)", cause, ids)
+}
+
+// confirm asks a yes/no question on the terminal and defaults to no. A second
+// interrupt while waiting for an answer also counts as no.
+func confirm(question string) bool {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	answerCh := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answerCh <- strings.ToLower(strings.TrimSpace(line))
+	}()
+
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", question)
+	select {
+	case answer := <-answerCh:
+		return answer == "y" || answer == "yes"
+	case <-sigCh:
+		fmt.Fprintln(os.Stderr)
+		return false
+	}
 }
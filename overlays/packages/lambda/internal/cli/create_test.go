@@ -0,0 +1,312 @@
+package cli
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/configutil"
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/fakecloud"
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/sshutil"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+// isolateHome points the config, state and pinned host keys of the test at
+// an empty temporary directory, which it returns.
+func isolateHome(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	t.Setenv("LM_KNOWN_HOSTS", filepath.Join(dir, "known_hosts"))
+	t.Setenv("LM_PROFILE", "")
+	return dir
+}
+
+// newTestRoot returns a root command with the persistent flags of lm's,
+// running cmd against the API at apiURL with an empty config and state.
+func newTestRoot(t *testing.T, apiURL string, cmd *cobra.Command) *cobra.Command {
+	t.Helper()
+	dir := isolateHome(t)
+
+	root := &cobra.Command{Use: "lm", SilenceUsage: true, SilenceErrors: true}
+	flags := root.PersistentFlags()
+	flags.String("config", "", "")
+	flags.String("profile", "", "")
+	flags.String("api-key", "test-key", "")
+	flags.String("api-url", apiURL, "")
+	flags.String("ssh-key-name", "laptop", "")
+	flags.String("ssh-key-path", filepath.Join(dir, "missing-key"), "")
+	flags.StringP("output", "o", "table", "")
+	flags.Int("max-retries", 0, "")
+	flags.Duration("retry-budget", time.Minute, "")
+	root.AddCommand(cmd)
+	// Cobra only hands the root's context to a subcommand without one, and
+	// cmd and its subcommands are reused across tests.
+	var reset func(*cobra.Command)
+	reset = func(c *cobra.Command) {
+		c.SetContext(nil)
+		for _, sub := range c.Commands() {
+			reset(sub)
+		}
+	}
+	reset(cmd)
+	return root
+}
+
+func TestWaitForActive(t *testing.T) {
+	interval, attempts := activePollInterval, activePollAttempts
+	activePollInterval, activePollAttempts = time.Millisecond, 20
+	t.Cleanup(func() { activePollInterval, activePollAttempts = interval, attempts })
+
+	srv := fakecloud.New(fakecloud.Options{SSHKeyNames: []string{"laptop"}})
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+	client, err := api.NewClient("test-key", api.WithBaseURL(httpSrv.URL+fakecloud.BasePath))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	ctx := context.Background()
+	ids, err := client.Launch(ctx, api.LaunchRequest{
+		RegionName:       "us-east-1",
+		InstanceTypeName: "gpu_1x_a10",
+		SSHKeyNames:      []string{"laptop"},
+		Name:             "sweep",
+		Quantity:         3,
+	})
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	if _, err := client.Terminate(ctx, ids[1]); err != nil {
+		t.Fatalf("Terminate: %v", err)
+	}
+
+	results := waitForActive(ctx, client, append(ids, "missing"), readiness{})
+	if len(results) != 4 {
+		t.Fatalf("got %d results, want 4", len(results))
+	}
+	for _, i := range []int{0, 2} {
+		if results[i].Err != nil || results[i].Instance.Status != "active" {
+			t.Errorf("instance %d: want active, got %+v", i, results[i])
+		}
+	}
+	if results[1].Err == nil || !results[1].Terminal {
+		t.Errorf("terminated instance: want terminal error, got %+v", results[1])
+	}
+	if results[3].Err == nil || results[3].Terminal {
+		t.Errorf("missing instance: want non-terminal timeout error, got %+v", results[3])
+	}
+}
+
+// TestCreateWaitAPIOnFakeCloud runs the 'lm dev fake-cloud' demo: the fake
+// hands out unreachable IPs and no SSH key exists, so create must neither
+// wait for SSH nor need the key.
+func TestCreateWaitAPIOnFakeCloud(t *testing.T) {
+	interval := activePollInterval
+	activePollInterval = time.Millisecond
+	t.Cleanup(func() { activePollInterval = interval })
+
+	httpSrv := httptest.NewServer(fakecloud.New(fakecloud.Options{SSHKeyNames: []string{"laptop"}}))
+	defer httpSrv.Close()
+	apiURL := httpSrv.URL + fakecloud.BasePath
+	root := newTestRoot(t, apiURL, CreateCmd)
+	root.SetArgs([]string{"create", "1xa10", "--wait=api", "--host-key-wait=0"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := root.ExecuteContext(ctx); err != nil {
+		t.Fatalf("lm create: %v", err)
+	}
+	client, err := api.NewClient("test-key", api.WithBaseURL(apiURL))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	instances, err := client.ListInstances(ctx)
+	if err != nil {
+		t.Fatalf("ListInstances: %v", err)
+	}
+	if len(instances) != 1 || instances[0].Status != "active" {
+		t.Fatalf("instances = %+v, want one active", instances)
+	}
+	if rec, ok := loadHistory()[instances[0].ID]; !ok || rec.LaunchedAt.IsZero() || rec.APIURL != apiURL {
+		t.Errorf("history = %+v, want the launch against %s", rec, apiURL)
+	}
+}
+
+func TestCreateProfileInstanceTypes(t *testing.T) {
+	interval := activePollInterval
+	activePollInterval = time.Millisecond
+	t.Cleanup(func() { activePollInterval = interval })
+
+	httpSrv := httptest.NewServer(fakecloud.New(fakecloud.Options{SSHKeyNames: []string{"laptop"}}))
+	defer httpSrv.Close()
+	apiURL := httpSrv.URL + fakecloud.BasePath
+	root := newTestRoot(t, apiURL, CreateCmd)
+	// The H100s only have capacity outside the profile's regions.
+	profile := &configutil.Profile{InstanceTypes: []string{"8xH100_SXM5", "1xA10"}, Regions: []string{"us-east-1"}}
+	path, err := configutil.DefaultConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&configutil.Config{Profiles: map[string]*configutil.Profile{configutil.DefaultProfileName: profile}}).Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	root.SetArgs([]string{"create", "--wait=api", "--host-key-wait=0"})
+	if err := root.ExecuteContext(ctx); err != nil {
+		t.Fatalf("lm create without a spec: %v", err)
+	}
+	client, err := api.NewClient("test-key", api.WithBaseURL(apiURL))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	instances, err := client.ListInstances(ctx)
+	if err != nil {
+		t.Fatalf("ListInstances: %v", err)
+	}
+	if len(instances) != 1 || instances[0].InstanceType.Name != "gpu_1x_a10" || instances[0].Region.Name != "us-east-1" {
+		t.Fatalf("instances = %+v, want one gpu_1x_a10 in us-east-1", instances)
+	}
+}
+
+func TestCreateMaxInstancesPerType(t *testing.T) {
+	httpSrv := httptest.NewServer(fakecloud.New(fakecloud.Options{SSHKeyNames: []string{"laptop"}}))
+	defer httpSrv.Close()
+	apiURL := httpSrv.URL + fakecloud.BasePath
+	root := newTestRoot(t, apiURL, CreateCmd)
+	t.Cleanup(func() { CreateCmd.Flags().Set("max-instances-per-type", "2") })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	client, err := api.NewClient("test-key", api.WithBaseURL(apiURL))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := client.Launch(ctx, api.LaunchRequest{RegionName: "us-east-1", InstanceTypeName: "gpu_1x_a10", SSHKeyNames: []string{"laptop"}, Name: "busy"}); err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+
+	root.SetArgs([]string{"create", "1xa10", "--max-instances-per-type", "1", "--wait=api", "--host-key-wait=0"})
+	err = root.ExecuteContext(ctx)
+	if err == nil || !strings.Contains(err.Error(), "--max-instances-per-type=1") || !strings.Contains(err.Error(), "a10") {
+		t.Fatalf("lm create over the limit: %v, want an error naming the limit", err)
+	}
+	if instances, _ := client.ListInstances(ctx); len(instances) != 1 {
+		t.Errorf("got %d instances, want only the one already running", len(instances))
+	}
+}
+
+func TestWaitForSSHTimeout(t *testing.T) {
+	interval := sshProbeInterval
+	sshProbeInterval = 10 * time.Millisecond
+	t.Cleanup(func() { sshProbeInterval = interval })
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("LM_KNOWN_HOSTS", filepath.Join(t.TempDir(), "known_hosts"))
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 192.0.2.0/24 is reserved for documentation, so nothing answers.
+	inst := api.Instance{ID: "i-1", Name: "sweep", IP: "192.0.2.1"}
+	if err := sshutil.PinHostKey(inst, signer.PublicKey()); err != nil {
+		t.Fatalf("PinHostKey: %v", err)
+	}
+
+	ready := readiness{Signer: signer, User: "ubuntu", SSHTimeout: 200 * time.Millisecond}
+	start := time.Now()
+	err = waitForSSH(context.Background(), inst, ready, start.Add(ready.SSHTimeout))
+	if err == nil || !strings.Contains(err.Error(), "did not accept an SSH login") {
+		t.Fatalf("want SSH timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("waitForSSH took %s, want it to stop at the deadline", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = waitForSSH(ctx, inst, ready, time.Now().Add(time.Minute))
+	if err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Errorf("want interrupted error, got %v", err)
+	}
+}
+
+func TestHandleAbandonedLaunchForgetsHostKeys(t *testing.T) {
+	httpSrv := httptest.NewServer(fakecloud.New(fakecloud.Options{SSHKeyNames: []string{"laptop"}}))
+	defer httpSrv.Close()
+	isolateHome(t)
+	client, err := api.NewClient("test-key", api.WithBaseURL(httpSrv.URL+fakecloud.BasePath))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	ctx := context.Background()
+	ids, err := client.Launch(ctx, api.LaunchRequest{RegionName: "us-east-1", InstanceTypeName: "gpu_1x_a10", SSHKeyNames: []string{"laptop"}, Name: "sweep", Quantity: 2})
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	active, err := client.GetInstance(ctx, ids[0])
+	if err != nil || active.IP == "" {
+		t.Fatalf("GetInstance: %+v, %v", active, err)
+	}
+
+	// The first instance pinned its host key, then create was interrupted
+	// while it waited for SSH.
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sshutil.PinHostKey(*active, signer.PublicKey()); err != nil {
+		t.Fatalf("PinHostKey: %v", err)
+	}
+	abandoned := []api.Instance{*active, {ID: ids[1], Name: "sweep"}}
+	cause := errors.New("interrupted")
+	if err := handleAbandonedLaunch(client, abandoned, onInterruptTerminate, cause); !errors.Is(err, cause) || !strings.Contains(err.Error(), "terminated") {
+		t.Fatalf("handleAbandonedLaunch = %v", err)
+	}
+
+	if pinned, err := sshutil.PinnedHosts(); err != nil || len(pinned) != 0 {
+		t.Errorf("pinned hosts = %+v, %v; want the pin dropped", pinned, err)
+	}
+	history := loadHistory()
+	for _, id := range ids {
+		if rec, ok := history[id]; !ok || rec.TerminatedAt.IsZero() {
+			t.Errorf("%s: history %+v, want terminated", id, rec)
+		}
+	}
+}
+
+func TestCreatePipelineResumeCommand(t *testing.T) {
+	tests := []struct {
+		p    createPipeline
+		want string
+	}{
+		{createPipeline{Connect: true}, "lm connect box"},
+		{createPipeline{Setup: &setupOptions{NixUser: defaultNixUser}}, "lm setup box"},
+		{createPipeline{Setup: &setupOptions{Detach: true, NixUser: defaultNixUser}, Connect: true}, "lm setup box --detach && lm connect box"},
+		{createPipeline{Setup: &setupOptions{Engine: true, NixUser: "bob"}}, "lm setup box --engine --user bob"},
+	}
+	for _, tt := range tests {
+		if got := tt.p.resumeCommand("box"); got != tt.want {
+			t.Errorf("resumeCommand() = %q, want %q", got, tt.want)
+		}
+	}
+}
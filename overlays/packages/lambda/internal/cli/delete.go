@@ -5,7 +5,8 @@ import (
 	"fmt"
 	"strings"
 
-	api "github.com/aarnphm/detachtools/overlays/packages/lambda/internal/apiclient"
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/sshutil"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -27,45 +28,15 @@ var DeleteCmd = &cobra.Command{
 		}
 
 		// 1. Find Instance by ID or Name
-		apiKey, _ := cmd.Root().PersistentFlags().GetString("api-key")
-		client, err := api.NewAPIClient(apiKey)
+		ctx := cmd.Context()
+		client, err := newAPIClient(cmd)
 		if err != nil {
 			return fmt.Errorf("failed to create API client: %w", err)
 		}
 		log.Debugf("Looking for instance '%s' to delete", instanceIdentifier)
-		var instancesResp api.InstancesResponse
-		err = client.Request("GET", "/instances", nil, &instancesResp)
+		targetInstance, err := client.ResolveInstance(ctx, instanceIdentifier)
 		if err != nil {
-			return fmt.Errorf("error fetching instances: %w", err)
-		}
-
-		var targetInstance *api.Instance
-		// First, try matching by ID
-		for i := range instancesResp.Data {
-			if instancesResp.Data[i].ID == instanceIdentifier {
-				targetInstance = &instancesResp.Data[i]
-				log.Debugf("Found instance by ID: %s", instanceIdentifier)
-				break
-			}
-		}
-		// If not found by ID, try matching by Name
-		if targetInstance == nil {
-			log.Debugf("Instance not found by ID '%s', trying by name", instanceIdentifier)
-			for i := range instancesResp.Data {
-				if instancesResp.Data[i].Name == instanceIdentifier {
-					// Check for ambiguity (multiple instances with the same name)
-					if targetInstance != nil {
-						return fmt.Errorf("multiple instances found with the name '%s'. Please use the unique instance ID instead", instanceIdentifier)
-					}
-					targetInstance = &instancesResp.Data[i]
-					log.Debugf("Found instance by name: %s", instanceIdentifier)
-					// Don't break here, continue to check for duplicates
-				}
-			}
-		}
-
-		if targetInstance == nil {
-			return fmt.Errorf("no instance found with name or ID '%s'. Cannot delete", instanceIdentifier)
+			return err
 		}
 
 		instanceID := targetInstance.ID
@@ -74,18 +45,14 @@ var DeleteCmd = &cobra.Command{
 			instanceName, instanceID, targetInstance.Status)
 
 		// 2. Send Terminate Request
-		terminateReq := api.TerminateRequest{
-			InstanceIDs: []string{instanceID},
-		}
-		var terminateResp api.TerminateResponse
-		err = client.Request("POST", "/instance-operations/terminate", terminateReq, &terminateResp)
+		terminatedInstances, err := client.Terminate(ctx, instanceID)
 		if err != nil {
 			return fmt.Errorf("error sending terminate request for instance '%s' (ID: %s): %w", instanceName, instanceID, err)
 		}
 
 		// 3. Verify Response
 		terminated := false
-		for _, terminatedInstance := range terminateResp.Data.TerminatedInstances {
+		for _, terminatedInstance := range terminatedInstances {
 			if terminatedInstance.ID == instanceID {
 				terminated = true
 				break
@@ -93,6 +60,8 @@ var DeleteCmd = &cobra.Command{
 		}
 
 		if terminated {
+			forgetHostKey(*targetInstance)
+			recordTerminated(*targetInstance)
 			if jsonOutput {
 				resp := map[string]interface{}{
 					"instance_id":   instanceID,
@@ -107,9 +76,20 @@ var DeleteCmd = &cobra.Command{
 			}
 		} else {
 			log.Errorf("Failed to confirm termination for instance '%s' (ID: %s)", instanceName, instanceID)
-			log.Debugf("API Response Data: %+v", terminateResp.Data)
+			log.Debugf("API Response Data: %+v", terminatedInstances)
 			return fmt.Errorf("failed to confirm termination for instance '%s' (ID: %s)", instanceName, instanceID)
 		}
 		return nil
 	},
 }
+
+// forgetHostKey drops the pinned host key of a terminated instance. Its IP
+// will be handed to another machine with a different key.
+func forgetHostKey(inst api.Instance) {
+	if inst.IP == "" {
+		return
+	}
+	if err := sshutil.UnpinHostKey(inst.IP); err != nil {
+		log.Warnf("Failed to remove the pinned host key of '%s' (%s): %v", inst.Name, inst.IP, err)
+	}
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/fakecloud"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var DevCmd = &cobra.Command{
+	Use:   "dev",
+	Short: "Developer utilities for working on lm",
+}
+
+var devFakeCloudCmd = &cobra.Command{
+	Use:   "fake-cloud",
+	Short: "Serve an in-memory fake of the Lambda Cloud API",
+	Long: `Serve an in-memory fake of the Lambda Cloud API for demos and offline testing.
+
+The fake emulates instances, instance types with per-region capacity, file
+systems, SSH keys, images and firewall rules. Launched instances move from
+booting to active after --boot-delay, and terminated ones from terminating to
+terminated after --terminate-delay. All state is lost on exit.
+
+Point lm at it from another shell. The fake IPs do not answer SSH, so tell
+create not to wait for it:
+
+  $ lm dev fake-cloud
+  $ export LAMBDA_API_URL=http://127.0.0.1:8787/api/v1 LAMBDA_API_KEY=fake
+  $ lm create 1xa10 --wait=api --host-key-wait=0`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		listen, _ := cmd.Flags().GetString("listen")
+		bootDelay, _ := cmd.Flags().GetDuration("boot-delay")
+		terminateDelay, _ := cmd.Flags().GetDuration("terminate-delay")
+		requiredKey, _ := cmd.Flags().GetString("require-api-key")
+		sshKeyName, _ := cmd.Root().PersistentFlags().GetString("ssh-key-name")
+
+		server := fakecloud.New(fakecloud.Options{
+			APIKey:         requiredKey,
+			BootDelay:      bootDelay,
+			TerminateDelay: terminateDelay,
+			SSHKeyNames:    []string{sshKeyName},
+		})
+
+		listener, err := net.Listen("tcp", listen)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", listen, err)
+		}
+		httpServer := &http.Server{Handler: server, ReadHeaderTimeout: 10 * time.Second}
+
+		apiKeyHint := requiredKey
+		if apiKeyHint == "" {
+			apiKeyHint = "fake"
+		}
+		log.Infof("Fake Lambda Cloud API listening on %s (SSH key '%s' pre-registered)", listener.Addr(), sshKeyName)
+		fmt.Printf("export LAMBDA_API_URL=http://%s%s LAMBDA_API_KEY=%s\n", listener.Addr(), fakecloud.BasePath, apiKeyHint)
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- httpServer.Serve(listener) }()
+
+		select {
+		case err := <-errCh:
+			return fmt.Errorf("fake cloud server stopped: %w", err)
+		case <-ctx.Done():
+		}
+		log.Info("Shutting down fake Lambda Cloud API.")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("failed to shut down fake cloud server: %w", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	devFakeCloudCmd.Flags().String("listen", "127.0.0.1:8787", "Address to listen on")
+	devFakeCloudCmd.Flags().Duration("boot-delay", 20*time.Second, "Time an instance spends booting before it becomes active")
+	devFakeCloudCmd.Flags().Duration("terminate-delay", 10*time.Second, "Time an instance spends terminating")
+	devFakeCloudCmd.Flags().String("require-api-key", "", "Only accept this API key (any non-empty key is accepted by default)")
+	DevCmd.AddCommand(devFakeCloudCmd)
+}
@@ -0,0 +1,288 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var FSCmd = &cobra.Command{
+	Use:     "fs",
+	Aliases: []string{"filesystem", "filesystems"},
+	Short:   "Manage persistent file systems",
+}
+
+// fileSystemView is a file system together with the instances mounting it.
+type fileSystemView struct {
+	api.FileSystem
+	Instances []attachedInstance `json:"instances"`
+}
+
+type attachedInstance struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	IP     string `json:"ip,omitempty"`
+}
+
+// listFileSystemViews fetches file systems and instances and joins them.
+func listFileSystemViews(cmd *cobra.Command, client *api.Client) ([]fileSystemView, error) {
+	ctx := cmd.Context()
+	fileSystems, err := client.ListFileSystems(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching filesystems: %w", err)
+	}
+	instances, err := client.ListInstances(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching instances: %w", err)
+	}
+	views := make([]fileSystemView, 0, len(fileSystems))
+	for _, fs := range fileSystems {
+		views = append(views, newFileSystemView(fs, instances))
+	}
+	return views, nil
+}
+
+func newFileSystemView(fs api.FileSystem, instances []api.Instance) fileSystemView {
+	view := fileSystemView{FileSystem: fs, Instances: []attachedInstance{}}
+	for _, inst := range api.MountedBy(fs, instances) {
+		view.Instances = append(view.Instances, attachedInstance{ID: inst.ID, Name: inst.Name, Status: inst.Status, IP: inst.IP})
+	}
+	return view
+}
+
+var fsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List file systems and the instances using them",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonOutput, err := jsonOutputFlag(cmd)
+		if err != nil {
+			return err
+		}
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error initializing API client: %w", err)
+		}
+		views, err := listFileSystemViews(cmd, client)
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput {
+			return printJSON(views)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tID\tREGION\tUSED\tINSTANCES")
+		for _, v := range views {
+			names := make([]string, 0, len(v.Instances))
+			for _, inst := range v.Instances {
+				names = append(names, inst.Name)
+			}
+			attached := "-"
+			if len(names) > 0 {
+				attached = strings.Join(names, ",")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", v.Name, v.ID, v.Region.Name, formatBytes(v.BytesUsed), attached)
+		}
+		return w.Flush()
+	},
+}
+
+var fsDescribeCmd = &cobra.Command{
+	Use:               "describe <name_or_id>",
+	Short:             "Show details of a file system",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeFileSystemNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonOutput, err := jsonOutputFlag(cmd)
+		if err != nil {
+			return err
+		}
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error initializing API client: %w", err)
+		}
+		view, err := resolveFileSystemView(cmd, client, args[0])
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput {
+			return printJSON(view)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Name:\t%s\n", view.Name)
+		fmt.Fprintf(w, "ID:\t%s\n", view.ID)
+		fmt.Fprintf(w, "Region:\t%s\n", view.Region.Name)
+		fmt.Fprintf(w, "Mount point:\t%s\n", view.MountPoint)
+		fmt.Fprintf(w, "Created:\t%s\n", view.Created)
+		fmt.Fprintf(w, "Used:\t%s\n", formatBytes(view.BytesUsed))
+		fmt.Fprintf(w, "In use:\t%t\n", view.IsInUse)
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if len(view.Instances) == 0 {
+			fmt.Println("Instances: none")
+			return nil
+		}
+		fmt.Println("Instances:")
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  NAME\tID\tSTATUS\tIP_ADDRESS")
+		for _, inst := range view.Instances {
+			ip := inst.IP
+			if ip == "" {
+				ip = "-"
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", inst.Name, inst.ID, inst.Status, ip)
+		}
+		return w.Flush()
+	},
+}
+
+var fsCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a persistent file system",
+	Long: `Create a persistent file system. Without --region, the first region of the
+active profile is used; a pattern such as us-* picks the first region matching
+it that has capacity for some instance type.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonOutput, err := jsonOutputFlag(cmd)
+		if err != nil {
+			return err
+		}
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error initializing API client: %w", err)
+		}
+		region, _ := cmd.Flags().GetString("region")
+		if region == "" {
+			_, profile, err := ActiveProfile(cmd)
+			if err != nil {
+				return err
+			}
+			if len(profile.Regions) == 0 {
+				return fmt.Errorf("no --region given and the active profile has no regions")
+			}
+			instanceTypes, err := client.ListInstanceTypes(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("error fetching instance types: %w", err)
+			}
+			var known []api.Region
+			for _, details := range instanceTypes {
+				known = append(known, details.RegionsWithCapacity...)
+			}
+			if region = resolveRegion(known, profile.Regions); region == "" {
+				return fmt.Errorf("no region with capacity matches the profile's regions %s; pass --region", strings.Join(profile.Regions, ","))
+			}
+			log.Debugf("Using region '%s' from the profile's regions %s", region, strings.Join(profile.Regions, ","))
+		}
+		fs, err := client.CreateFileSystem(cmd.Context(), args[0], region)
+		if err != nil {
+			return fmt.Errorf("failed to create filesystem '%s' in region '%s': %w", args[0], region, err)
+		}
+
+		if jsonOutput {
+			return printJSON(fs)
+		}
+		log.Infof("Created filesystem '%s' (ID: %s) in region '%s'", fs.Name, fs.ID, fs.Region.Name)
+		return nil
+	},
+}
+
+var fsDeleteCmd = &cobra.Command{
+	Use:               "delete <name_or_id>",
+	Short:             "Delete a file system and all data on it",
+	Aliases:           []string{"rm"},
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeFileSystemNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonOutput, err := jsonOutputFlag(cmd)
+		if err != nil {
+			return err
+		}
+		force, _ := cmd.Flags().GetBool("force")
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error initializing API client: %w", err)
+		}
+		view, err := resolveFileSystemView(cmd, client, args[0])
+		if err != nil {
+			return err
+		}
+		if len(view.Instances) > 0 {
+			var names []string
+			for _, inst := range view.Instances {
+				names = append(names, fmt.Sprintf("%s (%s)", inst.Name, inst.Status))
+			}
+			if !force {
+				return fmt.Errorf("filesystem '%s' is mounted by %s. Terminate those instances first or pass --force", view.Name, strings.Join(names, ", "))
+			}
+			log.Warnf("Filesystem '%s' is mounted by %s; deleting anyway because of --force", view.Name, strings.Join(names, ", "))
+		}
+
+		if err := client.DeleteFileSystem(cmd.Context(), view.ID); err != nil {
+			return fmt.Errorf("failed to delete filesystem '%s' (ID: %s): %w", view.Name, view.ID, err)
+		}
+
+		if jsonOutput {
+			return printJSON(map[string]any{
+				"id":     view.ID,
+				"name":   view.Name,
+				"region": view.Region.Name,
+				"action": "delete",
+			})
+		}
+		log.Infof("Deleted filesystem '%s' (ID: %s)", view.Name, view.ID)
+		return nil
+	},
+}
+
+// resolveFileSystemView finds a file system by name or ID with its mounts.
+func resolveFileSystemView(cmd *cobra.Command, client *api.Client, nameOrID string) (*fileSystemView, error) {
+	ctx := cmd.Context()
+	fileSystems, err := client.ListFileSystems(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching filesystems: %w", err)
+	}
+	fs, err := api.FindFileSystem(fileSystems, nameOrID)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := client.ListInstances(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching instances: %w", err)
+	}
+	view := newFileSystemView(*fs, instances)
+	return &view, nil
+}
+
+// formatBytes renders a byte count with a binary unit suffix.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func init() {
+	fsCreateCmd.Flags().String("region", "", "Region to create the file system in (default: first region of the active profile)")
+	fsDeleteCmd.Flags().BoolP("force", "f", false, "Delete even if instances have the file system mounted")
+
+	FSCmd.AddCommand(fsListCmd)
+	FSCmd.AddCommand(fsDescribeCmd)
+	FSCmd.AddCommand(fsCreateCmd)
+	FSCmd.AddCommand(fsDeleteCmd)
+}
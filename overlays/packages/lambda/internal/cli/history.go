@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/configutil"
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/state"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+	log "github.com/sirupsen/logrus"
+)
+
+// openHistory returns the local instance history.
+func openHistory() (*state.Store, error) {
+	dir, err := configutil.StateDir()
+	if err != nil {
+		return nil, fmt.Errorf("locating lm state directory: %w", err)
+	}
+	return state.Open(dir), nil
+}
+
+// loadHistory returns the recorded history of every instance by ID. The
+// history is bookkeeping, so a failure to read it is logged and yields none.
+func loadHistory() map[string]*state.Instance {
+	store, err := openHistory()
+	if err == nil {
+		var history map[string]*state.Instance
+		if history, err = store.Instances(); err == nil {
+			return history
+		}
+	}
+	log.Warnf("Could not read the local instance history: %v", err)
+	return map[string]*state.Instance{}
+}
+
+// recordEvents appends events to the local instance history. Like
+// loadHistory it only logs failures: the instances are what matters.
+func recordEvents(events ...state.Event) {
+	if len(events) == 0 {
+		return
+	}
+	store, err := openHistory()
+	if err == nil {
+		err = store.Append(events...)
+	}
+	if err != nil {
+		log.Warnf("Could not record %s in the local instance history: %v", events[0].Kind, err)
+	}
+}
+
+// compactHistory drops the idle samples later ones supersede from the local
+// history, so that sampling on every 'lm reap --idle' does not grow it.
+func compactHistory() {
+	store, err := openHistory()
+	if err == nil {
+		err = store.Compact()
+	}
+	if err != nil {
+		log.Warnf("Could not compact the local instance history: %v", err)
+	}
+}
+
+// recordLaunch records the instances of one launch with the price of their
+// type at launch, and the profile and API they were launched with.
+func recordLaunch(ids []string, names map[string]string, choice *placement, priceCentsPerHour int, profileName, apiURL, template string) {
+	user := configutil.LocalUser()
+	events := make([]state.Event, 0, len(ids))
+	for _, id := range ids {
+		events = append(events, state.Event{
+			Kind:              state.Launched,
+			InstanceID:        id,
+			InstanceName:      names[id],
+			InstanceType:      choice.InstanceType,
+			Region:            choice.Region,
+			PriceCentsPerHour: priceCentsPerHour,
+			User:              user,
+			Profile:           profileName,
+			APIURL:            apiURL,
+			Template:          template,
+		})
+	}
+	recordEvents(events...)
+}
+
+// recordClusterNodes records the instances of a cluster launch, ids in rank
+// order, as the nodes of cluster.
+func recordClusterNodes(cluster string, ids []string, names map[string]string) {
+	events := make([]state.Event, 0, len(ids))
+	for rank, id := range ids {
+		events = append(events, state.Event{Kind: state.ClusterNode, InstanceID: id, InstanceName: names[id], Cluster: cluster, ClusterRank: rank})
+	}
+	recordEvents(events...)
+}
+
+// recordTerminated records the termination of instances.
+func recordTerminated(instances ...api.Instance) {
+	events := make([]state.Event, 0, len(instances))
+	for _, inst := range instances {
+		events = append(events, state.Event{Kind: state.Terminated, InstanceID: inst.ID, InstanceName: inst.Name})
+	}
+	recordEvents(events...)
+}
+
+// recordTTL records when instances expire; a zero expiresAt clears their TTL.
+func recordTTL(expiresAt time.Time, instances ...api.Instance) {
+	events := make([]state.Event, 0, len(instances))
+	for _, inst := range instances {
+		events = append(events, state.Event{Kind: state.TTL, InstanceID: inst.ID, InstanceName: inst.Name, ExpiresAt: expiresAt})
+	}
+	recordEvents(events...)
+}
+
+// formatUptime renders d compactly, e.g. 45m, 3h05m or 2d04h.
+func formatUptime(d time.Duration) string {
+	d = d.Truncate(time.Minute)
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	default:
+		return fmt.Sprintf("%dd%02dh", int(d.Hours())/24, int(d.Hours())%24)
+	}
+}
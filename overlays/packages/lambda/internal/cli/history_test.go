@@ -0,0 +1,19 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatUptime(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		30 * time.Second:              "0m",
+		45 * time.Minute:              "45m",
+		3*time.Hour + 5*time.Minute:   "3h05m",
+		52*time.Hour + 59*time.Minute: "2d04h",
+	} {
+		if got := formatUptime(d); got != want {
+			t.Errorf("formatUptime(%s) = %q, want %q", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/sshutil"
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/state"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// An instance is idle while every GPU is at most idleGPUUtilization percent
+// busy and holds at most idleGPUMemoryMiB, nobody is logged in and the
+// 1-minute load average is below idleLoad.
+const (
+	idleGPUUtilization = 5
+	idleGPUMemoryMiB   = 1024
+	idleLoad           = 1.0
+)
+
+// idleSampleCommand prints what parseIdleSample reads: one CSV line per GPU,
+// a separator, the number of login sessions and /proc/loadavg.
+const idleSampleCommand = "nvidia-smi --query-gpu=utilization.gpu,memory.used --format=csv,noheader,nounits && echo --- && who | wc -l && cat /proc/loadavg"
+
+// idleSampleTimeout bounds the SSH login and command of one sample, and
+// idleSampleParallelism the instances sampled at once.
+var (
+	idleSampleTimeout     = 30 * time.Second
+	idleSampleParallelism = 8
+)
+
+// idleSample is how busy an instance was at one point.
+type idleSample struct {
+	// GPUUtilization and GPUMemoryMiB are those of the busiest GPU.
+	GPUUtilization int
+	GPUMemoryMiB   int
+	Sessions       int
+	Load           float64
+}
+
+// Idle reports whether the sample shows an instance nobody uses.
+func (s idleSample) Idle() bool {
+	return s.GPUUtilization <= idleGPUUtilization && s.GPUMemoryMiB <= idleGPUMemoryMiB && s.Sessions == 0 && s.Load < idleLoad
+}
+
+func (s idleSample) String() string {
+	return fmt.Sprintf("GPU %d%%, %d MiB, %d session(s), load %.2f", s.GPUUtilization, s.GPUMemoryMiB, s.Sessions, s.Load)
+}
+
+// parseIdleSample parses the output of idleSampleCommand.
+func parseIdleSample(out string) (idleSample, error) {
+	var s idleSample
+	gpus, rest, ok := strings.Cut(out, "---\n")
+	if !ok {
+		return s, fmt.Errorf("unexpected sample output %q", out)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(gpus), "\n") {
+		util, mem, ok := strings.Cut(line, ",")
+		u, errU := strconv.Atoi(strings.TrimSpace(util))
+		m, errM := strconv.Atoi(strings.TrimSpace(mem))
+		if !ok || errU != nil || errM != nil {
+			return s, fmt.Errorf("unexpected nvidia-smi output %q", line)
+		}
+		s.GPUUtilization = max(s.GPUUtilization, u)
+		s.GPUMemoryMiB = max(s.GPUMemoryMiB, m)
+	}
+	fields := strings.Fields(rest)
+	if len(fields) < 2 {
+		return s, fmt.Errorf("unexpected sample output %q", rest)
+	}
+	var err error
+	if s.Sessions, err = strconv.Atoi(fields[0]); err != nil {
+		return s, fmt.Errorf("unexpected session count %q", fields[0])
+	}
+	if s.Load, err = strconv.ParseFloat(fields[1], 64); err != nil {
+		return s, fmt.Errorf("unexpected load average %q", fields[1])
+	}
+	return s, nil
+}
+
+// sampleInstance logs in to inst and samples how busy it is.
+func sampleInstance(ctx context.Context, conn clusterSSH, inst api.Instance) (idleSample, error) {
+	ctx, cancel := context.WithTimeout(ctx, idleSampleTimeout)
+	defer cancel()
+	client, err := dialInstance(ctx, &inst, conn.KeyPath, conn.User, conn.KeyName)
+	if err != nil {
+		return idleSample{}, err
+	}
+	defer client.Close()
+	var out bytes.Buffer
+	if err := sshutil.StreamRemoteCommand(ctx, client, idleSampleCommand, &out, io.Discard); err != nil {
+		return idleSample{}, fmt.Errorf("sampling failed: %w", err)
+	}
+	return parseIdleSample(out.String())
+}
+
+// nextIdleSince returns since when an instance has been idle given a new
+// sample taken at now: when its current run of idle samples began, or zero
+// if it is busy. Samples further apart than threshold start a new run, since
+// the instance may have been busy in between.
+func nextIdleSince(rec *state.Instance, s idleSample, now time.Time, threshold time.Duration) time.Time {
+	switch {
+	case !s.Idle():
+		return time.Time{}
+	case rec == nil || rec.IdleSince.IsZero() || now.Sub(rec.SampledAt) > threshold:
+		return now
+	default:
+		return rec.IdleSince
+	}
+}
+
+// parseNamePatterns splits a comma-separated list of instance names or glob
+// patterns such as dev-* and validates the patterns.
+func parseNamePatterns(list string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid name pattern '%s': %w", p, err)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// idleExempt reports why inst is exempt from idle reaping, or "".
+func idleExempt(inst api.Instance, rec *state.Instance, patterns []string) string {
+	if rec != nil && rec.Protected {
+		return "protected"
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, inst.Name); ok {
+			return fmt.Sprintf("matches --exempt '%s'", p)
+		}
+	}
+	return ""
+}
+
+// sampleIdle samples the active instances that are not exempt. It appends
+// the samples to the local event log and compacts it, updates the records in
+// history to match, and returns the instances idle for at least threshold.
+func sampleIdle(ctx context.Context, conn clusterSSH, instances []api.Instance, history map[string]*state.Instance, exempt []string, threshold time.Duration) []reapTarget {
+	var sampled []api.Instance
+	for _, inst := range instances {
+		if inst.Status != "active" || inst.IP == "" {
+			continue
+		}
+		if why := idleExempt(inst, history[inst.ID], exempt); why != "" {
+			log.Debugf("Not sampling '%s': %s", inst.Name, why)
+			continue
+		}
+		sampled = append(sampled, inst)
+	}
+
+	samples := make([]idleSample, len(sampled))
+	errs := make([]error, len(sampled))
+	sem := make(chan struct{}, idleSampleParallelism)
+	var wg sync.WaitGroup
+	for i, inst := range sampled {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			samples[i], errs[i] = sampleInstance(ctx, conn, inst)
+		}()
+	}
+	wg.Wait()
+
+	now := time.Now()
+	var events []state.Event
+	var targets []reapTarget
+	for i, inst := range sampled {
+		if errs[i] != nil {
+			log.Warnf("Could not sample '%s': %v", inst.Name, errs[i])
+			continue
+		}
+		s := samples[i]
+		since := nextIdleSince(history[inst.ID], s, now, threshold)
+		events = append(events, state.Event{
+			Time:           now,
+			Kind:           state.Sampled,
+			InstanceID:     inst.ID,
+			InstanceName:   inst.Name,
+			GPUUtilization: s.GPUUtilization,
+			GPUMemoryMiB:   s.GPUMemoryMiB,
+			Sessions:       s.Sessions,
+			Load:           s.Load,
+			IdleSince:      since,
+		})
+		rec, ok := history[inst.ID]
+		if !ok {
+			rec = &state.Instance{ID: inst.ID, Name: inst.Name}
+			history[inst.ID] = rec
+		}
+		rec.SampledAt, rec.IdleSince = now, since
+		log.Infof("Instance '%s': %s, %s", inst.Name, s, idleState(since, now))
+		if !since.IsZero() && now.Sub(since) >= threshold {
+			reason := fmt.Sprintf("idle for %s (%s)", formatUptime(now.Sub(since)), s)
+			targets = append(targets, newReapTarget(inst, reason, since))
+		}
+	}
+	recordEvents(events...)
+	compactHistory()
+	return targets
+}
+
+func idleState(since, now time.Time) string {
+	if since.IsZero() {
+		return "busy"
+	}
+	return "idle for " + formatUptime(now.Sub(since))
+}
+
+var ProtectCmd = &cobra.Command{
+	Use:               "protect <instance_name_or_id>",
+	Short:             "Exempt an instance from 'lm reap --idle'",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeInstanceNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		off, _ := cmd.Flags().GetBool("off")
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error initializing API client: %w", err)
+		}
+		inst, err := client.ResolveInstance(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+		recordEvents(state.Event{Kind: state.Protected, InstanceID: inst.ID, InstanceName: inst.Name, Protected: !off})
+		if off {
+			log.Infof("Instance '%s' is no longer protected from idle reaping.", inst.Name)
+			return nil
+		}
+		log.Infof("Instance '%s' is protected from idle reaping. Undo with: lm protect %s --off", inst.Name, inst.Name)
+		return nil
+	},
+}
+
+func init() {
+	ProtectCmd.Flags().Bool("off", false, "Remove the protection")
+}
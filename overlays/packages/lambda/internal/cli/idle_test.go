@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/state"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+)
+
+func TestParseIdleSample(t *testing.T) {
+	out := "0, 3\n12, 40210\n---\n0\n0.08 0.12 0.10 1/612 48213\n"
+	s, err := parseIdleSample(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (idleSample{GPUUtilization: 12, GPUMemoryMiB: 40210, Load: 0.08}); s != want {
+		t.Fatalf("sample = %+v, want %+v", s, want)
+	}
+	if s.Idle() {
+		t.Error("a sample with a busy GPU is idle")
+	}
+
+	for _, bad := range []string{
+		"",
+		"0, 3\n0\n0.08 0.12 0.10 1/612 48213\n",
+		"NVIDIA-SMI has failed\n---\n0\n0.08 0.12 0.10 1/612 48213\n",
+		"0, 3\n---\n0\n",
+		"0, 3\n---\nmany\n0.08 0.12 0.10 1/612 48213\n",
+	} {
+		if _, err := parseIdleSample(bad); err == nil {
+			t.Errorf("parseIdleSample(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestIdleSampleIdle(t *testing.T) {
+	idle := idleSample{GPUUtilization: 2, GPUMemoryMiB: 500, Load: 0.3}
+	if !idle.Idle() {
+		t.Errorf("%s is not idle", idle)
+	}
+	for _, busy := range []idleSample{
+		{GPUUtilization: 50},
+		{GPUMemoryMiB: 30000},
+		{Sessions: 1},
+		{Load: 4},
+	} {
+		if busy.Idle() {
+			t.Errorf("%s is idle", busy)
+		}
+	}
+}
+
+func TestNextIdleSince(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	threshold := 45 * time.Minute
+	idle, busy := idleSample{}, idleSample{Sessions: 1}
+	started := now.Add(-30 * time.Minute)
+	recent := &state.Instance{SampledAt: now.Add(-5 * time.Minute), IdleSince: started}
+
+	for _, tc := range []struct {
+		name string
+		rec  *state.Instance
+		s    idleSample
+		want time.Time
+	}{
+		{"first sample", nil, idle, now},
+		{"busy", recent, busy, time.Time{}},
+		{"still idle", recent, idle, started},
+		{"idle after busy", &state.Instance{SampledAt: now.Add(-5 * time.Minute)}, idle, now},
+		{"samples too far apart", &state.Instance{SampledAt: now.Add(-time.Hour), IdleSince: started}, idle, now},
+	} {
+		if got := nextIdleSince(tc.rec, tc.s, now, threshold); !got.Equal(tc.want) {
+			t.Errorf("%s: idle since %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestIdleExempt(t *testing.T) {
+	patterns, err := parseNamePatterns("dev-*, notebook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	protected := &state.Instance{Protected: true}
+	for _, tc := range []struct {
+		name   string
+		rec    *state.Instance
+		exempt bool
+	}{
+		{"dev-alice", nil, true},
+		{"notebook", nil, true},
+		{"train-1", nil, false},
+		{"train-2", protected, true},
+	} {
+		if got := idleExempt(api.Instance{Name: tc.name}, tc.rec, patterns); (got != "") != tc.exempt {
+			t.Errorf("idleExempt(%s) = %q", tc.name, got)
+		}
+	}
+	if _, err := parseNamePatterns("dev-["); err == nil {
+		t.Error("parseNamePatterns accepted a malformed pattern")
+	}
+}
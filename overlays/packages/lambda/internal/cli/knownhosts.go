@@ -0,0 +1,202 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/configutil"
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/sshutil"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+var KnownHostsCmd = &cobra.Command{
+	Use:   "known-hosts",
+	Short: "Manage the host keys lm pinned for its instances",
+	Long: `Manage the host keys lm pinned for its instances. 'lm create' pins the host
+key of every instance it launches; 'lm delete' removes it again.`,
+}
+
+// pinView is a pinned host key together with what lives at its address now.
+type pinView struct {
+	sshutil.PinnedHost
+	Status string `json:"status"`
+	// Stale is set when the key cannot belong to a live instance.
+	Stale bool `json:"stale"`
+}
+
+// classifyPins matches pinned host keys against the live instances. A pin is
+// stale when no live instance has its address, or when the address now
+// belongs to an instance other than the one the key was pinned for.
+func classifyPins(pinned []sshutil.PinnedHost, instances []api.Instance) []pinView {
+	live := make(map[string]api.Instance)
+	for _, inst := range instances {
+		if inst.IP != "" && inst.Status != "terminated" {
+			live[inst.IP] = inst
+		}
+	}
+	views := make([]pinView, 0, len(pinned))
+	for _, p := range pinned {
+		host, _, _ := strings.Cut(p.Host, ",")
+		view := pinView{PinnedHost: p, Status: "live"}
+		inst, ok := live[host]
+		switch {
+		case !ok:
+			view.Status, view.Stale = "gone", true
+		case p.InstanceID != "" && p.InstanceID != inst.ID:
+			view.Status, view.Stale = fmt.Sprintf("reused by '%s' (ID: %s)", inst.Name, inst.ID), true
+		}
+		views = append(views, view)
+	}
+	return views
+}
+
+// listPins fetches the pinned host keys and the live instances and joins them.
+func listPins(ctx context.Context, cmd *cobra.Command) ([]pinView, error) {
+	pinned, err := sshutil.PinnedHosts()
+	if err != nil {
+		return nil, err
+	}
+	client, err := newAPIClient(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing API client: %w", err)
+	}
+	instances, err := client.ListInstances(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching instances: %w", err)
+	}
+	return classifyPins(pinned, instances), nil
+}
+
+var knownHostsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List pinned host keys and whether their instance still exists",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonOutput, err := jsonOutputFlag(cmd)
+		if err != nil {
+			return err
+		}
+		views, err := listPins(cmd.Context(), cmd)
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput {
+			return printJSON(views)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "HOST\tINSTANCE\tKEY\tFINGERPRINT\tSTATUS")
+		for _, v := range views {
+			instance := v.InstanceName
+			if instance == "" {
+				instance = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", v.Host, instance, v.KeyType, v.Fingerprint, v.Status)
+		}
+		return w.Flush()
+	},
+}
+
+var knownHostsPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove pinned host keys of instances that are gone or whose IP was reused",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonOutput, err := jsonOutputFlag(cmd)
+		if err != nil {
+			return err
+		}
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		views, err := listPins(cmd.Context(), cmd)
+		if err != nil {
+			return err
+		}
+
+		removed := []pinView{}
+		for _, v := range views {
+			if !v.Stale {
+				continue
+			}
+			if !dryRun {
+				host, _, _ := strings.Cut(v.Host, ",")
+				if err := sshutil.UnpinHostKey(host); err != nil {
+					return fmt.Errorf("failed to remove the host key of %s: %w", v.Host, err)
+				}
+			}
+			removed = append(removed, v)
+		}
+
+		if jsonOutput {
+			return printJSON(map[string]any{"removed": removed, "dry_run": dryRun})
+		}
+		verb := "Removed"
+		if dryRun {
+			verb = "Would remove"
+		}
+		for _, v := range removed {
+			instance := v.InstanceName
+			if instance == "" {
+				instance = "unknown instance"
+			}
+			log.Infof("%s host key of %s (%s): %s", verb, v.Host, instance, v.Status)
+		}
+		log.Infof("%s %d of %d pinned host key(s).", verb, len(removed), len(views))
+		return nil
+	},
+}
+
+var knownHostsPathCmd = &cobra.Command{
+	Use:   "path",
+	Short: "Print the path of the lm-managed known_hosts file",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := configutil.KnownHostsPath()
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+		return nil
+	},
+}
+
+// dialInstance opens an SSH connection to inst, checking its host key. When
+// the key changed because Lambda gave the address of an old instance to inst,
+// the error names both instances.
+func dialInstance(ctx context.Context, inst *api.Instance, sshKeyPath, user, sshKeyName string) (*ssh.Client, error) {
+	client, err := sshutil.EstablishSSHConnection(ctx, inst.IP, sshKeyPath, user, sshKeyName)
+	var changed *sshutil.HostKeyChangedError
+	if !errors.As(err, &changed) {
+		return client, err
+	}
+	prev := changed.Previous
+	switch {
+	case prev != nil && prev.InstanceID != "" && prev.InstanceID != inst.ID:
+		log.Errorf("%s was pinned for old instance '%s' (ID: %s) and now belongs to instance '%s' (ID: %s); Lambda reuses IPs.",
+			inst.IP, prev.InstanceName, prev.InstanceID, inst.Name, inst.ID)
+		log.Errorf("Run 'lm known-hosts prune' to drop the old key, then connect once with 'ssh %s@%s' to trust the new one.", user, inst.IP)
+		return nil, fmt.Errorf("host key of %s changed: old instance '%s' (ID: %s), new instance '%s' (ID: %s): %w",
+			inst.IP, prev.InstanceName, prev.InstanceID, inst.Name, inst.ID, changed.Err)
+	case prev != nil && prev.InstanceID == inst.ID:
+		log.Errorf("Instance '%s' (ID: %s) presented a different host key than the one pinned when it was created.", inst.Name, inst.ID)
+		log.Error("Someone may be intercepting the connection. Not connecting.")
+	default:
+		log.Errorf("The host key of %s does not match the one in %s.", inst.IP, changed.File)
+		log.Errorf("If the instance was replaced, remove the old entry with 'ssh-keygen -R %s -f %s'.", inst.IP, changed.File)
+	}
+	return nil, err
+}
+
+func init() {
+	knownHostsPruneCmd.Flags().Bool("dry-run", false, "Only show which host keys would be removed")
+
+	KnownHostsCmd.AddCommand(knownHostsListCmd)
+	KnownHostsCmd.AddCommand(knownHostsPruneCmd)
+	KnownHostsCmd.AddCommand(knownHostsPathCmd)
+}
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/sshutil"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+)
+
+func TestClassifyPins(t *testing.T) {
+	pinned := []sshutil.PinnedHost{
+		{Host: "192.0.2.10", InstanceID: "i-live", InstanceName: "live"},
+		{Host: "192.0.2.11", InstanceID: "i-old", InstanceName: "old"},
+		{Host: "192.0.2.12", InstanceID: "i-gone", InstanceName: "gone"},
+		{Host: "192.0.2.13"},
+		{Host: "192.0.2.14", InstanceID: "i-dead", InstanceName: "dead"},
+	}
+	instances := []api.Instance{
+		{ID: "i-live", Name: "live", IP: "192.0.2.10", Status: "active"},
+		{ID: "i-new", Name: "new", IP: "192.0.2.11", Status: "booting"},
+		{ID: "i-other", Name: "other", IP: "192.0.2.13", Status: "active"},
+		{ID: "i-dead", Name: "dead", IP: "192.0.2.14", Status: "terminated"},
+	}
+	views := classifyPins(pinned, instances)
+	want := []struct {
+		status string
+		stale  bool
+	}{
+		{"live", false},
+		{"reused by 'new' (ID: i-new)", true},
+		{"gone", true},
+		{"live", false},
+		{"gone", true},
+	}
+	for i, w := range want {
+		if views[i].Status != w.status || views[i].Stale != w.stale {
+			t.Errorf("%s: got (%q, %v), want (%q, %v)", views[i].Host, views[i].Status, views[i].Stale, w.status, w.stale)
+		}
+	}
+}
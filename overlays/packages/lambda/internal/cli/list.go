@@ -3,36 +3,67 @@ package cli
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"strings"
 	"text/tabwriter"
+	"time"
 
-	api "github.com/aarnphm/detachtools/overlays/packages/lambda/internal/apiclient"
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/state"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
 	"github.com/spf13/cobra"
 )
 
+// listEntry is an instance in 'lm list -o json', with what the local history
+// knows about it.
+type listEntry struct {
+	api.Instance
+	History       *state.Instance `json:"history,omitempty"`
+	UptimeSeconds int64           `json:"uptime_seconds,omitempty"`
+	// CostUSD is the cost so far at the price recorded at launch.
+	CostUSD float64 `json:"cost_usd,omitempty"`
+	// TTLSeconds is the time left until the instance expires, negative once
+	// it is past its TTL.
+	TTLSeconds *int64 `json:"ttl_seconds,omitempty"`
+}
+
 var ListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List running Lambda Cloud instances",
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		apiKey, _ := cmd.Root().PersistentFlags().GetString("api-key")
-		client, err := api.NewAPIClient(apiKey)
+		ctx := cmd.Context()
+		client, err := newAPIClient(cmd)
 		if err != nil {
 			return fmt.Errorf("error initializing API client: %w", err)
 		}
 
-		var instancesResp api.InstancesResponse
-		err = client.Request("GET", "/instances", nil, &instancesResp)
+		instances, err := client.ListInstances(ctx)
 		if err != nil {
 			return fmt.Errorf("error fetching instances: %w", err)
 		}
 
 		outputFormat, _ := cmd.Root().PersistentFlags().GetString("output")
+		history := loadHistory()
+		now := time.Now()
 
 		switch outputFormat {
 		case "json":
-			jsonData, err := json.MarshalIndent(instancesResp.Data, "", "  ")
+			entries := make([]listEntry, len(instances))
+			for i, inst := range instances {
+				entries[i] = listEntry{Instance: inst}
+				if rec, ok := history[inst.ID]; ok && !rec.LaunchedAt.IsZero() {
+					entries[i].History = rec
+					entries[i].UptimeSeconds = int64(rec.Uptime(now).Seconds())
+					entries[i].CostUSD = math.Round(rec.CostCents(now)) / 100
+				}
+				if rec, ok := history[inst.ID]; ok && !rec.ExpiresAt.IsZero() {
+					left := int64(rec.ExpiresAt.Sub(now).Seconds())
+					entries[i].History = rec
+					entries[i].TTLSeconds = &left
+				}
+			}
+			jsonData, err := json.MarshalIndent(entries, "", "  ")
 			if err != nil {
 				return fmt.Errorf("failed to marshal instances to JSON: %w", err)
 			}
@@ -40,15 +71,15 @@ var ListCmd = &cobra.Command{
 		case "table", "":
 			// Initialize tabwriter for aligned columns
 			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-			fmt.Fprintln(w, "NAME\tID\tIP_ADDRESS\tGPU_TYPE\tREGION\tSTATUS\tPRICE/HR\tUPTIME")
+			fmt.Fprintln(w, "NAME\tID\tIP_ADDRESS\tGPU_TYPE\tREGION\tSTATUS\tPRICE/HR\tUPTIME\tCOST\tTTL")
 
-			if len(instancesResp.Data) == 0 {
-                w.Flush()
+			if len(instances) == 0 {
+				w.Flush()
 				return nil
 			}
 
 			activeCount := 0
-			for _, inst := range instancesResp.Data {
+			for _, inst := range instances {
 				// Skip non-active instances for the default list
 				if inst.Status != "active" {
 					continue
@@ -64,7 +95,15 @@ var ListCmd = &cobra.Command{
 					gpuType = inst.InstanceType.Name
 				}
 
-				uptimeStr := "-" // Launch time not available via API
+				// The API has no launch time; use the one create recorded
+				uptimeStr, costStr, ttlStr := "-", "-", "-"
+				if rec, ok := history[inst.ID]; ok {
+					if !rec.LaunchedAt.IsZero() {
+						uptimeStr = formatUptime(rec.Uptime(now))
+						costStr = fmt.Sprintf("$%.2f", rec.CostCents(now)/100)
+					}
+					ttlStr = formatTTL(rec.ExpiresAt, now)
+				}
 
 				ipAddr := inst.IP
 				if ipAddr == "" || ipAddr == "null" {
@@ -73,7 +112,7 @@ var ListCmd = &cobra.Command{
 
 				regionName := inst.Region.Name
 
-				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t$%.2f\t%s\n",
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t$%.2f\t%s\t%s\t%s\n",
 					inst.Name,
 					inst.ID,
 					ipAddr,
@@ -82,6 +121,8 @@ var ListCmd = &cobra.Command{
 					inst.Status,
 					float64(inst.InstanceType.PriceCentsPerHour)/100,
 					uptimeStr,
+					costStr,
+					ttlStr,
 				)
 			}
 
@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// jsonOutputFlag validates --output and reports whether JSON was requested.
+func jsonOutputFlag(cmd *cobra.Command) (bool, error) {
+	outputFormat, _ := cmd.Root().PersistentFlags().GetString("output")
+	outputFormat = strings.ToLower(outputFormat)
+	if outputFormat != "" && outputFormat != "json" && outputFormat != "table" {
+		return false, fmt.Errorf("invalid output format: %s. Supported formats: 'table', 'json'", outputFormat)
+	}
+	return outputFormat == "json", nil
+}
+
+// printJSON writes v to stdout as indented JSON.
+func printJSON(v any) error {
+	jsonBytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON output: %w", err)
+	}
+	fmt.Println(string(jsonBytes))
+	return nil
+}
@@ -0,0 +1,210 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+	log "github.com/sirupsen/logrus"
+)
+
+var specRe = regexp.MustCompile(`^([1-9][0-9]*)x([a-zA-Z0-9_]+)$`)
+
+// typeCandidate is one entry of the `lm create` spec list, e.g. 8xH100_SXM5.
+type typeCandidate struct {
+	Spec     string
+	NumGPUs  string
+	GPUType  string
+	TypeName string
+}
+
+// parseSpecs parses a comma-separated list of <gpus>x<type> specs, keeping
+// their order. Types are matched case-insensitively, since the API names
+// them in lower case.
+func parseSpecs(specs string) ([]typeCandidate, error) {
+	var candidates []typeCandidate
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		matches := specRe.FindStringSubmatch(spec)
+		if len(matches) != 3 {
+			return nil, fmt.Errorf("invalid instance specification '%s'. Use <number_gpus>x<gpu_type> (e.g., 1xA100, 2xH100_SXM5), comma-separated for fallbacks", spec)
+		}
+		gpuType := strings.ToLower(matches[2])
+		candidates = append(candidates, typeCandidate{
+			Spec:     spec,
+			NumGPUs:  matches[1],
+			GPUType:  gpuType,
+			TypeName: fmt.Sprintf("gpu_%sx_%s", matches[1], gpuType),
+		})
+	}
+	return candidates, nil
+}
+
+// parseRegionPatterns splits a comma-separated list of region names or glob
+// patterns such as us-* and validates the patterns.
+func parseRegionPatterns(list string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid region pattern '%s': %w", p, err)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// placement is the instance type and region chosen for a launch.
+type placement struct {
+	Candidate     typeCandidate `json:"-"`
+	InstanceType  string        `json:"instance_type"`
+	Region        string        `json:"region"`
+	RegionPattern string        `json:"region_pattern"`
+	Reason        string        `json:"reason"`
+}
+
+// matchRegion returns the first region with capacity that matches the
+// patterns, trying patterns in order. Regions matching the same pattern are
+// tried alphabetically.
+func matchRegion(available []api.Region, patterns []string) (region, pattern string) {
+	names := make([]string, 0, len(available))
+	for _, r := range available {
+		names = append(names, r.Name)
+	}
+	sort.Strings(names)
+	for _, p := range patterns {
+		for _, name := range names {
+			if ok, _ := path.Match(p, name); ok {
+				return name, p
+			}
+		}
+	}
+	return "", ""
+}
+
+// resolveRegion returns the region the first of patterns names: a literal
+// entry as is, a glob the first of the known regions it matches
+// alphabetically. It returns "" if no pattern names a region.
+func resolveRegion(known []api.Region, patterns []string) string {
+	for _, p := range patterns {
+		if !strings.ContainsAny(p, `*?[\`) {
+			return p
+		}
+		if region, _ := matchRegion(known, []string{p}); region != "" {
+			return region
+		}
+	}
+	return ""
+}
+
+// choosePlacement returns the first satisfiable (type, region) pair: types
+// in preference order, and for each type the region patterns in order. It
+// returns nil if nothing has capacity. Unknown types are skipped; callers
+// validate them up front.
+func choosePlacement(types map[string]api.InstanceTypeDetails, candidates []typeCandidate, patterns []string) *placement {
+	var skipped []string
+	for _, c := range candidates {
+		details, ok := types[c.TypeName]
+		if !ok {
+			continue
+		}
+		region, pattern := matchRegion(details.RegionsWithCapacity, patterns)
+		if region == "" {
+			skipped = append(skipped, c.TypeName)
+			continue
+		}
+		reason := fmt.Sprintf("%s has capacity in %s (matches '%s')", c.TypeName, region, pattern)
+		if pattern != patterns[0] {
+			reason += fmt.Sprintf(", preferred regions before '%s' had none", pattern)
+		}
+		if len(skipped) > 0 {
+			reason = fmt.Sprintf("%s had no capacity in %s; %s", strings.Join(skipped, ", "), strings.Join(patterns, ","), reason)
+		} else if pattern == patterns[0] {
+			reason = "first choice: " + reason
+		}
+		return &placement{
+			Candidate:     c,
+			InstanceType:  c.TypeName,
+			Region:        region,
+			RegionPattern: pattern,
+			Reason:        reason,
+		}
+	}
+	return nil
+}
+
+// capacitySummary describes where the candidates have capacity, for
+// messages when none of it is in an allowed region.
+func capacitySummary(types map[string]api.InstanceTypeDetails, candidates []typeCandidate) string {
+	var parts []string
+	for _, c := range candidates {
+		var names []string
+		for _, r := range types[c.TypeName].RegionsWithCapacity {
+			names = append(names, r.Name)
+		}
+		if len(names) > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %s", c.TypeName, strings.Join(names, ",")))
+		}
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// waitForPlacement polls /instance-types until choosePlacement succeeds. A
+// zero deadline waits until ctx is cancelled.
+func waitForPlacement(ctx context.Context, client *api.Client, candidates []typeCandidate, patterns []string, poll time.Duration, deadline time.Time) (*placement, error) {
+	typeNames := make([]string, len(candidates))
+	for i, c := range candidates {
+		typeNames[i] = c.TypeName
+	}
+	wanted := strings.Join(typeNames, ",")
+	log.Infof("Waiting for capacity for %s in %s, checking every %s", wanted, strings.Join(patterns, ","), poll)
+
+	status := newStatusLine()
+	defer status.Done()
+	start := time.Now()
+	for checks := 1; ; checks++ {
+		seen := "none"
+		types, err := client.ListInstanceTypes(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return nil, fmt.Errorf("interrupted while waiting for capacity for %s: %w", wanted, ctx.Err())
+		case err != nil:
+			seen = "error: " + err.Error()
+			log.Debugf("Error fetching instance types while waiting for capacity: %v", err)
+		default:
+			if p := choosePlacement(types, candidates, patterns); p != nil {
+				status.Done()
+				log.Infof("Capacity for %s appeared in %s after %s", p.InstanceType, p.Region, time.Since(start).Round(time.Second))
+				p.Reason += fmt.Sprintf(" after waiting %s", time.Since(start).Round(time.Second))
+				return p, nil
+			}
+			seen = capacitySummary(types, candidates)
+		}
+
+		wait := poll
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return nil, fmt.Errorf("no capacity for %s in %s after waiting %s", wanted, strings.Join(patterns, ","), time.Since(start).Round(time.Second))
+			}
+			wait = min(wait, left)
+		}
+		status.Update(fmt.Sprintf("Waiting for %s: %s elapsed, %d checks, capacity: %s", wanted, time.Since(start).Round(time.Second), checks, seen))
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("interrupted while waiting for capacity for %s: %w", wanted, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
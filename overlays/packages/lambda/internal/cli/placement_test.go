@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/fakecloud"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+)
+
+func TestParseSpecs(t *testing.T) {
+	candidates, err := parseSpecs("8xH100_SXM5, 8xA100_80GB_SXM4")
+	if err != nil {
+		t.Fatalf("parseSpecs: %v", err)
+	}
+	if len(candidates) != 2 || candidates[0].TypeName != "gpu_8x_h100_sxm5" || candidates[1].GPUType != "a100_80gb_sxm4" {
+		t.Errorf("unexpected candidates: %+v", candidates)
+	}
+	for _, bad := range []string{"", "h100", "8xH100,", "0xA10"} {
+		if _, err := parseSpecs(bad); err == nil {
+			t.Errorf("parseSpecs(%q): expected error", bad)
+		}
+	}
+	if _, err := parseRegionPatterns("us-[east"); err == nil {
+		t.Error("expected error for malformed glob")
+	}
+}
+
+func TestMatchRegion(t *testing.T) {
+	available := []api.Region{{Name: "europe-central-1"}, {Name: "us-west-3"}, {Name: "us-east-1"}}
+	tests := []struct {
+		name        string
+		patterns    []string
+		wantRegion  string
+		wantPattern string
+	}{
+		{"exact", []string{"us-west-3"}, "us-west-3", "us-west-3"},
+		{"unavailable", []string{"us-south-1"}, "", ""},
+		{"preference order", []string{"us-south-1", "us-east-1", "us-west-3"}, "us-east-1", "us-east-1"},
+		{"glob picks alphabetically", []string{"us-south-1", "us-*"}, "us-east-1", "us-*"},
+		{"non-US glob", []string{"europe-*"}, "europe-central-1", "europe-*"},
+	}
+	for _, tc := range tests {
+		region, pattern := matchRegion(available, tc.patterns)
+		if region != tc.wantRegion || pattern != tc.wantPattern {
+			t.Errorf("%s: got (%q, %q), want (%q, %q)", tc.name, region, pattern, tc.wantRegion, tc.wantPattern)
+		}
+	}
+}
+
+func TestResolveRegion(t *testing.T) {
+	known := []api.Region{{Name: "us-west-3"}, {Name: "us-east-1"}}
+	for _, tc := range []struct {
+		patterns []string
+		want     string
+	}{
+		{[]string{"us-*", "europe-central-1"}, "us-east-1"},
+		{[]string{"asia-*", "europe-central-1", "us-*"}, "europe-central-1"},
+		{[]string{"us-south-1"}, "us-south-1"},
+		{[]string{"asia-*"}, ""},
+	} {
+		if got := resolveRegion(known, tc.patterns); got != tc.want {
+			t.Errorf("resolveRegion(%v) = %q, want %q", tc.patterns, got, tc.want)
+		}
+	}
+}
+
+func TestChoosePlacement(t *testing.T) {
+	types := map[string]api.InstanceTypeDetails{
+		"gpu_8x_h100_sxm5":      {RegionsWithCapacity: []api.Region{{Name: "europe-central-1"}}},
+		"gpu_8x_a100_80gb_sxm4": {RegionsWithCapacity: []api.Region{{Name: "us-west-1"}, {Name: "us-east-1"}}},
+	}
+	candidates, _ := parseSpecs("8xh100_sxm5,8xa100_80gb_sxm4")
+
+	p := choosePlacement(types, candidates, []string{"us-east-1", "us-*"})
+	if p == nil || p.InstanceType != "gpu_8x_a100_80gb_sxm4" || p.Region != "us-east-1" {
+		t.Fatalf("unexpected placement: %+v", p)
+	}
+	if !strings.Contains(p.Reason, "gpu_8x_h100_sxm5 had no capacity") {
+		t.Errorf("reason does not mention the skipped type: %q", p.Reason)
+	}
+
+	p = choosePlacement(types, candidates, []string{"europe-*", "us-*"})
+	if p == nil || p.InstanceType != "gpu_8x_h100_sxm5" || !strings.HasPrefix(p.Reason, "first choice") {
+		t.Errorf("unexpected placement: %+v", p)
+	}
+
+	if p := choosePlacement(types, candidates, []string{"asia-*"}); p != nil {
+		t.Errorf("expected no placement, got %+v", p)
+	}
+}
+
+func TestWaitForPlacement(t *testing.T) {
+	srv := fakecloud.New(fakecloud.Options{})
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+	client, err := api.NewClient("test-key", api.WithBaseURL(httpSrv.URL+fakecloud.BasePath))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	const typeName = "gpu_8x_h100_sxm5"
+	if err := srv.SetCapacity(typeName, "us-west-3", 0); err != nil {
+		t.Fatalf("SetCapacity: %v", err)
+	}
+	candidates, _ := parseSpecs("8xh100_sxm5")
+	patterns := []string{"us-*"}
+
+	ctx := context.Background()
+	_, err = waitForPlacement(ctx, client, candidates, patterns, 5*time.Millisecond, time.Now().Add(30*time.Millisecond))
+	if err == nil {
+		t.Fatal("expected timeout error while there is no capacity")
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		srv.SetCapacity(typeName, "us-west-3", 1)
+	}()
+	p, err := waitForPlacement(ctx, client, candidates, patterns, 5*time.Millisecond, time.Now().Add(5*time.Second))
+	if err != nil {
+		t.Fatalf("waitForPlacement: %v", err)
+	}
+	if p.Region != "us-west-3" {
+		t.Errorf("region = %q, want us-west-3", p.Region)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	gh200, _ := parseSpecs("1xgh200")
+	if _, err := waitForPlacement(cancelled, client, gh200, patterns, time.Second, time.Time{}); err == nil {
+		t.Error("expected error for cancelled context")
+	}
+}
@@ -0,0 +1,238 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/state"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var ReapCmd = &cobra.Command{
+	Use:   "reap",
+	Short: "Terminate instances that are past their TTL or idle",
+	Long: `Terminate instances that are past their TTL or, with --idle, idle.
+
+The TTLs come from the local history (see 'lm ttl'), so reap also catches
+instances whose own timer never ran, e.g. because no ttl_api_key_command was
+set or the instance was unreachable.
+
+With --idle, reap also logs in to every active instance and samples its GPU
+utilization and memory, login sessions and load average. An instance whose
+samples have all been idle for the --idle duration is terminated. Samples are
+kept in the local history, so successive runs build on each other; run reap
+more often than --idle, or let --watch do so. Instances marked with
+'lm protect' or matching --exempt are never sampled.
+
+Run it from cron or a systemd timer on a machine that stays up, or keep it
+running with --watch.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonOutput, err := jsonOutputFlag(cmd)
+		if err != nil {
+			return err
+		}
+		opts := reapOptions{JSON: jsonOutput}
+		opts.DryRun, _ = cmd.Flags().GetBool("dry-run")
+		opts.Idle, _ = cmd.Flags().GetDuration("idle")
+		watch, _ := cmd.Flags().GetDuration("watch")
+		exempt, _ := cmd.Flags().GetString("exempt")
+		if opts.Exempt, err = parseNamePatterns(exempt); err != nil {
+			return err
+		}
+		switch {
+		case opts.Idle < 0 || watch < 0:
+			return fmt.Errorf("--idle and --watch must be positive")
+		case len(opts.Exempt) > 0 && opts.Idle == 0:
+			return fmt.Errorf("--exempt only applies with --idle")
+		case opts.Idle > 0 && watch >= opts.Idle:
+			return fmt.Errorf("--watch (%s) must be shorter than --idle (%s), or idle instances are never seen idle for long enough", watch, opts.Idle)
+		}
+		if opts.Idle > 0 {
+			_, profile, err := ActiveProfile(cmd)
+			if err != nil {
+				return err
+			}
+			flags := cmd.Root().PersistentFlags()
+			opts.SSH.User = profile.RemoteUser
+			opts.SSH.KeyPath, _ = flags.GetString("ssh-key-path")
+			opts.SSH.KeyName, _ = flags.GetString("ssh-key-name")
+		}
+		ctx := cmd.Context()
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error initializing API client: %w", err)
+		}
+		if watch == 0 {
+			return reapOnce(ctx, client, opts)
+		}
+
+		log.Infof("Reaping every %s; stop with Ctrl-C.", watch)
+		ticker := time.NewTicker(watch)
+		defer ticker.Stop()
+		for {
+			// A failed pass, e.g. because the API was unreachable, is retried
+			// on the next tick.
+			if err := reapOnce(ctx, client, opts); err != nil {
+				log.Errorf("%v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+// reapOptions are the flags of 'lm reap'.
+type reapOptions struct {
+	JSON   bool
+	DryRun bool
+	// Idle is how long an instance must stay idle to be reaped, 0 to leave
+	// idle instances alone.
+	Idle   time.Duration
+	Exempt []string
+	SSH    clusterSSH
+}
+
+// reapOnce finds the instances to reap, terminates them unless this is a dry
+// run and prints them.
+func reapOnce(ctx context.Context, client *api.Client, opts reapOptions) error {
+	instances, err := client.ListInstances(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching instances: %w", err)
+	}
+
+	history := loadHistory()
+	targets := expiredInstances(instances, history, time.Now())
+	if opts.Idle > 0 {
+		expired := make(map[string]bool, len(targets))
+		for _, t := range targets {
+			expired[t.ID] = true
+		}
+		for _, t := range sampleIdle(ctx, opts.SSH, instances, history, opts.Exempt, opts.Idle) {
+			if !expired[t.ID] {
+				targets = append(targets, t)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		if opts.JSON {
+			return printJSON([]reapTarget{})
+		}
+		log.Info("Nothing to reap.")
+		return nil
+	}
+	for _, t := range targets {
+		log.Infof("Instance '%s' (ID: %s): %s", t.Instance.Name, t.Instance.ID, t.Reason)
+	}
+	if !opts.DryRun {
+		err = reapInstances(ctx, client, targets)
+	}
+
+	if opts.JSON {
+		if jsonErr := printJSON(targets); jsonErr != nil {
+			return jsonErr
+		}
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tID\tREASON\tACTION")
+	for _, t := range targets {
+		action := t.Action
+		if opts.DryRun {
+			action = "would terminate"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.Instance.Name, t.Instance.ID, t.Reason, action)
+	}
+	if flushErr := w.Flush(); flushErr != nil {
+		return flushErr
+	}
+	return err
+}
+
+// reapTarget is an instance reap terminates, and why.
+type reapTarget struct {
+	Instance api.Instance `json:"-"`
+	ID       string       `json:"instance_id"`
+	Name     string       `json:"instance_name"`
+	Reason   string       `json:"reason"`
+	// Action is "terminated" or "failed" once reap has acted, empty for a
+	// dry run.
+	Action string `json:"action,omitempty"`
+	// since is when the reason started to hold.
+	since time.Time
+}
+
+func newReapTarget(inst api.Instance, reason string, since time.Time) reapTarget {
+	return reapTarget{Instance: inst, ID: inst.ID, Name: inst.Name, Reason: reason, since: since}
+}
+
+// expiredInstances returns the live instances that are past the TTL
+// recorded for them at now, longest expired first.
+func expiredInstances(instances []api.Instance, history map[string]*state.Instance, now time.Time) []reapTarget {
+	var targets []reapTarget
+	for _, inst := range instances {
+		if inst.Status == "terminated" || inst.Status == "terminating" {
+			continue
+		}
+		rec, ok := history[inst.ID]
+		if !ok || !rec.Expired(now) {
+			continue
+		}
+		reason := fmt.Sprintf("TTL expired %s ago", formatUptime(now.Sub(rec.ExpiresAt)))
+		targets = append(targets, newReapTarget(inst, reason, rec.ExpiresAt))
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].since.Before(targets[j].since) })
+	return targets
+}
+
+// reapInstances terminates targets in one request and records the
+// outcome of each in its Action.
+func reapInstances(ctx context.Context, client *api.Client, targets []reapTarget) error {
+	ids := make([]string, len(targets))
+	for i, t := range targets {
+		ids[i] = t.Instance.ID
+	}
+	terminated, err := client.Terminate(ctx, ids...)
+	if err != nil {
+		for i := range targets {
+			targets[i].Action = "failed"
+		}
+		return fmt.Errorf("error terminating %d instance(s): %w", len(ids), err)
+	}
+	done := make(map[string]bool, len(terminated))
+	for _, inst := range terminated {
+		done[inst.ID] = true
+	}
+	var failed int
+	for i, t := range targets {
+		if !done[t.Instance.ID] {
+			targets[i].Action = "failed"
+			failed++
+			continue
+		}
+		targets[i].Action = "terminated"
+		forgetHostKey(t.Instance)
+		recordTerminated(t.Instance)
+		log.Infof("Terminated '%s' (ID: %s).", t.Instance.Name, t.Instance.ID)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to confirm termination of %d of %d instance(s)", failed, len(targets))
+	}
+	return nil
+}
+
+func init() {
+	ReapCmd.Flags().Bool("dry-run", false, "Show what would be terminated without terminating anything")
+	ReapCmd.Flags().Duration("idle", 0, "Also terminate instances idle for this long, e.g. 45m (samples them over SSH)")
+	ReapCmd.Flags().String("exempt", "", "Comma-separated instance names or globs that --idle never terminates, e.g. dev-*")
+	ReapCmd.Flags().Duration("watch", 0, "Keep running and reap at this interval instead of once, e.g. 5m")
+}
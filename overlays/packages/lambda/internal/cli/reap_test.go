@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/state"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+)
+
+func TestExpiredInstances(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	launch := now.Add(-10 * time.Hour)
+	history := state.Fold([]state.Event{
+		{Time: launch, Kind: state.Launched, InstanceID: "i-recent"},
+		{Time: launch, Kind: state.TTL, InstanceID: "i-recent", ExpiresAt: now.Add(-time.Minute)},
+		{Time: launch, Kind: state.Launched, InstanceID: "i-old"},
+		{Time: launch, Kind: state.TTL, InstanceID: "i-old", ExpiresAt: now.Add(-2 * time.Hour)},
+		{Time: launch, Kind: state.Launched, InstanceID: "i-extended"},
+		{Time: launch, Kind: state.TTL, InstanceID: "i-extended", ExpiresAt: now.Add(-time.Hour)},
+		{Time: now.Add(-2 * time.Hour), Kind: state.TTL, InstanceID: "i-extended", ExpiresAt: now.Add(time.Hour)},
+		{Time: launch, Kind: state.Launched, InstanceID: "i-cleared"},
+		{Time: launch, Kind: state.TTL, InstanceID: "i-cleared", ExpiresAt: now.Add(-time.Hour)},
+		{Time: launch.Add(time.Minute), Kind: state.TTL, InstanceID: "i-cleared"},
+		{Time: launch, Kind: state.Launched, InstanceID: "i-going"},
+		{Time: launch, Kind: state.TTL, InstanceID: "i-going", ExpiresAt: now.Add(-time.Hour)},
+	})
+	instances := []api.Instance{
+		{ID: "i-recent", Name: "recent", Status: "active"},
+		{ID: "i-old", Name: "old", Status: "booting"},
+		{ID: "i-extended", Status: "active"},
+		{ID: "i-cleared", Status: "active"},
+		{ID: "i-going", Status: "terminating"},
+		{ID: "i-unknown", Status: "active"},
+	}
+
+	targets := expiredInstances(instances, history, now)
+	if len(targets) != 2 || targets[0].ID != "i-old" || targets[1].ID != "i-recent" {
+		t.Fatalf("targets = %+v", targets)
+	}
+	if targets[0].Reason != "TTL expired 2h00m ago" || targets[1].Name != "recent" {
+		t.Errorf("targets = %+v", targets)
+	}
+}
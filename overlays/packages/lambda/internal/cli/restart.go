@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"strings"
 
-	api "github.com/aarnphm/detachtools/overlays/packages/lambda/internal/apiclient"
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/state"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -26,45 +26,15 @@ var RestartCmd = &cobra.Command{
 		}
 
 		// 1. Find Instance by ID or Name
-		apiKey, _ := cmd.Root().PersistentFlags().GetString("api-key")
-		client, err := api.NewAPIClient(apiKey)
+		ctx := cmd.Context()
+		client, err := newAPIClient(cmd)
 		if err != nil {
 			return fmt.Errorf("failed to create API client: %w", err)
 		}
 		log.Debugf("Looking for instance '%s' to restart", instanceIdentifier)
-		var instancesResp api.InstancesResponse
-		err = client.Request("GET", "/instances", nil, &instancesResp)
+		targetInstance, err := client.ResolveInstance(ctx, instanceIdentifier)
 		if err != nil {
-			return fmt.Errorf("error fetching instances: %w", err)
-		}
-
-		var targetInstance *api.Instance
-		// First, try matching by ID
-		for i := range instancesResp.Data {
-			if instancesResp.Data[i].ID == instanceIdentifier {
-				targetInstance = &instancesResp.Data[i]
-				log.Debugf("Found instance by ID: %s", instanceIdentifier)
-				break
-			}
-		}
-		// If not found by ID, try matching by Name
-		if targetInstance == nil {
-			log.Debugf("Instance not found by ID '%s', trying by name", instanceIdentifier)
-			for i := range instancesResp.Data {
-				if instancesResp.Data[i].Name == instanceIdentifier {
-					// Check for ambiguity (multiple instances with the same name)
-					if targetInstance != nil {
-						return fmt.Errorf("multiple instances found with the name '%s'. Please use the unique instance ID instead", instanceIdentifier)
-					}
-					targetInstance = &instancesResp.Data[i]
-					log.Debugf("Found instance by name: %s", instanceIdentifier)
-					// Don't break here, continue to check for duplicates
-				}
-			}
-		}
-
-		if targetInstance == nil {
-			return fmt.Errorf("no instance found with name or ID '%s'. Cannot restart", instanceIdentifier)
+			return err
 		}
 
 		instanceID := targetInstance.ID
@@ -73,18 +43,14 @@ var RestartCmd = &cobra.Command{
 			instanceName, instanceID, targetInstance.Status)
 
 		// 2. Send Restart Request
-		restartReq := api.RestartRequest{
-			InstanceIDs: []string{instanceID},
-		}
-		var restartResp api.RestartResponse
-		err = client.Request("POST", "/instance-operations/restart", restartReq, &restartResp)
+		restartedInstances, err := client.Restart(ctx, instanceID)
 		if err != nil {
 			return fmt.Errorf("error sending restart request for instance '%s' (ID: %s): %w", instanceName, instanceID, err)
 		}
 
 		// 3. Verify Response
 		restarted := false
-		for _, restartedInstance := range restartResp.Data.RestartedInstances {
+		for _, restartedInstance := range restartedInstances {
 			if restartedInstance.ID == instanceID {
 				restarted = true
 				break
@@ -92,6 +58,7 @@ var RestartCmd = &cobra.Command{
 		}
 
 		if restarted {
+			recordEvents(state.Event{Kind: state.Restarted, InstanceID: instanceID, InstanceName: instanceName})
 			if jsonOutput {
 				resp := map[string]interface{}{
 					"instance_id":   instanceID,
@@ -106,7 +73,7 @@ var RestartCmd = &cobra.Command{
 			}
 		} else {
 			log.Errorf("Failed to confirm restart for instance '%s' (ID: %s)", instanceName, instanceID)
-			log.Debugf("API Response Data: %+v", restartResp.Data)
+			log.Debugf("API Response Data: %+v", restartedInstances)
 			return fmt.Errorf("failed to confirm restart for instance '%s' (ID: %s)", instanceName, instanceID)
 		}
 		return nil
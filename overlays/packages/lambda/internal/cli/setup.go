@@ -3,6 +3,7 @@ package cli
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"fmt"
 	"os"
@@ -10,11 +11,13 @@ import (
 	"strings"
 	"text/template"
 
-	api "github.com/aarnphm/detachtools/overlays/packages/lambda/internal/apiclient"
 	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/configutil"
 	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/sshutil"
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/state"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
 )
 
 //go:embed setup_remote.sh.in
@@ -31,6 +34,9 @@ type remoteSetupParams struct {
 	NixUser             string
 }
 
+// defaultNixUser is the Nix user setup bootstraps unless --user is given.
+const defaultNixUser = "aarnphm"
+
 var (
 	detachFlag  bool
 	forceFlag   bool
@@ -44,37 +50,25 @@ var SetupCmd = &cobra.Command{
 	Args:              cobra.ExactArgs(1),
 	ValidArgsFunction: completeInstanceNames,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Check if Bitwarden session exists
-		if os.Getenv("BW_SESSION") == "" {
-			return fmt.Errorf("bitwarden vault is locked. Please unlock it first (e.g., run 'bw unlock')")
-		}
-
 		instanceNameOrID := args[0]
 
 		// 1. Find Instance
-		apiKey, _ := cmd.Root().PersistentFlags().GetString("api-key")
 		sshKeyPath, _ := cmd.Root().PersistentFlags().GetString("ssh-key-path")
 		sshKeyName, _ := cmd.Root().PersistentFlags().GetString("ssh-key-name")
-		client, err := api.NewAPIClient(apiKey)
+		_, profile, err := ActiveProfile(cmd)
+		if err != nil {
+			return err
+		}
+		ctx := cmd.Context()
+		client, err := newAPIClient(cmd)
 		if err != nil {
 			return fmt.Errorf("error initializing API client: %w", err)
 		}
 
 		log.Infof("Looking for instance '%s'", instanceNameOrID)
-		var instancesResp api.InstancesResponse
-		err = client.Request("GET", "/instances", nil, &instancesResp)
+		targetInstance, err := client.ResolveInstance(ctx, instanceNameOrID)
 		if err != nil {
-			return fmt.Errorf("error fetching instances: %w", err)
-		}
-		var targetInstance *api.Instance
-		for i := range instancesResp.Data {
-			if instancesResp.Data[i].Name == instanceNameOrID || instancesResp.Data[i].ID == instanceNameOrID {
-				targetInstance = &instancesResp.Data[i]
-				break
-			}
-		}
-		if targetInstance == nil {
-			return fmt.Errorf("no instance found with name or ID '%s'", instanceNameOrID)
+			return err
 		}
 		if targetInstance.Status != "active" {
 			return fmt.Errorf("instance '%s' found, but it is not active (status: '%s'). Cannot setup", targetInstance.Name, targetInstance.Status)
@@ -85,146 +79,210 @@ var SetupCmd = &cobra.Command{
 		ipAddress := targetInstance.IP
 		log.Infof("Found instance: %s (%s), IP: %s, Status: %s", targetInstance.Name, targetInstance.ID, ipAddress, targetInstance.Status)
 
-		// Determine effective `detach` setup setting
-		effectiveDetachSetup := detachFlag
-
-		log.Info("Retrieving GitHub token from Bitwarden")
-		bwCmd := exec.Command("bw", "get", "notes", configutil.BitwardenNoteName)
-		ghTokenBytes, err := bwCmd.Output()
-		if err != nil {
-			log.Error("Failed to execute 'bw get notes'. Is Bitwarden CLI installed, logged in, and unlocked?")
-			stderr := ""
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				stderr = string(exitErr.Stderr)
-				log.Errorf("Bitwarden CLI stderr: %s", stderr)
-			}
-			return fmt.Errorf("error running bitwarden command: %w. Stderr: %s", err, stderr)
-		}
-		ghToken := strings.TrimSpace(string(ghTokenBytes))
-		if ghToken == "" {
-			return fmt.Errorf("failed to retrieve GitHub token (item note '%s') from Bitwarden. Is the note populated?", configutil.BitwardenNoteName)
-		}
-		log.Info("GitHub token retrieved successfully.")
-		log.Info("Retrieving GPG passphrase from Bitwarden")
-		gpgCmd := exec.Command("bw", "get", "notes", "gpg-github-paperspace-a4000-keys")
-		gpgPassphraseBytes, err := gpgCmd.Output()
+		// 2. Retrieve secrets
+		secrets, err := fetchSetupSecrets(ctx, profile)
 		if err != nil {
-			log.Error("Failed to execute 'bw get notes' for GPG passphrase. Is Bitwarden CLI installed, logged in, and unlocked?")
-			stderr := ""
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				stderr = string(exitErr.Stderr)
-				log.Errorf("Bitwarden CLI stderr: %s", stderr)
-			}
-			return fmt.Errorf("error running bitwarden command for GPG passphrase: %w. Stderr: %s", err, stderr)
+			return err
 		}
-		remoteGpgPassphrase := strings.TrimSpace(string(gpgPassphraseBytes))
-		if remoteGpgPassphrase == "" {
-			return fmt.Errorf("failed to retrieve GPG passphrase (item note 'gpg-github-paperspace-a4000-keys') from Bitwarden. Is the note populated?")
-		}
-		log.Info("GPG passphrase retrieved successfully.")
 
-		// 3. Establish SSH Connection (without strict known_hosts check for setup)
+		// 3. Establish SSH Connection, checking the host key pinned by create
 		log.Debugf("Attempting to establish SSH connection to %s using key %s", ipAddress, sshKeyPath)
-		sshClient, err := sshutil.EstablishSSHConnection(ipAddress, sshKeyPath, configutil.RemoteUser, sshKeyName, true)
+		sshClient, err := dialInstance(ctx, targetInstance, sshKeyPath, profile.RemoteUser, sshKeyName)
 		if err != nil {
 			return fmt.Errorf("failed to establish SSH connection to %s: %w", ipAddress, err)
 		}
 		defer sshClient.Close()
 
-		// 4. Copy necessary files, only for detach setup.
-		if effectiveDetachSetup {
-			filesToCopy := map[string]string{
-				configutil.GetEnvWithDefault("SSH_KNOWN_HOSTS_FILE", "~/.ssh/known_hosts"):              "~/.ssh/known_hosts",
-				configutil.GetEnvWithDefault("SSH_ID_FILE", "~/.ssh/id_ed25519-github"):                 "~/.ssh/id_ed25519-github",
-				configutil.GetEnvWithDefault("BW_PASS_FILE", "~/bw.pass"):                               "~/bw.pass",
-				configutil.GetEnvWithDefault("IPYTHON_DIRECTORY", "~/.ipython"):                         "~/.ipython",
-				configutil.GetEnvWithDefault("ATUIN_PASS_FILE", "~/atuin.key"):                          "~/atuin.key",
-				configutil.GetEnvWithDefault("YATAI_CONFIG_FILE", "~/.local/share/bentoml/.yatai.yaml"): "~/.yatai.yaml",
-				configutil.GetEnvWithDefault("GPG_PRIVATE_KEY_FILE", "~/gpg-private-lambdalabs.key"):    "~/gpg-private-lambdalabs.key",
-			}
-			for local, remote := range filesToCopy {
-				expandedLocal, err := configutil.ExpandPath(local)
-				if err != nil {
-					log.Warnf("Could not expand local path '%s', skipping copy: %v", local, err)
-					continue
-				}
-				if _, err := os.Stat(expandedLocal); os.IsNotExist(err) {
-					log.Warnf("Local file '%s' (expanded: '%s') does not exist, skipping copy.", local, expandedLocal)
-					continue
-				}
+		opts := setupOptions{Detach: detachFlag, Engine: engineFlag, Force: forceFlag, NixUser: nixUserFlag}
+		if err := runSetup(ctx, sshClient, targetInstance, profile.RemoteUser, secrets, opts); err != nil {
+			return err
+		}
 
-				// Determine if it's a file or directory
-				fileInfo, err := os.Stat(expandedLocal)
-				if err != nil {
-					log.Warnf("Could not stat local path '%s', skipping copy: %v", expandedLocal, err)
-					continue
-				}
+		log.Info("--------------------------------------------------")
+		log.Info("Remote setup script execution finished successfully.")
+		log.Infof("Next step: 'lm connect %s'", targetInstance.Name)
+		log.Info("--------------------------------------------------")
+		return nil
+	},
+}
 
-				if fileInfo.IsDir() {
-					err = sshutil.CopyDirToRemote(sshClient, expandedLocal, remote)
-					if err != nil {
-						return fmt.Errorf("failed to copy directory '%s' to '%s': %w", expandedLocal, remote, err)
-					}
-				} else {
-					err = sshutil.CopyFileToRemote(sshClient, expandedLocal, remote)
-					if err != nil {
-						return fmt.Errorf("failed to copy file '%s' to '%s': %w", expandedLocal, remote, err)
-					}
-				}
-			}
-		}
+// setupOptions are the knobs of the remote setup script.
+type setupOptions struct {
+	// Detach also copies the detachtools credentials and dotfiles.
+	Detach  bool
+	Engine  bool
+	Force   bool
+	NixUser string
+}
 
-		// 5. Render and copy setup script
-		log.Info("Rendering remote setup script")
-		params := remoteSetupParams{
-			RemoteUser:          configutil.RemoteUser,
-			RemotePassword:      configutil.RemotePassword,
-			RemoteGpgPassphrase: remoteGpgPassphrase,
-			GhToken:             ghToken,
-			DetachSetup:         effectiveDetachSetup,
-			EngineSetup:         engineFlag,
-			ForceSetup:          forceFlag,
-			NixUser:             nixUserFlag,
-		}
-		tmpl, err := template.New("remoteScript").Parse(remoteSetupScriptTemplate)
-		if err != nil {
-			return fmt.Errorf("failed to parse remote script template: %w", err)
+// args returns the 'lm setup' flags that select opts.
+func (o setupOptions) args() string {
+	var args []string
+	if o.Detach {
+		args = append(args, "--detach")
+	}
+	if o.Engine {
+		args = append(args, "--engine")
+	}
+	if o.Force {
+		args = append(args, "--force")
+	}
+	if o.NixUser != defaultNixUser {
+		args = append(args, "--user "+o.NixUser)
+	}
+	return strings.Join(args, " ")
+}
+
+// setupSecrets are the credentials setup pulls from Bitwarden.
+type setupSecrets struct {
+	GhToken       string
+	GpgPassphrase string
+}
+
+// fetchSetupSecrets retrieves the GitHub token and GPG passphrase from an
+// unlocked Bitwarden vault.
+func fetchSetupSecrets(ctx context.Context, profile configutil.Profile) (setupSecrets, error) {
+	// Check if Bitwarden session exists
+	if os.Getenv("BW_SESSION") == "" {
+		return setupSecrets{}, fmt.Errorf("bitwarden vault is locked. Please unlock it first (e.g., run 'bw unlock')")
+	}
+
+	log.Info("Retrieving GitHub token from Bitwarden")
+	bwCmd := exec.CommandContext(ctx, "bw", "get", "notes", profile.BitwardenNote)
+	ghTokenBytes, err := bwCmd.Output()
+	if err != nil {
+		log.Error("Failed to execute 'bw get notes'. Is Bitwarden CLI installed, logged in, and unlocked?")
+		stderr := ""
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			stderr = string(exitErr.Stderr)
+			log.Errorf("Bitwarden CLI stderr: %s", stderr)
 		}
-		var scriptBuf bytes.Buffer
-		if err := tmpl.Execute(&scriptBuf, params); err != nil {
-			return fmt.Errorf("failed to execute remote script template: %w", err)
+		return setupSecrets{}, fmt.Errorf("error running bitwarden command: %w. Stderr: %s", err, stderr)
+	}
+	ghToken := strings.TrimSpace(string(ghTokenBytes))
+	if ghToken == "" {
+		return setupSecrets{}, fmt.Errorf("failed to retrieve GitHub token (item note '%s') from Bitwarden. Is the note populated?", profile.BitwardenNote)
+	}
+	log.Info("GitHub token retrieved successfully.")
+	log.Info("Retrieving GPG passphrase from Bitwarden")
+	gpgCmd := exec.CommandContext(ctx, "bw", "get", "notes", profile.GPGBitwardenNote)
+	gpgPassphraseBytes, err := gpgCmd.Output()
+	if err != nil {
+		log.Error("Failed to execute 'bw get notes' for GPG passphrase. Is Bitwarden CLI installed, logged in, and unlocked?")
+		stderr := ""
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			stderr = string(exitErr.Stderr)
+			log.Errorf("Bitwarden CLI stderr: %s", stderr)
 		}
+		return setupSecrets{}, fmt.Errorf("error running bitwarden command for GPG passphrase: %w. Stderr: %s", err, stderr)
+	}
+	remoteGpgPassphrase := strings.TrimSpace(string(gpgPassphraseBytes))
+	if remoteGpgPassphrase == "" {
+		return setupSecrets{}, fmt.Errorf("failed to retrieve GPG passphrase (item note '%s') from Bitwarden. Is the note populated?", profile.GPGBitwardenNote)
+	}
+	log.Info("GPG passphrase retrieved successfully.")
+	return setupSecrets{GhToken: ghToken, GpgPassphrase: remoteGpgPassphrase}, nil
+}
 
-		remoteScriptPath := fmt.Sprintf("/tmp/setup_remote_%s.sh", targetInstance.Name)
-		err = sshutil.CopyContentToRemote(sshClient, scriptBuf.Bytes(), remoteScriptPath, "0755")
+// runSetup copies the setup files to inst over sshClient and runs the
+// remote setup script there. The run and its outcome are recorded in the
+// local instance history.
+func runSetup(ctx context.Context, sshClient *ssh.Client, inst *api.Instance, remoteUser string, secrets setupSecrets, opts setupOptions) (err error) {
+	defer func() {
+		event := state.Event{Kind: state.Setup, InstanceID: inst.ID, InstanceName: inst.Name}
 		if err != nil {
-			return fmt.Errorf("failed to copy rendered script to '%s': %w", remoteScriptPath, err)
+			event.Error = err.Error()
 		}
-		log.Debug("Remote setup script copied.")
-
-		// 6. Execute remote script
-		log.Info("Executing remote setup script This may take a while.")
-		remoteCommand := fmt.Sprintf("INSTANCE_ID=%s bash %s", targetInstance.Name, remoteScriptPath)
-		err = sshutil.RunRemoteCommand(sshClient, remoteCommand)
-		if err != nil {
-			return fmt.Errorf("remote script execution failed: %w", err)
+		recordEvents(event)
+	}()
+	// Copy necessary files, only for detach setup.
+	if opts.Detach {
+		filesToCopy := map[string]string{
+			configutil.GetEnvWithDefault("SSH_KNOWN_HOSTS_FILE", "~/.ssh/known_hosts"):              "~/.ssh/known_hosts",
+			configutil.GetEnvWithDefault("SSH_ID_FILE", "~/.ssh/id_ed25519-github"):                 "~/.ssh/id_ed25519-github",
+			configutil.GetEnvWithDefault("BW_PASS_FILE", "~/bw.pass"):                               "~/bw.pass",
+			configutil.GetEnvWithDefault("IPYTHON_DIRECTORY", "~/.ipython"):                         "~/.ipython",
+			configutil.GetEnvWithDefault("ATUIN_PASS_FILE", "~/atuin.key"):                          "~/atuin.key",
+			configutil.GetEnvWithDefault("YATAI_CONFIG_FILE", "~/.local/share/bentoml/.yatai.yaml"): "~/.yatai.yaml",
+			configutil.GetEnvWithDefault("GPG_PRIVATE_KEY_FILE", "~/gpg-private-lambdalabs.key"):    "~/gpg-private-lambdalabs.key",
 		}
-		err = sshutil.RunRemoteCommand(sshClient, "rm "+remoteScriptPath)
-		if err != nil {
-			return fmt.Errorf("failed to remove remote script: %w", err)
+		for local, remote := range filesToCopy {
+			expandedLocal, err := configutil.ExpandPath(local)
+			if err != nil {
+				log.Warnf("Could not expand local path '%s', skipping copy: %v", local, err)
+				continue
+			}
+			if _, err := os.Stat(expandedLocal); os.IsNotExist(err) {
+				log.Warnf("Local file '%s' (expanded: '%s') does not exist, skipping copy.", local, expandedLocal)
+				continue
+			}
+
+			// Determine if it's a file or directory
+			fileInfo, err := os.Stat(expandedLocal)
+			if err != nil {
+				log.Warnf("Could not stat local path '%s', skipping copy: %v", expandedLocal, err)
+				continue
+			}
+
+			if fileInfo.IsDir() {
+				err = sshutil.CopyDirToRemote(ctx, sshClient, expandedLocal, remote)
+				if err != nil {
+					return fmt.Errorf("failed to copy directory '%s' to '%s': %w", expandedLocal, remote, err)
+				}
+			} else {
+				err = sshutil.CopyFileToRemote(ctx, sshClient, expandedLocal, remote)
+				if err != nil {
+					return fmt.Errorf("failed to copy file '%s' to '%s': %w", expandedLocal, remote, err)
+				}
+			}
 		}
+	}
 
-		log.Info("--------------------------------------------------")
-		log.Info("Remote setup script execution finished successfully.")
-		log.Infof("Next step: 'lm connect %s'", targetInstance.Name)
-		log.Info("--------------------------------------------------")
-		return nil
-	},
+	// Render and copy setup script
+	log.Info("Rendering remote setup script")
+	params := remoteSetupParams{
+		RemoteUser:          remoteUser,
+		RemotePassword:      configutil.RemotePassword,
+		RemoteGpgPassphrase: secrets.GpgPassphrase,
+		GhToken:             secrets.GhToken,
+		DetachSetup:         opts.Detach,
+		EngineSetup:         opts.Engine,
+		ForceSetup:          opts.Force,
+		NixUser:             opts.NixUser,
+	}
+	tmpl, err := template.New("remoteScript").Parse(remoteSetupScriptTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse remote script template: %w", err)
+	}
+	var scriptBuf bytes.Buffer
+	if err := tmpl.Execute(&scriptBuf, params); err != nil {
+		return fmt.Errorf("failed to execute remote script template: %w", err)
+	}
+
+	remoteScriptPath := fmt.Sprintf("/tmp/setup_remote_%s.sh", inst.Name)
+	err = sshutil.CopyContentToRemote(ctx, sshClient, scriptBuf.Bytes(), remoteScriptPath, "0755")
+	if err != nil {
+		return fmt.Errorf("failed to copy rendered script to '%s': %w", remoteScriptPath, err)
+	}
+	log.Debug("Remote setup script copied.")
+
+	// Execute remote script
+	log.Info("Executing remote setup script This may take a while.")
+	remoteCommand := fmt.Sprintf("INSTANCE_ID=%s bash %s", inst.Name, remoteScriptPath)
+	err = sshutil.RunRemoteCommand(ctx, sshClient, remoteCommand)
+	if err != nil {
+		return fmt.Errorf("remote script execution failed: %w", err)
+	}
+	err = sshutil.RunRemoteCommand(ctx, sshClient, "rm "+remoteScriptPath)
+	if err != nil {
+		return fmt.Errorf("failed to remove remote script: %w", err)
+	}
+	return nil
 }
 
 func init() {
 	SetupCmd.Flags().BoolVar(&detachFlag, "detach", false, "Perform aarnphm/detachtools's specific setup steps")
 	SetupCmd.Flags().BoolVar(&engineFlag, "engine", false, "Whether to setup engine (vllm, sglang)")
 	SetupCmd.Flags().BoolVarP(&forceFlag, "force", "f", false, "Force setup even if already completed once")
-	SetupCmd.Flags().StringVar(&nixUserFlag, "user", "aarnphm", "Nix user to bootstrap")
+	SetupCmd.Flags().StringVar(&nixUserFlag, "user", defaultNixUser, "Nix user to bootstrap")
 }
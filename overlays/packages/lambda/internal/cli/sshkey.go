@@ -0,0 +1,311 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/configutil"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+var SSHKeyCmd = &cobra.Command{
+	Use:     "ssh-key",
+	Aliases: []string{"ssh-keys"},
+	Short:   "Manage SSH keys registered with Lambda Cloud",
+}
+
+var sshKeyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List registered SSH keys",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonOutput, err := jsonOutputFlag(cmd)
+		if err != nil {
+			return err
+		}
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error initializing API client: %w", err)
+		}
+		keys, err := client.ListSSHKeys(cmd.Context())
+		if err != nil {
+			return fmt.Errorf("error fetching SSH keys: %w", err)
+		}
+
+		if jsonOutput {
+			return printJSON(keys)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tID\tPUBLIC_KEY")
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", key.Name, key.ID, abbreviateKey(key.PublicKey))
+		}
+		return w.Flush()
+	},
+}
+
+var sshKeyAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Register an existing public key",
+	Long: `Register an existing public key under <name>. Without --from, the public
+half of --ssh-key-path (that path plus ".pub") is used.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonOutput, err := jsonOutputFlag(cmd)
+		if err != nil {
+			return err
+		}
+		from, _ := cmd.Flags().GetString("from")
+		if from == "" {
+			sshKeyPath, _ := cmd.Root().PersistentFlags().GetString("ssh-key-path")
+			from = sshKeyPath + ".pub"
+		}
+		path, err := configutil.ExpandPath(from)
+		if err != nil {
+			return fmt.Errorf("could not expand public key path '%s': %w", from, err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read public key: %w", err)
+		}
+		if _, _, _, _, err := ssh.ParseAuthorizedKey(data); err != nil {
+			return fmt.Errorf("'%s' is not a valid public key: %w", path, err)
+		}
+
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error initializing API client: %w", err)
+		}
+		key, err := client.AddSSHKey(cmd.Context(), args[0], strings.TrimSpace(string(data)))
+		if err != nil {
+			return fmt.Errorf("failed to register SSH key '%s': %w", args[0], err)
+		}
+
+		if jsonOutput {
+			return printJSON(sshKeyResult{SSHKey: *key, Action: "add", PublicKeyPath: path})
+		}
+		log.Infof("Registered SSH key '%s' (ID: %s) from %s", key.Name, key.ID, path)
+		return nil
+	},
+}
+
+var sshKeyGenerateCmd = &cobra.Command{
+	Use:   "generate <name>",
+	Short: "Create an ed25519 key pair locally and register its public key",
+	Long: `Create an ed25519 key pair, save it to --path (default ~/.ssh/<name>) and
+<path>.pub, then register the public key under <name>. The private key is
+written with mode 0600 and never sent to Lambda Cloud.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		jsonOutput, err := jsonOutputFlag(cmd)
+		if err != nil {
+			return err
+		}
+		keyPath, _ := cmd.Flags().GetString("path")
+		if keyPath == "" {
+			keyPath = "~/.ssh/" + name
+		}
+		privatePath, err := configutil.ExpandPath(keyPath)
+		if err != nil {
+			return fmt.Errorf("could not expand key path '%s': %w", keyPath, err)
+		}
+		publicPath := privatePath + ".pub"
+		force, _ := cmd.Flags().GetBool("force")
+		for _, p := range []string{privatePath, publicPath} {
+			if _, err := os.Stat(p); err == nil && !force {
+				return fmt.Errorf("'%s' already exists. Use --force to overwrite it", p)
+			}
+		}
+
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error initializing API client: %w", err)
+		}
+		privatePEM, authorizedKey, err := generateEd25519(name)
+		if err != nil {
+			return err
+		}
+		var key *api.SSHKey
+		err = writeKeyPair(privatePath, privatePEM, publicPath, authorizedKey, func() error {
+			var err error
+			if key, err = client.AddSSHKey(cmd.Context(), name, strings.TrimSpace(string(authorizedKey))); err != nil {
+				return fmt.Errorf("failed to register SSH key '%s': %w", name, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput {
+			return printJSON(sshKeyResult{SSHKey: *key, Action: "generate", PrivateKeyPath: privatePath, PublicKeyPath: publicPath})
+		}
+		log.Infof("Registered SSH key '%s' (ID: %s)", key.Name, key.ID)
+		log.Infof("Private key saved to %s", privatePath)
+		log.Infof("Use it with: lm config set ssh_key_name %s && lm config set ssh_key_path %s", name, privatePath)
+		return nil
+	},
+}
+
+var sshKeyDeleteCmd = &cobra.Command{
+	Use:               "delete <name_or_id>",
+	Short:             "Delete a registered SSH key",
+	Aliases:           []string{"rm"},
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeSSHKeyNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonOutput, err := jsonOutputFlag(cmd)
+		if err != nil {
+			return err
+		}
+		ctx := cmd.Context()
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error initializing API client: %w", err)
+		}
+		keys, err := client.ListSSHKeys(ctx)
+		if err != nil {
+			return fmt.Errorf("error fetching SSH keys: %w", err)
+		}
+		var target *api.SSHKey
+		for i := range keys {
+			if keys[i].Name == args[0] || keys[i].ID == args[0] {
+				target = &keys[i]
+				break
+			}
+		}
+		if target == nil {
+			return fmt.Errorf("SSH key '%s' not found", args[0])
+		}
+		if err := client.DeleteSSHKey(ctx, target.ID); err != nil {
+			return fmt.Errorf("failed to delete SSH key '%s' (ID: %s): %w", target.Name, target.ID, err)
+		}
+
+		if jsonOutput {
+			return printJSON(sshKeyResult{SSHKey: *target, Action: "delete"})
+		}
+		log.Infof("Deleted SSH key '%s' (ID: %s)", target.Name, target.ID)
+		return nil
+	},
+}
+
+// sshKeyResult is the --output json shape of the mutating ssh-key commands.
+type sshKeyResult struct {
+	api.SSHKey
+	Action         string `json:"action"`
+	PrivateKeyPath string `json:"private_key_path,omitempty"`
+	PublicKeyPath  string `json:"public_key_path,omitempty"`
+}
+
+// generateEd25519 returns an OpenSSH PEM private key and its authorized_keys line.
+func generateEd25519(comment string) ([]byte, []byte, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate ed25519 key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, comment)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " " + comment + "\n"
+	return pem.EncodeToMemory(block), []byte(authorized), nil
+}
+
+// writeKeyPair saves a key pair to privatePath and publicPath once register
+// succeeds. The pair is staged in temporary files next to them first, so
+// existing files are only replaced by a registered key and a registered key
+// is never lost.
+func writeKeyPair(privatePath string, privatePEM []byte, publicPath string, authorizedKey []byte, register func() error) error {
+	if err := os.MkdirAll(filepath.Dir(privatePath), 0o700); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+	tmpPrivate, err := writeTempFile(privatePath, privatePEM, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write private key: %w", err)
+	}
+	tmpPublic, err := writeTempFile(publicPath, authorizedKey, 0o644)
+	if err != nil {
+		removeKeyPair(tmpPrivate)
+		return fmt.Errorf("failed to write public key: %w", err)
+	}
+	if err := register(); err != nil {
+		removeKeyPair(tmpPrivate, tmpPublic)
+		return err
+	}
+	// The renamed files keep the staged permissions, whatever the old ones were
+	if err := os.Rename(tmpPrivate, privatePath); err != nil {
+		return fmt.Errorf("the key is registered but could not be saved to '%s'; it is in '%s' and '%s': %w", privatePath, tmpPrivate, tmpPublic, err)
+	}
+	if err := os.Rename(tmpPublic, publicPath); err != nil {
+		return fmt.Errorf("the key is registered but its public half could not be saved to '%s'; it is in '%s': %w", publicPath, tmpPublic, err)
+	}
+	return nil
+}
+
+// writeTempFile writes data with mode perm to a new temporary file next to
+// path and returns its name.
+func writeTempFile(path string, data []byte, perm os.FileMode) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if errC := f.Close(); err == nil {
+		err = errC
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), perm)
+	}
+	if err != nil {
+		removeKeyPair(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func removeKeyPair(paths ...string) {
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Warnf("Failed to remove '%s': %v", p, err)
+		}
+	}
+}
+
+// abbreviateKey shortens a public key for table output.
+func abbreviateKey(key string) string {
+	fields := strings.Fields(key)
+	if len(fields) < 2 {
+		return key
+	}
+	body := fields[1]
+	if len(body) > 16 {
+		body = body[:8] + "..." + body[len(body)-8:]
+	}
+	return fields[0] + " " + body
+}
+
+func init() {
+	sshKeyAddCmd.Flags().String("from", "", "Path to the public key to register (default: --ssh-key-path + .pub)")
+	sshKeyGenerateCmd.Flags().String("path", "", "Where to save the private key (default: ~/.ssh/<name>)")
+	sshKeyGenerateCmd.Flags().BoolP("force", "f", false, "Overwrite existing key files")
+
+	SSHKeyCmd.AddCommand(sshKeyListCmd)
+	SSHKeyCmd.AddCommand(sshKeyAddCmd)
+	SSHKeyCmd.AddCommand(sshKeyGenerateCmd)
+	SSHKeyCmd.AddCommand(sshKeyDeleteCmd)
+}
@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/fakecloud"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenerateEd25519(t *testing.T) {
+	privatePEM, authorizedKey, err := generateEd25519("laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.ParsePrivateKey(privatePEM)
+	if err != nil {
+		t.Fatalf("ParsePrivateKey: %v", err)
+	}
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey(authorizedKey)
+	if err != nil {
+		t.Fatalf("ParseAuthorizedKey: %v", err)
+	}
+	if !bytes.Equal(pub.Marshal(), signer.PublicKey().Marshal()) || pub.Type() != ssh.KeyAlgoED25519 || comment != "laptop" {
+		t.Errorf("public key %q does not belong to the private key", authorizedKey)
+	}
+}
+
+func TestWriteKeyPair(t *testing.T) {
+	dir := t.TempDir()
+	privatePath, publicPath := filepath.Join(dir, "mykey"), filepath.Join(dir, "mykey.pub")
+	if err := os.WriteFile(privatePath, []byte("old private"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(publicPath, []byte("old public"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("name taken")
+	err := writeKeyPair(privatePath, []byte("new private"), publicPath, []byte("new public"), func() error { return failed })
+	if !errors.Is(err, failed) {
+		t.Fatalf("writeKeyPair = %v, want the registration error", err)
+	}
+	assertKeyFiles(t, dir, "old private", "old public")
+
+	if err := writeKeyPair(privatePath, []byte("new private"), publicPath, []byte("new public"), func() error { return nil }); err != nil {
+		t.Fatalf("writeKeyPair: %v", err)
+	}
+	assertKeyFiles(t, dir, "new private", "new public")
+	for path, want := range map[string]os.FileMode{privatePath: 0o600, publicPath: 0o644} {
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != want {
+			t.Errorf("%s: mode %v (%v), want %v", path, info.Mode().Perm(), err, want)
+		}
+	}
+}
+
+// assertKeyFiles checks that dir holds exactly mykey and mykey.pub with the
+// given contents.
+func assertKeyFiles(t *testing.T, dir, private, public string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("%d files in %s, want only the key pair", len(entries), dir)
+	}
+	for name, want := range map[string]string{"mykey": private, "mykey.pub": public} {
+		if got, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(got) != want {
+			t.Errorf("%s = %q (%v), want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestSSHKeyGenerateNameTaken(t *testing.T) {
+	httpSrv := httptest.NewServer(fakecloud.New(fakecloud.Options{SSHKeyNames: []string{"laptop"}}))
+	defer httpSrv.Close()
+	root := newTestRoot(t, httpSrv.URL+fakecloud.BasePath, SSHKeyCmd)
+	t.Cleanup(func() { sshKeyGenerateCmd.Flags().Set("force", "false") })
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{"mykey": "old private", "mykey.pub": "old public"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root.SetArgs([]string{"ssh-key", "generate", "laptop", "--path", filepath.Join(dir, "mykey"), "--force"})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := root.ExecuteContext(ctx); err == nil || !strings.Contains(err.Error(), "laptop") {
+		t.Fatalf("lm ssh-key generate with a taken name: %v", err)
+	}
+	assertKeyFiles(t, dir, "old private", "old public")
+}
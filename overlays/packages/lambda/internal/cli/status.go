@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/term"
+)
+
+// statusLogInterval is how often a statusLine logs when stderr is not a terminal.
+const statusLogInterval = time.Minute
+
+// statusLine shows progress of a long wait. On a terminal it redraws one
+// line on stderr; otherwise it logs an update at most once per statusLogInterval.
+type statusLine struct {
+	tty     bool
+	drawn   bool
+	lastLog time.Time
+}
+
+func newStatusLine() *statusLine {
+	return &statusLine{tty: term.IsTerminal(int(os.Stderr.Fd()))}
+}
+
+// Update replaces the current status with msg.
+func (s *statusLine) Update(msg string) {
+	if !s.tty {
+		if time.Since(s.lastLog) >= statusLogInterval {
+			log.Info(msg)
+			s.lastLog = time.Now()
+		}
+		return
+	}
+	if width, _, err := term.GetSize(int(os.Stderr.Fd())); err == nil && width > 1 && len(msg) >= width {
+		msg = msg[:width-1]
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[K%s", msg)
+	s.drawn = true
+}
+
+// Done ends the status line so regular log output starts on a fresh line.
+func (s *statusLine) Done() {
+	if s.drawn {
+		fmt.Fprintln(os.Stderr)
+		s.drawn = false
+	}
+}
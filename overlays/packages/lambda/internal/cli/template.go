@@ -0,0 +1,311 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/configutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var TemplateCmd = &cobra.Command{
+	Use:   "template",
+	Short: "Manage launch templates for 'lm create --template'",
+	Long: `Manage launch templates for 'lm create --template'.
+
+Templates live under 'templates' in the config file and hold create flags:
+spec, regions, prefix, filesystem, count, wait, wait_for_capacity, setup
+({detach, engine}), connect and ttl. Flags given to create override the template.
+Save the flags of a create command line as a template with
+'lm create ... --save-template <name>'.`,
+}
+
+// flagValue is a create flag set by a template.
+type flagValue struct {
+	Name  string
+	Value string
+}
+
+// templateFlags returns the create flags t sets, in a stable order.
+func templateFlags(t configutil.Template) []flagValue {
+	var flags []flagValue
+	add := func(name, value string) { flags = append(flags, flagValue{name, value}) }
+	if len(t.Regions) > 0 {
+		add("regions", strings.Join(t.Regions, ","))
+	}
+	if t.Prefix != "" {
+		add("prefix", t.Prefix)
+	}
+	if t.Filesystem != "" {
+		add("filesystem", t.Filesystem)
+	}
+	if t.Count > 0 {
+		add("count", strconv.Itoa(t.Count))
+	}
+	if t.Wait != "" {
+		add("wait", t.Wait)
+	}
+	if t.WaitForCapacity {
+		add("wait-for-capacity", "true")
+	}
+	if t.Setup != nil {
+		add("setup", "true")
+		if t.Setup.Detach {
+			add("detach", "true")
+		}
+		if t.Setup.Engine {
+			add("engine", "true")
+		}
+	}
+	if t.Connect {
+		add("connect", "true")
+	}
+	if t.TTL != "" {
+		add("ttl", t.TTL)
+	}
+	return flags
+}
+
+// applyTemplate sets the create flags t sets, except those given on the
+// command line. A region argument overrides the template's regions, and an
+// explicit --setup its setup options.
+func applyTemplate(cmd *cobra.Command, t configutil.Template, regionArg bool) error {
+	flags := cmd.Flags()
+	setupGiven := flags.Changed("setup")
+	for _, f := range templateFlags(t) {
+		switch {
+		case flags.Changed(f.Name):
+			continue
+		case f.Name == "regions" && regionArg:
+			continue
+		case (f.Name == "detach" || f.Name == "engine") && setupGiven:
+			continue
+		}
+		if err := flags.Set(f.Name, f.Value); err != nil {
+			return fmt.Errorf("template sets invalid --%s '%s': %w", f.Name, f.Value, err)
+		}
+	}
+	return nil
+}
+
+// templateFromFlags captures the create flags given on the command line,
+// including those set by --template, as a template.
+func templateFromFlags(cmd *cobra.Command, spec, region string) configutil.Template {
+	flags := cmd.Flags()
+	t := configutil.Template{Spec: spec}
+	changedString := func(name string) string {
+		if !flags.Changed(name) {
+			return ""
+		}
+		v, _ := flags.GetString(name)
+		return v
+	}
+	changedBool := func(name string) bool {
+		v, _ := flags.GetBool(name)
+		return v && flags.Changed(name)
+	}
+	if region == "" {
+		region = changedString("regions")
+	}
+	for _, r := range strings.Split(region, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			t.Regions = append(t.Regions, r)
+		}
+	}
+	t.Prefix = changedString("prefix")
+	t.Filesystem = changedString("filesystem")
+	if flags.Changed("count") {
+		t.Count, _ = flags.GetInt("count")
+	}
+	t.Wait = changedString("wait")
+	t.WaitForCapacity = changedBool("wait-for-capacity")
+	if changedBool("setup") {
+		t.Setup = &configutil.TemplateSetup{Detach: changedBool("detach"), Engine: changedBool("engine")}
+	}
+	t.Connect = changedBool("connect")
+	t.TTL = changedString("ttl")
+	return t
+}
+
+// shellSafeRe matches arguments that need no quoting in a shell.
+var shellSafeRe = regexp.MustCompile(`^[A-Za-z0-9_.,:/=-]+$`)
+
+// shellQuote quotes s as one word for a POSIX shell.
+func shellQuote(s string) string {
+	if shellSafeRe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// templateCommandLine returns the create command line equivalent to t.
+func templateCommandLine(t configutil.Template) string {
+	parts := []string{"lm", "create"}
+	if t.Spec != "" {
+		parts = append(parts, shellQuote(t.Spec))
+	}
+	for _, f := range templateFlags(t) {
+		if f.Value == "true" {
+			parts = append(parts, "--"+f.Name)
+			continue
+		}
+		parts = append(parts, "--"+f.Name, shellQuote(f.Value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// loadTemplate returns the named template from the config file.
+func loadTemplate(cmd *cobra.Command, name string) (configutil.Template, error) {
+	_, cfg, err := loadConfig(cmd)
+	if err != nil {
+		return configutil.Template{}, err
+	}
+	t, err := cfg.Template(name)
+	if err != nil {
+		return configutil.Template{}, err
+	}
+	return *t, nil
+}
+
+// saveTemplate stores t under name in the config file.
+func saveTemplate(cmd *cobra.Command, name string, t configutil.Template) error {
+	if t.Spec != "" {
+		if _, err := parseSpecs(t.Spec); err != nil {
+			return err
+		}
+	}
+	if t.TTL != "" {
+		if _, err := parseTTL(t.TTL, false); err != nil {
+			return err
+		}
+	}
+	path, cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+	_, exists := cfg.Templates[name]
+	cfg.SetTemplate(name, t)
+	if err := cfg.Save(path); err != nil {
+		return err
+	}
+	verb := "Saved"
+	if exists {
+		verb = "Replaced"
+	}
+	log.Infof("%s template '%s' (%s):", verb, name, path)
+	log.Infof("  %s", templateCommandLine(t))
+	log.Infof("Launch it with: lm create --template %s", name)
+	return nil
+}
+
+// completeTemplateNames completes the names of the templates in the config.
+func completeTemplateNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	_, cfg, err := loadConfig(cmd)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	var names []string
+	for _, name := range cfg.TemplateNames() {
+		if strings.HasPrefix(name, toComplete) {
+			names = append(names, name)
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+var templateListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the launch templates",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonOutput, err := jsonOutputFlag(cmd)
+		if err != nil {
+			return err
+		}
+		_, cfg, err := loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+		if jsonOutput {
+			templates := cfg.Templates
+			if templates == nil {
+				templates = map[string]*configutil.Template{}
+			}
+			return printJSON(templates)
+		}
+		if len(cfg.Templates) == 0 {
+			log.Info("No templates. Save one with: lm create <spec> [flags] --save-template <name>")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tCOMMAND")
+		for _, name := range cfg.TemplateNames() {
+			if t := cfg.Templates[name]; t != nil {
+				fmt.Fprintf(w, "%s\t%s\n", name, templateCommandLine(*t))
+			}
+		}
+		return w.Flush()
+	},
+}
+
+var templateShowCmd = &cobra.Command{
+	Use:               "show <name>",
+	Short:             "Show a launch template and the create command it stands for",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeTemplateNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonOutput, err := jsonOutputFlag(cmd)
+		if err != nil {
+			return err
+		}
+		t, err := loadTemplate(cmd, args[0])
+		if err != nil {
+			return err
+		}
+		if jsonOutput {
+			return printJSON(t)
+		}
+		if err := printJSON(t); err != nil {
+			return err
+		}
+		fmt.Println(templateCommandLine(t))
+		return nil
+	},
+}
+
+var templateDeleteCmd = &cobra.Command{
+	Use:               "delete <name>",
+	Aliases:           []string{"rm"},
+	Short:             "Delete a launch template",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeTemplateNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, cfg, err := loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+		if _, err := cfg.Template(args[0]); err != nil {
+			return err
+		}
+		delete(cfg.Templates, args[0])
+		if err := cfg.Save(path); err != nil {
+			return err
+		}
+		log.Infof("Deleted template '%s'", args[0])
+		return nil
+	},
+}
+
+func init() {
+	TemplateCmd.AddCommand(templateListCmd)
+	TemplateCmd.AddCommand(templateShowCmd)
+	TemplateCmd.AddCommand(templateDeleteCmd)
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/configutil"
+	"github.com/spf13/cobra"
+)
+
+// newTemplateTestCmd returns a command with the create flags templates set.
+func newTemplateTestCmd(t *testing.T, args ...string) *cobra.Command {
+	t.Helper()
+	cmd := &cobra.Command{}
+	for _, name := range []string{"regions", "prefix", "filesystem", "wait", "ttl"} {
+		cmd.Flags().String(name, "", "")
+	}
+	cmd.Flags().Int("count", 1, "")
+	for _, name := range []string{"wait-for-capacity", "setup", "detach", "engine", "connect"} {
+		cmd.Flags().Bool(name, false, "")
+	}
+	if err := cmd.Flags().Parse(args); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return cmd
+}
+
+func TestApplyTemplate(t *testing.T) {
+	tmpl := configutil.Template{
+		Spec:    "8xh100_sxm5",
+		Regions: []string{"us-east-1", "us-*"},
+		Prefix:  "train",
+		Count:   2,
+		Setup:   &configutil.TemplateSetup{Detach: true},
+		TTL:     "6h",
+	}
+
+	cmd := newTemplateTestCmd(t, "--count", "4")
+	if err := applyTemplate(cmd, tmpl, false); err != nil {
+		t.Fatalf("applyTemplate: %v", err)
+	}
+	flags := cmd.Flags()
+	if got, _ := flags.GetInt("count"); got != 4 {
+		t.Errorf("count = %d, want the flag's 4", got)
+	}
+	if got, _ := flags.GetString("regions"); got != "us-east-1,us-*" {
+		t.Errorf("regions = %q", got)
+	}
+	if detach, _ := flags.GetBool("detach"); !detach {
+		t.Error("detach not set from the template")
+	}
+	got := templateFromFlags(cmd, tmpl.Spec, "")
+	want := tmpl
+	want.Count = 4
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("templateFromFlags = %+v, want %+v", got, want)
+	}
+
+	// A region argument and an explicit --setup replace the template's.
+	cmd = newTemplateTestCmd(t, "--setup=false")
+	if err := applyTemplate(cmd, tmpl, true); err != nil {
+		t.Fatalf("applyTemplate: %v", err)
+	}
+	if cmd.Flags().Changed("regions") || cmd.Flags().Changed("detach") {
+		t.Error("regions or detach applied despite an override")
+	}
+	if got := templateFromFlags(cmd, tmpl.Spec, "us-west-1"); got.Setup != nil || !reflect.DeepEqual(got.Regions, []string{"us-west-1"}) {
+		t.Errorf("templateFromFlags = %+v", got)
+	}
+}
+
+func TestTemplateCommandLine(t *testing.T) {
+	got := templateCommandLine(configutil.Template{
+		Spec:       "1xa10",
+		Regions:    []string{"us-*"},
+		Filesystem: "{{.User}}-data",
+		Setup:      &configutil.TemplateSetup{Engine: true},
+		Connect:    true,
+		TTL:        "90m",
+	})
+	want := "lm create 1xa10 --regions 'us-*' --filesystem '{{.User}}-data' --setup --engine --connect --ttl 90m"
+	if got != want {
+		t.Errorf("templateCommandLine = %q, want %q", got, want)
+	}
+}
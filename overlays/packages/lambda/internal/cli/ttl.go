@@ -0,0 +1,279 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/configutil"
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/sshutil"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+//go:embed ttl_remote.sh.in
+var ttlRemoteScriptTemplate string
+
+// ttlWarnBefore is how long before an instance expires its TTL timer warns
+// the users logged in to it.
+const ttlWarnBefore = 15 * time.Minute
+
+var TTLCmd = &cobra.Command{
+	Use:   "ttl",
+	Short: "Set or extend the time after which an instance is terminated",
+	Long: `Set or extend the time after which an instance is terminated.
+
+An instance past its TTL is terminated twice over: a systemd timer on the
+instance warns logged-in users with wall 15 minutes before it expires and then
+terminates it through the API, and 'lm reap' terminates whatever the local
+history says has expired.
+
+The timer needs an API key of its own, printed by the profile's
+ttl_api_key_command. It is stored on the instance, readable by root only, so
+use a dedicated key you can revoke. Without one only 'lm reap' enforces TTLs.`,
+}
+
+type ttlRemoteParams struct {
+	InstanceID   string
+	InstanceName string
+	APIURL       string
+	// ExpiresAt is a Unix time, 0 to remove the timer.
+	ExpiresAt  int64
+	WarnBefore int64
+}
+
+func renderTTLScript(params ttlRemoteParams) ([]byte, error) {
+	// The values end up in a quoted heredoc, which a line break would end.
+	for _, v := range []string{params.InstanceID, params.InstanceName, params.APIURL} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("cannot install a TTL timer for %q: line breaks are not supported", v)
+		}
+	}
+	tmpl, err := template.New("ttlRemote").Funcs(template.FuncMap{"shellquote": shellQuote}).Parse(ttlRemoteScriptTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse TTL script template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, params); err != nil {
+		return nil, fmt.Errorf("failed to execute TTL script template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ttlTimer installs the timers that make instances terminate themselves
+// when their TTL expires.
+type ttlTimer struct {
+	// APIKey is what the timers terminate their instance with. Without one
+	// no timer is installed.
+	APIKey string
+	APIURL string
+
+	SSHKeyPath string
+	SSHKeyName string
+	User       string
+}
+
+// newTTLTimer returns the timer installer for the profile, running its
+// ttl_api_key_command.
+func newTTLTimer(cmd *cobra.Command, client *api.Client, profile configutil.Profile) (*ttlTimer, error) {
+	key, err := profile.ResolveTTLAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	flags := cmd.Root().PersistentFlags()
+	t := &ttlTimer{APIKey: key, APIURL: client.BaseURL(), User: profile.RemoteUser}
+	t.SSHKeyPath, _ = flags.GetString("ssh-key-path")
+	t.SSHKeyName, _ = flags.GetString("ssh-key-name")
+	return t, nil
+}
+
+// install sets the timer of inst to expiresAt over sshClient, or removes it
+// if expiresAt is zero.
+func (t *ttlTimer) install(ctx context.Context, sshClient *ssh.Client, inst *api.Instance, expiresAt time.Time) error {
+	params := ttlRemoteParams{InstanceID: inst.ID, InstanceName: inst.Name, APIURL: t.APIURL, WarnBefore: int64(ttlWarnBefore.Seconds())}
+	if !expiresAt.IsZero() {
+		params.ExpiresAt = expiresAt.Unix()
+	}
+	script, err := renderTTLScript(params)
+	if err != nil {
+		return err
+	}
+	dir := "~/.lm/ttl"
+	if params.ExpiresAt != 0 {
+		header := []byte("Authorization: Bearer " + t.APIKey + "\n")
+		if err := sshutil.CopyContentToRemote(ctx, sshClient, header, dir+"/auth", "0600"); err != nil {
+			return fmt.Errorf("failed to copy the TTL API key: %w", err)
+		}
+	}
+	if err := sshutil.CopyContentToRemote(ctx, sshClient, script, dir+"/install.sh", "0755"); err != nil {
+		return fmt.Errorf("failed to copy the TTL script: %w", err)
+	}
+	return sshutil.StreamRemoteCommand(ctx, sshClient, "sudo bash "+dir+"/install.sh", io.Discard, os.Stderr)
+}
+
+// apply dials inst and sets its timer to expiresAt, or removes it if
+// expiresAt is zero. Without an API key no timer is installed, so apply only
+// warns that 'lm reap' has to enforce the TTL.
+func (t *ttlTimer) apply(ctx context.Context, inst *api.Instance, expiresAt time.Time) error {
+	if t.APIKey == "" {
+		if !expiresAt.IsZero() {
+			log.Warnf("ttl_api_key_command is not set, so '%s' cannot terminate itself; run 'lm reap' to enforce its TTL.", inst.Name)
+		}
+		return nil
+	}
+	if inst.Status != "active" || inst.IP == "" {
+		return fmt.Errorf("instance '%s' is %s", inst.Name, inst.Status)
+	}
+	sshClient, err := dialInstance(ctx, inst, t.SSHKeyPath, t.User, t.SSHKeyName)
+	if err != nil {
+		return err
+	}
+	defer sshClient.Close()
+	if err := t.install(ctx, sshClient, inst, expiresAt); err != nil {
+		return err
+	}
+	if expiresAt.IsZero() {
+		log.Infof("Removed the TTL timer from '%s'.", inst.Name)
+		return nil
+	}
+	log.Infof("The TTL timer on '%s' terminates it at %s.", inst.Name, expiresAt.Local().Format(time.DateTime))
+	return nil
+}
+
+// parseTTL parses a TTL such as 6h or 90m; with allowZero, 0 means none.
+func parseTTL(s string, allowZero bool) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	switch {
+	case err != nil:
+		return 0, fmt.Errorf("invalid TTL '%s': use a duration such as 6h or 90m", s)
+	case d < 0 || (d == 0 && !allowZero):
+		return 0, fmt.Errorf("invalid TTL '%s': must be positive", s)
+	}
+	return d, nil
+}
+
+// formatTTL renders the time left until expiresAt, for 'lm list'.
+func formatTTL(expiresAt, now time.Time) string {
+	switch {
+	case expiresAt.IsZero():
+		return "-"
+	case !now.Before(expiresAt):
+		return "expired"
+	default:
+		return formatUptime(expiresAt.Sub(now))
+	}
+}
+
+// updateTTL sets the expiry of an instance to what next returns for its
+// current expiry (zero if none), records it and updates the instance's
+// timer.
+func updateTTL(cmd *cobra.Command, nameOrID string, next func(current, now time.Time) (time.Time, error)) error {
+	jsonOutput, err := jsonOutputFlag(cmd)
+	if err != nil {
+		return err
+	}
+	_, profile, err := ActiveProfile(cmd)
+	if err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+	client, err := newAPIClient(cmd)
+	if err != nil {
+		return fmt.Errorf("error initializing API client: %w", err)
+	}
+	inst, err := client.ResolveInstance(ctx, nameOrID)
+	if err != nil {
+		return err
+	}
+	var current time.Time
+	if rec, ok := loadHistory()[inst.ID]; ok {
+		current = rec.ExpiresAt
+	}
+	expiresAt, err := next(current, time.Now())
+	if err != nil {
+		return fmt.Errorf("instance '%s': %w", inst.Name, err)
+	}
+	timer, err := newTTLTimer(cmd, client, profile)
+	if err != nil {
+		return err
+	}
+	if err := timer.apply(ctx, inst, expiresAt); err != nil {
+		// The timer installed with the current TTL is still running on the
+		// instance and will act on it.
+		if !current.IsZero() {
+			return fmt.Errorf("could not update the TTL timer on '%s', so it still terminates the instance at %s; the new TTL was not recorded, retry once the instance is reachable: %w",
+				inst.Name, current.Local().Format(time.DateTime), err)
+		}
+		log.Warnf("Could not install the TTL timer on '%s': %v. 'lm reap' enforces the TTL meanwhile.", inst.Name, err)
+	}
+	recordTTL(expiresAt, *inst)
+
+	if jsonOutput {
+		out := map[string]any{"instance_id": inst.ID, "instance_name": inst.Name, "expires_at": nil}
+		if !expiresAt.IsZero() {
+			out["expires_at"] = expiresAt
+		}
+		return printJSON(out)
+	}
+	if expiresAt.IsZero() {
+		log.Infof("Instance '%s' has no TTL.", inst.Name)
+		return nil
+	}
+	log.Infof("Instance '%s' expires at %s (in %s).", inst.Name, expiresAt.Local().Format(time.DateTime), formatUptime(time.Until(expiresAt)))
+	return nil
+}
+
+var ttlSetCmd = &cobra.Command{
+	Use:               "set <instance_name_or_id> <ttl>",
+	Short:             "Terminate an instance after a TTL from now, e.g. 6h; 0 removes the TTL",
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeInstanceNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ttl, err := parseTTL(args[1], true)
+		if err != nil {
+			return err
+		}
+		return updateTTL(cmd, args[0], func(_, now time.Time) (time.Time, error) {
+			if ttl == 0 {
+				return time.Time{}, nil
+			}
+			return now.Add(ttl), nil
+		})
+	},
+}
+
+var ttlExtendCmd = &cobra.Command{
+	Use:               "extend <instance_name_or_id> <duration>",
+	Short:             "Push back the expiry of an instance",
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeInstanceNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		extra, err := parseTTL(args[1], false)
+		if err != nil {
+			return err
+		}
+		return updateTTL(cmd, args[0], func(current, now time.Time) (time.Time, error) {
+			if current.IsZero() {
+				return time.Time{}, fmt.Errorf("no TTL to extend; set one with 'lm ttl set'")
+			}
+			// An instance that outlived its TTL is extended from now.
+			if current.Before(now) {
+				current = now
+			}
+			return current.Add(extra), nil
+		})
+	},
+}
+
+func init() {
+	TTLCmd.AddCommand(ttlSetCmd)
+	TTLCmd.AddCommand(ttlExtendCmd)
+}
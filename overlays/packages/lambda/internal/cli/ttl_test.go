@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/fakecloud"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+)
+
+func TestRenderTTLScript(t *testing.T) {
+	script, err := renderTTLScript(ttlRemoteParams{
+		InstanceID:   "0123abcd",
+		InstanceName: "train-8_h100_sxm5-0123abcd",
+		APIURL:       "https://cloud.lambda.ai/api/v1",
+		ExpiresAt:    1792000000,
+		WarnBefore:   900,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"INSTANCE_ID=0123abcd\n", "EXPIRES_AT=1792000000\n", "WARN_BEFORE=900\n", `if [ "1792000000" = 0 ]`} {
+		if !bytes.Contains(script, []byte(want)) {
+			t.Errorf("script lacks %q:\n%s", want, script)
+		}
+	}
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not installed")
+	}
+	path := filepath.Join(t.TempDir(), "install.sh")
+	if err := os.WriteFile(path, script, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("bash", "-n", path).CombinedOutput(); err != nil {
+		t.Errorf("bash -n: %v\n%s", err, out)
+	}
+}
+
+func TestRenderTTLScriptQuotesValues(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not installed")
+	}
+	name := `box $(touch pwned) "; touch pwned; '`
+	params := ttlRemoteParams{InstanceID: "0123abcd", InstanceName: name, APIURL: "https://cloud.lambda.ai/api/v1?a=1&b=2", ExpiresAt: 1792000000, WarnBefore: 900}
+	script, err := renderTTLScript(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write and source the env file the way the script and the timer do.
+	start := bytes.Index(script, []byte(`cat >"$conf/env"`))
+	end := bytes.Index(script[start:], []byte("\nEOF\n"))
+	if start < 0 || end < 0 {
+		t.Fatalf("no env file in the script:\n%s", script)
+	}
+	dir := t.TempDir()
+	snippet := "set -eu\nconf=.\n" + string(script[start:start+end]) + "\nEOF\n" + `. ./env; printf '%s\n%s' "$INSTANCE_NAME" "$API_URL"`
+	cmd := exec.Command("bash", "-c", snippet)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("bash: %v\n%s", err, out)
+	}
+	if want := name + "\n" + params.APIURL; string(out) != want {
+		t.Errorf("sourced %q, want %q", out, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+		t.Error("the instance name ran a command")
+	}
+
+	params.InstanceName = "box\nEOF"
+	if _, err := renderTTLScript(params); err == nil {
+		t.Error("renderTTLScript accepted a name with a line break")
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	if d, err := parseTTL("6h", false); err != nil || d != 6*time.Hour {
+		t.Errorf("parseTTL(6h) = %s, %v", d, err)
+	}
+	if d, err := parseTTL("0", true); err != nil || d != 0 {
+		t.Errorf("parseTTL(0, allowZero) = %s, %v", d, err)
+	}
+	for _, bad := range []string{"0", "-1h", "6 hours"} {
+		if _, err := parseTTL(bad, false); err == nil {
+			t.Errorf("parseTTL(%q) accepted", bad)
+		}
+	}
+}
+
+func TestFormatTTL(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for expiresAt, want := range map[time.Time]string{
+		{}:                                   "-",
+		now.Add(-time.Minute):                "expired",
+		now.Add(2*time.Hour + 5*time.Minute): "2h05m",
+	} {
+		if got := formatTTL(expiresAt, now); got != want {
+			t.Errorf("formatTTL(%s) = %q, want %q", expiresAt, got, want)
+		}
+	}
+}
+
+// TestUpdateTTLKeepsTimer checks that changing a TTL whose timer cannot be
+// reached fails instead of leaving the old timer to terminate the instance.
+func TestUpdateTTLKeepsTimer(t *testing.T) {
+	httpSrv := httptest.NewServer(fakecloud.New(fakecloud.Options{SSHKeyNames: []string{"laptop"}}))
+	defer httpSrv.Close()
+	apiURL := httpSrv.URL + fakecloud.BasePath
+	root := newTestRoot(t, apiURL, TTLCmd)
+	config := filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "lm", "config.json")
+	if err := os.MkdirAll(filepath.Dir(config), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(config, []byte(`{"profiles": {"default": {"ttl_api_key_command": "echo ttl-key"}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := api.NewClient("test-key", api.WithBaseURL(apiURL))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	ctx := context.Background()
+	ids, err := client.Launch(ctx, api.LaunchRequest{RegionName: "us-east-1", InstanceTypeName: "gpu_1x_a10", SSHKeyNames: []string{"laptop"}, Name: "box"})
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	recordTTL(expiresAt, api.Instance{ID: ids[0], Name: "box"})
+
+	// The instance has a fake IP and the SSH key does not exist.
+	for _, args := range [][]string{{"ttl", "extend", "box", "2h"}, {"ttl", "set", "box", "0"}} {
+		root.SetArgs(args)
+		err := root.ExecuteContext(ctx)
+		if err == nil || !strings.Contains(err.Error(), "still terminates the instance at") {
+			t.Errorf("lm %s: %v, want an error about the old timer", strings.Join(args, " "), err)
+		}
+		if rec := loadHistory()[ids[0]]; !rec.ExpiresAt.Equal(expiresAt) {
+			t.Errorf("lm %s recorded expiry %s, want %s unchanged", strings.Join(args, " "), rec.ExpiresAt, expiresAt)
+		}
+	}
+}
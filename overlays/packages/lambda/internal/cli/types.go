@@ -0,0 +1,228 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+	"github.com/spf13/cobra"
+)
+
+// instanceTypeRow is one line of `lm types`, flattened from InstanceTypeDetails.
+type instanceTypeRow struct {
+	Name            string   `json:"name"`
+	Spec            string   `json:"spec"`
+	GPUs            int      `json:"gpus"`
+	GPUDescription  string   `json:"gpu_description"`
+	VCPUs           int      `json:"vcpus"`
+	MemGiB          int      `json:"mem_gib"`
+	PricePerHour    float64  `json:"price_per_hour"`
+	PricePerGPUHour float64  `json:"price_per_gpu_hour"`
+	Regions         []string `json:"regions_with_capacity"`
+}
+
+// instanceTypeFilter holds the `lm types` filter flags. Zero values match everything.
+type instanceTypeFilter struct {
+	GPU           string
+	MinGPUs       int
+	Region        string
+	AvailableOnly bool
+	MaxPrice      float64
+}
+
+// instanceTypeSortKeys maps --sort values to less functions.
+var instanceTypeSortKeys = map[string]func(a, b instanceTypeRow) bool{
+	"name":      func(a, b instanceTypeRow) bool { return a.Name < b.Name },
+	"gpus":      func(a, b instanceTypeRow) bool { return a.GPUs < b.GPUs },
+	"gpu":       func(a, b instanceTypeRow) bool { return a.GPUDescription < b.GPUDescription },
+	"vcpus":     func(a, b instanceTypeRow) bool { return a.VCPUs < b.VCPUs },
+	"ram":       func(a, b instanceTypeRow) bool { return a.MemGiB < b.MemGiB },
+	"price":     func(a, b instanceTypeRow) bool { return a.PricePerHour < b.PricePerHour },
+	"gpu-price": func(a, b instanceTypeRow) bool { return a.PricePerGPUHour < b.PricePerGPUHour },
+	"regions":   func(a, b instanceTypeRow) bool { return len(a.Regions) < len(b.Regions) },
+}
+
+func sortKeyNames() []string {
+	keys := make([]string, 0, len(instanceTypeSortKeys))
+	for k := range instanceTypeSortKeys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// newInstanceTypeRow flattens details into a row.
+func newInstanceTypeRow(name string, details api.InstanceTypeDetails) instanceTypeRow {
+	t := details.InstanceType
+	row := instanceTypeRow{
+		Name:           name,
+		Spec:           specFromTypeName(name),
+		GPUs:           t.Specs.Gpus,
+		GPUDescription: t.GpuDescription,
+		VCPUs:          t.Specs.Vcpus,
+		MemGiB:         t.Specs.MemGiB,
+		PricePerHour:   float64(t.PriceCentsPerHour) / 100,
+		Regions:        []string{},
+	}
+	if row.GPUs > 0 {
+		row.PricePerGPUHour = row.PricePerHour / float64(row.GPUs)
+	}
+	for _, r := range details.RegionsWithCapacity {
+		row.Regions = append(row.Regions, r.Name)
+	}
+	sort.Strings(row.Regions)
+	return row
+}
+
+// specFromTypeName turns gpu_8x_h100_sxm5 into the 8xh100_sxm5 form that
+// `lm create` accepts. Names that do not follow the pattern are returned as is.
+func specFromTypeName(name string) string {
+	rest, ok := strings.CutPrefix(name, "gpu_")
+	if !ok {
+		return name
+	}
+	count, gpu, ok := strings.Cut(rest, "_")
+	if !ok {
+		return name
+	}
+	return count + gpu
+}
+
+// hasGPU reports whether gpu names the GPU family of row, e.g. h100, or its
+// exact model, e.g. h100_sxm5. The family of a type not named like
+// gpu_8x_h100_sxm5 is the first word of its GPU description.
+func (row instanceTypeRow) hasGPU(gpu string) bool {
+	family := gpuTypeOf(row.Name)
+	if family == "" {
+		family, _, _ = strings.Cut(strings.ToLower(row.GPUDescription), " ")
+	}
+	if gpu == family {
+		return true
+	}
+	m := specRe.FindStringSubmatch(row.Spec)
+	return m != nil && strings.ToLower(m[2]) == gpu
+}
+
+// matches reports whether row passes every filter.
+func (f instanceTypeFilter) matches(row instanceTypeRow) bool {
+	if f.GPU != "" && !row.hasGPU(strings.ToLower(f.GPU)) {
+		return false
+	}
+	if row.GPUs < f.MinGPUs {
+		return false
+	}
+	if f.AvailableOnly && len(row.Regions) == 0 {
+		return false
+	}
+	if f.Region != "" {
+		found := false
+		for _, r := range row.Regions {
+			if r == f.Region {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.MaxPrice > 0 && row.PricePerHour > f.MaxPrice {
+		return false
+	}
+	return true
+}
+
+// selectInstanceTypes filters and sorts the instance types. Ties are broken
+// by name so the output is stable.
+func selectInstanceTypes(types map[string]api.InstanceTypeDetails, filter instanceTypeFilter, sortKey string, desc bool) ([]instanceTypeRow, error) {
+	less, ok := instanceTypeSortKeys[sortKey]
+	if !ok {
+		return nil, fmt.Errorf("invalid sort column: %s. Supported columns: %s", sortKey, strings.Join(sortKeyNames(), ", "))
+	}
+	rows := make([]instanceTypeRow, 0, len(types))
+	for name, details := range types {
+		if row := newInstanceTypeRow(name, details); filter.matches(row) {
+			rows = append(rows, row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if desc {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return rows[i].Name < rows[j].Name
+	})
+	return rows, nil
+}
+
+var TypesCmd = &cobra.Command{
+	Use:     "types",
+	Aliases: []string{"instance-types"},
+	Short:   "List instance types with prices and live capacity",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonOutput, err := jsonOutputFlag(cmd)
+		if err != nil {
+			return err
+		}
+		var filter instanceTypeFilter
+		filter.GPU, _ = cmd.Flags().GetString("gpu")
+		filter.MinGPUs, _ = cmd.Flags().GetInt("min-gpus")
+		filter.Region, _ = cmd.Flags().GetString("region")
+		filter.AvailableOnly, _ = cmd.Flags().GetBool("available-only")
+		filter.MaxPrice, _ = cmd.Flags().GetFloat64("max-price")
+		sortKey, _ := cmd.Flags().GetString("sort")
+		desc, _ := cmd.Flags().GetBool("desc")
+
+		client, err := newAPIClient(cmd)
+		if err != nil {
+			return fmt.Errorf("error initializing API client: %w", err)
+		}
+		types, err := client.ListInstanceTypes(cmd.Context())
+		if err != nil {
+			return fmt.Errorf("error fetching instance types: %w", err)
+		}
+		rows, err := selectInstanceTypes(types, filter, strings.ToLower(sortKey), desc)
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput {
+			return printJSON(rows)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SPEC\tGPUS\tGPU\tVCPUS\tRAM\tPRICE/HR\tPRICE/GPU-HR\tREGIONS")
+		for _, row := range rows {
+			regions := "-"
+			if len(row.Regions) > 0 {
+				regions = strings.Join(row.Regions, ",")
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%d GiB\t$%.2f\t$%.2f\t%s\n",
+				row.Spec, row.GPUs, row.GPUDescription, row.VCPUs, row.MemGiB,
+				row.PricePerHour, row.PricePerGPUHour, regions)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	TypesCmd.Flags().String("gpu", "", "Only show types of this GPU family or model, e.g. h100 or h100_sxm5")
+	TypesCmd.Flags().Int("min-gpus", 0, "Only show types with at least this many GPUs")
+	TypesCmd.Flags().String("region", "", "Only show types with capacity in this region")
+	TypesCmd.Flags().Bool("available-only", false, "Only show types with capacity in some region")
+	TypesCmd.Flags().Float64("max-price", 0, "Only show types costing at most this many dollars per hour")
+	TypesCmd.Flags().String("sort", "price", "Column to sort by. One of: "+strings.Join(sortKeyNames(), "|"))
+	TypesCmd.Flags().Bool("desc", false, "Sort in descending order")
+	TypesCmd.RegisterFlagCompletionFunc("sort", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return sortKeyNames(), cobra.ShellCompDirectiveNoFileComp
+	})
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"testing"
+
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+)
+
+func testInstanceTypes() map[string]api.InstanceTypeDetails {
+	mk := func(desc string, gpus, cents int, regions ...string) api.InstanceTypeDetails {
+		var d api.InstanceTypeDetails
+		d.InstanceType.GpuDescription = desc
+		d.InstanceType.Specs.Gpus = gpus
+		d.InstanceType.PriceCentsPerHour = cents
+		for _, r := range regions {
+			d.RegionsWithCapacity = append(d.RegionsWithCapacity, api.Region{Name: r})
+		}
+		return d
+	}
+	return map[string]api.InstanceTypeDetails{
+		"gpu_1x_a10":       mk("A10 (24 GB PCIe)", 1, 75, "us-east-1"),
+		"gpu_1x_a100_sxm4": mk("A100 (40 GB SXM4)", 1, 129),
+		"gpu_1x_h100_pcie": mk("H100 (80 GB PCIe)", 1, 249, "us-west-3"),
+		"gpu_8x_h100_sxm5": mk("H100 (80 GB SXM5)", 8, 2392, "us-west-3", "us-east-1"),
+		"gpu_1x_gh200":     mk("GH200 (96 GB)", 1, 149),
+	}
+}
+
+func rowNames(rows []instanceTypeRow) []string {
+	names := make([]string, len(rows))
+	for i, r := range rows {
+		names[i] = r.Spec
+	}
+	return names
+}
+
+func TestSelectInstanceTypes(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter instanceTypeFilter
+		sort   string
+		desc   bool
+		want   []string
+	}{
+		{"all by price", instanceTypeFilter{}, "price", false, []string{"1xa10", "1xa100_sxm4", "1xgh200", "1xh100_pcie", "8xh100_sxm5"}},
+		{"gpu filter", instanceTypeFilter{GPU: "H100"}, "price", false, []string{"1xh100_pcie", "8xh100_sxm5"}},
+		{"gpu family is exact", instanceTypeFilter{GPU: "a10"}, "price", false, []string{"1xa10"}},
+		{"gpu model", instanceTypeFilter{GPU: "h100_sxm5"}, "price", false, []string{"8xh100_sxm5"}},
+		{"available only", instanceTypeFilter{AvailableOnly: true}, "price", true, []string{"8xh100_sxm5", "1xh100_pcie", "1xa10"}},
+		{"region", instanceTypeFilter{Region: "us-east-1"}, "name", false, []string{"1xa10", "8xh100_sxm5"}},
+		{"min gpus", instanceTypeFilter{MinGPUs: 2}, "price", false, []string{"8xh100_sxm5"}},
+		{"max price", instanceTypeFilter{MaxPrice: 1.5}, "price", false, []string{"1xa10", "1xa100_sxm4", "1xgh200"}},
+		{"sort by gpus", instanceTypeFilter{GPU: "h100"}, "gpus", false, []string{"1xh100_pcie", "8xh100_sxm5"}},
+	}
+	for _, tc := range tests {
+		rows, err := selectInstanceTypes(testInstanceTypes(), tc.filter, tc.sort, tc.desc)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		got := rowNames(rows)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+
+	if _, err := selectInstanceTypes(testInstanceTypes(), instanceTypeFilter{}, "bogus", false); err == nil {
+		t.Error("expected error for unknown sort column")
+	}
+
+	custom := instanceTypeRow{Name: "b200_preview", Spec: "b200_preview", GPUDescription: "B200 (180 GB SXM6)"}
+	if !custom.hasGPU("b200") || custom.hasGPU("b20") {
+		t.Error("types not named gpu_<n>x_<model> should match the first word of their GPU description exactly")
+	}
+}
+
+func TestPricePerGPUHour(t *testing.T) {
+	row := newInstanceTypeRow("gpu_8x_h100_sxm5", testInstanceTypes()["gpu_8x_h100_sxm5"])
+	if row.PricePerGPUHour != 2.99 {
+		t.Errorf("PricePerGPUHour = %v, want 2.99", row.PricePerGPUHour)
+	}
+}
@@ -0,0 +1,28 @@
+package configutil
+
+import "strings"
+
+// Budget caps what the instances launched by lm may cost. create and cluster
+// create refuse launches that would break a cap unless --override-budget is
+// given. Zero fields impose no cap.
+type Budget struct {
+	// MaxUSDPerHour caps the hourly price of all live instances together.
+	MaxUSDPerHour float64 `json:"max_usd_per_hour,omitempty"`
+	// MaxGPUsPerType caps the live GPUs of each GPU type, keyed like
+	// --max-instances-per-type counts them, e.g. {"h100": 16, "a100": 8}.
+	MaxGPUsPerType map[string]int `json:"max_gpus_per_type,omitempty"`
+	// MonthlyCapUSD caps this month's spend, projected to the end of the
+	// month at the burn rate after the launch.
+	MonthlyCapUSD float64 `json:"monthly_cap_usd,omitempty"`
+}
+
+// MaxGPUs returns the GPU cap for gpuType, matched case-insensitively, and
+// whether there is one.
+func (b Budget) MaxGPUs(gpuType string) (int, bool) {
+	for name, limit := range b.MaxGPUsPerType {
+		if strings.EqualFold(name, gpuType) && limit > 0 {
+			return limit, true
+		}
+	}
+	return 0, false
+}
@@ -10,12 +10,12 @@ import (
 // Constants related to configuration and defaults
 const (
 	DefaultRegion = "us-east-1"
-	SSHKeyName    = "aaron-mbp16"
 	RemoteUser    = "ubuntu"
 	// TODO: Consider removing hardcoded password
-	RemotePassword    = "toor"
-	DefaultSSHKeyPath = "~/.ssh/id_ed25519-paperspace"
-	BitwardenNoteName = "pat-lambda"
+	RemotePassword       = "toor"
+	DefaultSSHKeyPath    = "~/.ssh/id_ed25519"
+	BitwardenNoteName    = "pat-lambda"
+	GPGBitwardenNoteName = "gpg-passphrase"
 )
 
 // ExpandPath expands the tilde (~) prefix in a path to the user's home directory.
@@ -0,0 +1,338 @@
+package configutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// DefaultProfileName is used when neither --profile, LM_PROFILE nor the
+// config file select a profile.
+const DefaultProfileName = "default"
+
+// Profile holds per-user settings that would otherwise have to be passed as
+// flags on every invocation. Empty fields fall back to the built-in defaults.
+type Profile struct {
+	// API key source, in order of preference: a literal key, the name of an
+	// environment variable holding it, or a shell command printing it.
+	APIKey        string `json:"api_key,omitempty"`
+	APIKeyEnv     string `json:"api_key_env,omitempty"`
+	APIKeyCommand string `json:"api_key_command,omitempty"`
+	APIURL        string `json:"api_url,omitempty"`
+	// TTLAPIKeyCommand prints the API key instances launched with a TTL use
+	// to terminate themselves. The key is stored on those instances, so use
+	// a dedicated key that can be revoked on its own.
+	TTLAPIKeyCommand string `json:"ttl_api_key_command,omitempty"`
+
+	SSHKeyName string `json:"ssh_key_name,omitempty"`
+	SSHKeyPath string `json:"ssh_key_path,omitempty"`
+	RemoteUser string `json:"remote_user,omitempty"`
+
+	// Regions lists the preferred regions for new instances, in order. Entries
+	// may be globs such as "us-*".
+	Regions []string `json:"regions,omitempty"`
+	// InstanceTypes lists the instance types create tries when no spec is
+	// given, in order of preference, e.g. ["8xH100_SXM5", "8xA100_80GB_SXM4"].
+	InstanceTypes []string `json:"instance_types,omitempty"`
+	// FilesystemTemplate names the persistent file system attached to new
+	// instances, e.g. "{{.User}}-{{.Region}}". See FilesystemName.
+	FilesystemTemplate string `json:"filesystem_template,omitempty"`
+	// Prefix is the default instance name prefix for create.
+	Prefix string `json:"prefix,omitempty"`
+	// BitwardenNote is the Bitwarden note holding the GitHub token used by setup.
+	BitwardenNote string `json:"bitwarden_note,omitempty"`
+	// GPGBitwardenNote is the Bitwarden note holding the passphrase of the
+	// GPG key setup installs.
+	GPGBitwardenNote string `json:"gpg_bitwarden_note,omitempty"`
+
+	MaxRetries  *int   `json:"max_retries,omitempty"`
+	RetryBudget string `json:"retry_budget,omitempty"`
+}
+
+// Config is the on-disk configuration file.
+type Config struct {
+	CurrentProfile string              `json:"current_profile,omitempty"`
+	Profiles       map[string]*Profile `json:"profiles,omitempty"`
+	// Templates are named launch presets, shared by all profiles.
+	Templates map[string]*Template `json:"templates,omitempty"`
+	// Budget caps launches across all profiles.
+	Budget Budget `json:"budget,omitzero"`
+}
+
+// DefaultProfile returns the built-in settings used for anything a profile
+// leaves unset. The SSH key name defaults to the local user name.
+func DefaultProfile() Profile {
+	return Profile{
+		SSHKeyName:         LocalUser(),
+		SSHKeyPath:         DefaultSSHKeyPath,
+		RemoteUser:         RemoteUser,
+		Regions:            []string{DefaultRegion, "us-*"},
+		FilesystemTemplate: "{{.User}}-{{.Region}}",
+		Prefix:             "generic",
+		BitwardenNote:      BitwardenNoteName,
+		GPGBitwardenNote:   GPGBitwardenNoteName,
+	}
+}
+
+// ConfigPath returns the config file to use: explicit if set, then
+// $LM_CONFIG, then DefaultConfigPath.
+func ConfigPath(explicit string) (string, error) {
+	if explicit == "" {
+		explicit = os.Getenv("LM_CONFIG")
+	}
+	if explicit != "" {
+		return ExpandPath(explicit)
+	}
+	return DefaultConfigPath()
+}
+
+// DefaultConfigPath returns $XDG_CONFIG_HOME/lm/config.json, falling back
+// to ~/.config/lm/config.json.
+func DefaultConfigPath() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "lm", "config.json"), nil
+	}
+	return ExpandPath("~/.config/lm/config.json")
+}
+
+// KnownHostsPath returns the known_hosts file lm pins instance host keys
+// in: $LM_KNOWN_HOSTS, else known_hosts next to the default config file.
+func KnownHostsPath() (string, error) {
+	if path := os.Getenv("LM_KNOWN_HOSTS"); path != "" {
+		return ExpandPath(path)
+	}
+	config, err := DefaultConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(config), "known_hosts"), nil
+}
+
+// StateDir returns the directory lm keeps its local instance history in:
+// $XDG_STATE_HOME/lm, falling back to ~/.local/state/lm.
+func StateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "lm"), nil
+	}
+	return ExpandPath("~/.local/state/lm")
+}
+
+// LocalUser returns the name of the user running lm.
+func LocalUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// LoadConfig reads the config file at path. A missing file yields an empty config.
+func LoadConfig(path string) (*Config, error) {
+	cfg := &Config{Profiles: map[string]*Profile{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config file '%s': %w", path, err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing config file '%s': %w", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]*Profile{}
+	}
+	return cfg, nil
+}
+
+// Save writes the config to path, creating parent directories. The file is
+// only readable by the owner since profiles may hold an API key.
+func (c *Config) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("creating config directory: %w", err)
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding config: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("writing config file '%s': %w", tmp, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// ProfileName picks the active profile: the explicit name if set, then the
+// config's current profile, then DefaultProfileName.
+func (c *Config) ProfileName(explicit string) string {
+	switch {
+	case explicit != "":
+		return explicit
+	case c.CurrentProfile != "":
+		return c.CurrentProfile
+	default:
+		return DefaultProfileName
+	}
+}
+
+// Profile returns the named profile merged over DefaultProfile. Unknown
+// names yield the defaults unless strict is set.
+func (c *Config) Profile(name string, strict bool) (Profile, error) {
+	p, ok := c.Profiles[name]
+	if !ok {
+		if strict {
+			return Profile{}, fmt.Errorf("profile '%s' not found in config", name)
+		}
+		return DefaultProfile(), nil
+	}
+	return p.Merge(DefaultProfile()), nil
+}
+
+// Merge returns p with every unset field taken from def.
+func (p Profile) Merge(def Profile) Profile {
+	out := p
+	pv := reflect.ValueOf(&out).Elem()
+	dv := reflect.ValueOf(def)
+	for i := range pv.NumField() {
+		if pv.Field(i).IsZero() {
+			pv.Field(i).Set(dv.Field(i))
+		}
+	}
+	return out
+}
+
+// ResolveAPIKey returns the API key configured in the profile, if any.
+func (p Profile) ResolveAPIKey() (string, error) {
+	switch {
+	case p.APIKey != "":
+		return p.APIKey, nil
+	case p.APIKeyEnv != "":
+		return os.Getenv(p.APIKeyEnv), nil
+	case p.APIKeyCommand != "":
+		out, err := exec.Command("sh", "-c", p.APIKeyCommand).Output()
+		if err != nil {
+			return "", fmt.Errorf("running api_key_command: %w", err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	return "", nil
+}
+
+// ResolveTTLAPIKey returns the API key installed on instances for their TTL
+// timer, or "" if ttl_api_key_command is not set.
+func (p Profile) ResolveTTLAPIKey() (string, error) {
+	if p.TTLAPIKeyCommand == "" {
+		return "", nil
+	}
+	out, err := exec.Command("sh", "-c", p.TTLAPIKeyCommand).Output()
+	if err != nil {
+		return "", fmt.Errorf("running ttl_api_key_command: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// FilesystemName renders the profile's filesystem template for region.
+// The template can reference {{.Region}}, {{.User}} (the local user name)
+// and {{.Profile}}.
+func (p Profile) FilesystemName(profileName, region string) (string, error) {
+	tmpl, err := template.New("filesystem").Option("missingkey=error").Parse(p.FilesystemTemplate)
+	if err != nil {
+		return "", fmt.Errorf("parsing filesystem_template '%s': %w", p.FilesystemTemplate, err)
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, map[string]string{"Region": region, "User": LocalUser(), "Profile": profileName})
+	if err != nil {
+		return "", fmt.Errorf("rendering filesystem_template '%s': %w", p.FilesystemTemplate, err)
+	}
+	return buf.String(), nil
+}
+
+// ProfileKeys returns the settable profile keys, sorted.
+func ProfileKeys() []string {
+	t := reflect.TypeOf(Profile{})
+	keys := make([]string, 0, t.NumField())
+	for i := range t.NumField() {
+		keys = append(keys, jsonKey(t.Field(i)))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Get returns the value of key formatted for display. Lists are comma-separated.
+func (p Profile) Get(key string) (string, error) {
+	v, err := p.field(key)
+	if err != nil {
+		return "", err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Slice:
+		return strings.Join(v.Interface().([]string), ","), nil
+	case reflect.Pointer:
+		if v.IsNil() {
+			return "", nil
+		}
+		return strconv.Itoa(int(v.Elem().Int())), nil
+	}
+	return "", fmt.Errorf("unsupported type for key '%s'", key)
+}
+
+// Set parses value and assigns it to key. Lists are comma-separated; an
+// empty value clears the key.
+func (p *Profile) Set(key, value string) error {
+	v, err := p.field(key)
+	if err != nil {
+		return err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case reflect.Pointer:
+		if value == "" {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for '%s': %w", key, err)
+		}
+		v.Set(reflect.ValueOf(&n))
+	default:
+		return fmt.Errorf("unsupported type for key '%s'", key)
+	}
+	return nil
+}
+
+func (p *Profile) field(key string) (reflect.Value, error) {
+	v := reflect.ValueOf(p).Elem()
+	t := v.Type()
+	for i := range t.NumField() {
+		if jsonKey(t.Field(i)) == key {
+			return v.Field(i), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("unknown config key '%s'. Valid keys: %s", key, strings.Join(ProfileKeys(), ", "))
+}
+
+func jsonKey(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	return name
+}
@@ -0,0 +1,101 @@
+package configutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	cfg, err := LoadConfig(filepath.Join(t.TempDir(), "nope.json"))
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if got := cfg.ProfileName(""); got != DefaultProfileName {
+		t.Errorf("ProfileName = %q, want %q", got, DefaultProfileName)
+	}
+	p, err := cfg.Profile(DefaultProfileName, false)
+	if err != nil {
+		t.Fatalf("Profile: %v", err)
+	}
+	if p.SSHKeyName != LocalUser() || p.RemoteUser != RemoteUser || p.FilesystemTemplate != "{{.User}}-{{.Region}}" {
+		t.Errorf("expected built-in defaults, got %+v", p)
+	}
+	if _, err := cfg.Profile("work", true); err == nil {
+		t.Error("expected error for unknown profile in strict mode")
+	}
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lm", "config.json")
+	cfg := &Config{CurrentProfile: "work", Profiles: map[string]*Profile{"work": {}}}
+	for key, value := range map[string]string{
+		"ssh_key_name":        "laptop",
+		"regions":             "us-west-1, us-east-1",
+		"instance_types":      "8xH100_SXM5,8xA100_80GB_SXM4",
+		"filesystem_template": "{{.Profile}}-{{.Region}}",
+		"max_retries":         "5",
+	} {
+		if err := cfg.Profiles["work"].Set(key, value); err != nil {
+			t.Fatalf("Set(%s): %v", key, err)
+		}
+	}
+	if err := cfg.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("config mode = %o, want 600", perm)
+	}
+
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	name := loaded.ProfileName("")
+	p, err := loaded.Profile(name, true)
+	if err != nil {
+		t.Fatalf("Profile: %v", err)
+	}
+	if got, _ := p.Get("regions"); got != "us-west-1,us-east-1" {
+		t.Errorf("regions = %q", got)
+	}
+	if got, _ := p.Get("instance_types"); got != "8xH100_SXM5,8xA100_80GB_SXM4" {
+		t.Errorf("instance_types = %q", got)
+	}
+	if got, _ := p.Get("max_retries"); got != "5" {
+		t.Errorf("max_retries = %q", got)
+	}
+	if p.SSHKeyName != "laptop" || p.RemoteUser != RemoteUser {
+		t.Errorf("profile not merged over defaults: %+v", p)
+	}
+	fs, err := p.FilesystemName(name, "us-west-1")
+	if err != nil || fs != "work-us-west-1" {
+		t.Errorf("FilesystemName = %q, %v", fs, err)
+	}
+	if err := p.Set("nope", "x"); err == nil {
+		t.Error("expected error for unknown key")
+	}
+}
+
+func TestResolveAPIKey(t *testing.T) {
+	t.Setenv("LM_TEST_KEY", "from-env")
+	tests := []struct {
+		profile Profile
+		want    string
+	}{
+		{Profile{APIKey: "literal", APIKeyEnv: "LM_TEST_KEY"}, "literal"},
+		{Profile{APIKeyEnv: "LM_TEST_KEY"}, "from-env"},
+		{Profile{APIKeyCommand: "echo from-command"}, "from-command"},
+		{Profile{}, ""},
+	}
+	for _, tc := range tests {
+		got, err := tc.profile.ResolveAPIKey()
+		if err != nil || got != tc.want {
+			t.Errorf("ResolveAPIKey(%+v) = %q, %v; want %q", tc.profile, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package configutil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Template is a named launch preset for 'lm create --template'. Each field
+// stands for the create flag of the same name; empty fields leave the flag
+// alone.
+type Template struct {
+	// Spec is the instance type spec, e.g. "8xH100_SXM5,8xA100_80GB_SXM4".
+	Spec    string   `json:"spec,omitempty"`
+	Regions []string `json:"regions,omitempty"`
+	Prefix  string   `json:"prefix,omitempty"`
+	// Filesystem is the file system to attach, which may use the fields of
+	// filesystem_template, e.g. "team-data" or "{{.User}}-{{.Region}}".
+	Filesystem      string `json:"filesystem,omitempty"`
+	Count           int    `json:"count,omitempty"`
+	Wait            string `json:"wait,omitempty"`
+	WaitForCapacity bool   `json:"wait_for_capacity,omitempty"`
+	// Setup, if set, runs 'lm setup' on the new instance.
+	Setup   *TemplateSetup `json:"setup,omitempty"`
+	Connect bool           `json:"connect,omitempty"`
+	// TTL is a duration such as "6h" after which the instances terminate.
+	TTL string `json:"ttl,omitempty"`
+}
+
+// TemplateSetup holds the setup options of a template.
+type TemplateSetup struct {
+	Detach bool `json:"detach,omitempty"`
+	Engine bool `json:"engine,omitempty"`
+}
+
+// TemplateNames returns the names of the templates in the config, sorted.
+func (c *Config) TemplateNames() []string {
+	names := make([]string, 0, len(c.Templates))
+	for name := range c.Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Template returns the named template.
+func (c *Config) Template(name string) (*Template, error) {
+	t, ok := c.Templates[name]
+	if !ok || t == nil {
+		if len(c.Templates) == 0 {
+			return nil, fmt.Errorf("template '%s' not found: the config has no templates", name)
+		}
+		return nil, fmt.Errorf("template '%s' not found. Available templates: %s", name, strings.Join(c.TemplateNames(), ", "))
+	}
+	return t, nil
+}
+
+// SetTemplate adds or replaces the named template.
+func (c *Config) SetTemplate(name string, t Template) {
+	if c.Templates == nil {
+		c.Templates = map[string]*Template{}
+	}
+	c.Templates[name] = &t
+}
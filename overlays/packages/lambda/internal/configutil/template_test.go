@@ -0,0 +1,35 @@
+package configutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := `{"templates": {"train": {"spec": "8xH100_SXM5", "regions": ["us-*"], "filesystem": "team-data", "setup": {"detach": true, "engine": true}}}}`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	train, err := cfg.Template("train")
+	if err != nil {
+		t.Fatalf("Template: %v", err)
+	}
+	if train.Spec != "8xH100_SXM5" || train.Filesystem != "team-data" || train.Setup == nil || !train.Setup.Engine {
+		t.Errorf("unexpected template %+v", train)
+	}
+
+	cfg.SetTemplate("eval", Template{Spec: "1xA10"})
+	if got := strings.Join(cfg.TemplateNames(), ","); got != "eval,train" {
+		t.Errorf("TemplateNames = %s", got)
+	}
+	if _, err := cfg.Template("missing"); err == nil || !strings.Contains(err.Error(), "eval, train") {
+		t.Errorf("want error listing the templates, got %v", err)
+	}
+}
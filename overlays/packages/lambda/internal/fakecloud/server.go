@@ -0,0 +1,615 @@
+// Package fakecloud implements an in-memory stand-in for the Lambda Cloud v1
+// API. It is used by `lm dev fake-cloud` and by tests to exercise the CLI end
+// to end without network access or a real account.
+package fakecloud
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+	"golang.org/x/crypto/ssh"
+)
+
+// BasePath is the path prefix the fake API is served under, mirroring the
+// real endpoint (https://cloud.lambda.ai/api/v1).
+const BasePath = "/api/v1"
+
+// Instance statuses reported by the API.
+const (
+	StatusBooting     = "booting"
+	StatusActive      = "active"
+	StatusTerminating = "terminating"
+	StatusTerminated  = "terminated"
+)
+
+// Options configures a Server.
+type Options struct {
+	// APIKey, when set, is the only bearer token accepted. Any non-empty
+	// token is accepted otherwise.
+	APIKey string
+	// BootDelay is how long a launched instance stays "booting" before it
+	// becomes "active" and gets an IP address.
+	BootDelay time.Duration
+	// TerminateDelay is how long a terminated instance stays "terminating".
+	TerminateDelay time.Duration
+	// Retention is how long terminated instances remain in the instance list.
+	Retention time.Duration
+	// SSHKeyNames are registered as SSH keys at startup.
+	SSHKeyNames []string
+	// Now overrides the clock, for tests.
+	Now func() time.Time
+}
+
+// Server is an in-memory Lambda Cloud API. It is safe for concurrent use.
+type Server struct {
+	opts Options
+	mux  *http.ServeMux
+
+	mu          sync.Mutex
+	instances   []*instance
+	types       map[string]*instanceType
+	fileSystems []lambda.FileSystem
+	sshKeys     []lambda.SSHKey
+	firewall    []lambda.FirewallRule
+	images      []lambda.Image
+	nextIP      int
+}
+
+type instance struct {
+	lambda.Instance
+	launchedAt   time.Time
+	terminatedAt time.Time
+}
+
+type instanceType struct {
+	lambda.InstanceType
+	// capacity is the number of instances that can still be launched, per region.
+	capacity map[string]int
+}
+
+// New returns a Server seeded with a default catalog of instance types.
+func New(opts Options) *Server {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Retention == 0 {
+		opts.Retention = time.Minute
+	}
+	s := &Server{
+		opts:   opts,
+		types:  make(map[string]*instanceType),
+		nextIP: 10,
+		firewall: []lambda.FirewallRule{
+			{Protocol: "tcp", PortRange: []int{22, 22}, SourceNetwork: "0.0.0.0/0", Description: "Allow SSH"},
+		},
+		images: []lambda.Image{
+			{ID: "img-lambda-stack-2204", Name: "Lambda Stack 22.04", Family: "lambda-stack-22-04", Version: "22.04", Architecture: "x86_64"},
+			{ID: "img-lambda-stack-2404", Name: "Lambda Stack 24.04", Family: "lambda-stack-24-04", Version: "24.04", Architecture: "x86_64"},
+		},
+	}
+	for _, t := range defaultCatalog {
+		s.AddInstanceType(t.name, t.description, t.gpuDescription, t.gpus, t.vcpus, t.memGiB, t.priceCents, t.capacity)
+	}
+	for _, name := range opts.SSHKeyNames {
+		s.sshKeys = append(s.sshKeys, lambda.SSHKey{ID: newID(), Name: name, PublicKey: "ssh-ed25519 AAAA-fake " + name})
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("GET "+BasePath+"/instances", s.listInstances)
+	s.mux.HandleFunc("GET "+BasePath+"/instances/{id}", s.getInstance)
+	s.mux.HandleFunc("POST "+BasePath+"/instances/{id}", s.updateInstance)
+	s.mux.HandleFunc("POST "+BasePath+"/instance-operations/launch", s.launch)
+	s.mux.HandleFunc("POST "+BasePath+"/instance-operations/terminate", s.terminate)
+	s.mux.HandleFunc("POST "+BasePath+"/instance-operations/restart", s.restart)
+	s.mux.HandleFunc("GET "+BasePath+"/instance-types", s.listInstanceTypes)
+	s.mux.HandleFunc("GET "+BasePath+"/file-systems", s.listFileSystems)
+	s.mux.HandleFunc("POST "+BasePath+"/filesystems", s.createFileSystem)
+	s.mux.HandleFunc("DELETE "+BasePath+"/filesystems/{id}", s.deleteFileSystem)
+	s.mux.HandleFunc("GET "+BasePath+"/ssh-keys", s.listSSHKeys)
+	s.mux.HandleFunc("POST "+BasePath+"/ssh-keys", s.addSSHKey)
+	s.mux.HandleFunc("DELETE "+BasePath+"/ssh-keys/{id}", s.deleteSSHKey)
+	s.mux.HandleFunc("GET "+BasePath+"/images", s.listImages)
+	s.mux.HandleFunc("GET "+BasePath+"/firewall-rules", s.listFirewallRules)
+	s.mux.HandleFunc("PUT "+BasePath+"/firewall-rules", s.setFirewallRules)
+	return s
+}
+
+// catalogEntry describes one instance type of the default catalog.
+type catalogEntry struct {
+	name, description, gpuDescription string
+	gpus, vcpus, memGiB, priceCents   int
+	capacity                          map[string]int
+}
+
+// regions are the region names the fake accepts, those of the real API.
+var regions = map[string]bool{
+	"us-east-1": true, "us-east-2": true, "us-east-3": true,
+	"us-west-1": true, "us-west-2": true, "us-west-3": true,
+	"us-south-1": true, "us-south-2": true, "us-south-3": true,
+	"us-midwest-1": true, "europe-central-1": true, "me-west-1": true,
+	"asia-south-1": true, "asia-northeast-1": true, "asia-northeast-2": true,
+}
+
+var defaultCatalog = []catalogEntry{
+	{"gpu_1x_a10", "1x A10 (24 GB PCIe)", "A10 (24 GB PCIe)", 1, 30, 200, 75, map[string]int{"us-east-1": 4, "us-west-1": 4}},
+	{"gpu_1x_a100_sxm4", "1x A100 (40 GB SXM4)", "A100 (40 GB SXM4)", 1, 30, 200, 129, map[string]int{"us-east-1": 2, "us-south-1": 2}},
+	{"gpu_8x_a100_80gb_sxm4", "8x A100 (80 GB SXM4)", "A100 (80 GB SXM4)", 8, 240, 1800, 1432, map[string]int{"us-west-1": 1}},
+	{"gpu_1x_h100_pcie", "1x H100 (80 GB PCIe)", "H100 (80 GB PCIe)", 1, 26, 200, 249, map[string]int{"us-east-1": 2, "us-west-3": 2}},
+	{"gpu_8x_h100_sxm5", "8x H100 (80 GB SXM5)", "H100 (80 GB SXM5)", 8, 208, 1800, 2392, map[string]int{"us-west-3": 1}},
+	{"gpu_1x_gh200", "1x GH200 (96 GB)", "GH200 (96 GB)", 1, 64, 432, 149, map[string]int{}},
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if auth == "" || (s.opts.APIKey != "" && auth != s.opts.APIKey) {
+		writeError(w, http.StatusUnauthorized, lambda.ErrCodeInvalidAPIKey, "API key was invalid, expired, or deleted.", "Check your API key or create a new one, then try again.")
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// AddInstanceType adds (or replaces) an instance type with the given capacity per region.
+func (s *Server) AddInstanceType(name, description, gpuDescription string, gpus, vcpus, memGiB, priceCents int, capacity map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := &instanceType{capacity: make(map[string]int)}
+	t.Name = name
+	t.Description = description
+	t.GpuDescription = gpuDescription
+	t.PriceCentsPerHour = priceCents
+	t.Specs.Gpus = gpus
+	t.Specs.Vcpus = vcpus
+	t.Specs.MemGiB = memGiB
+	for region, n := range capacity {
+		t.capacity[region] = n
+	}
+	s.types[name] = t
+}
+
+// SetCapacity sets how many more instances of typeName can be launched in region.
+func (s *Server) SetCapacity(typeName, region string, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.types[typeName]
+	if !ok {
+		return fmt.Errorf("unknown instance type '%s'", typeName)
+	}
+	t.capacity[region] = n
+	return nil
+}
+
+// advance applies time-based state transitions. Callers must hold s.mu.
+func (s *Server) advance() {
+	now := s.opts.Now()
+	kept := s.instances[:0]
+	for _, inst := range s.instances {
+		switch inst.Status {
+		case StatusBooting:
+			if now.Sub(inst.launchedAt) >= s.opts.BootDelay {
+				inst.Status = StatusActive
+				inst.IP = fmt.Sprintf("192.0.2.%d", s.nextIP)
+				inst.PrivateIP = fmt.Sprintf("10.0.0.%d", s.nextIP)
+				inst.Hostname = strings.ReplaceAll(inst.IP, ".", "-")
+				s.nextIP++
+			}
+		case StatusTerminating:
+			if now.Sub(inst.terminatedAt) >= s.opts.TerminateDelay {
+				inst.Status = StatusTerminated
+				inst.IP = ""
+			}
+		case StatusTerminated:
+			if now.Sub(inst.terminatedAt) >= s.opts.TerminateDelay+s.opts.Retention {
+				continue
+			}
+		}
+		kept = append(kept, inst)
+	}
+	s.instances = kept
+}
+
+func (s *Server) findInstance(id string) *instance {
+	for _, inst := range s.instances {
+		if inst.ID == id {
+			return inst
+		}
+	}
+	return nil
+}
+
+func (s *Server) listInstances(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance()
+	data := make([]lambda.Instance, 0, len(s.instances))
+	for _, inst := range s.instances {
+		data = append(data, inst.Instance)
+	}
+	writeData(w, data)
+}
+
+func (s *Server) getInstance(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance()
+	inst := s.findInstance(r.PathValue("id"))
+	if inst == nil {
+		writeError(w, http.StatusNotFound, lambda.ErrCodeObjectDoesNotExist, "Specified instance does not exist.", "")
+		return
+	}
+	writeData(w, inst.Instance)
+}
+
+func (s *Server) updateInstance(w http.ResponseWriter, r *http.Request) {
+	var req lambda.UpdateInstanceRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance()
+	inst := s.findInstance(r.PathValue("id"))
+	if inst == nil {
+		writeError(w, http.StatusNotFound, lambda.ErrCodeObjectDoesNotExist, "Specified instance does not exist.", "")
+		return
+	}
+	inst.Name = req.Name
+	writeData(w, inst.Instance)
+}
+
+func (s *Server) launch(w http.ResponseWriter, r *http.Request) {
+	var req lambda.LaunchRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance()
+
+	t, ok := s.types[req.InstanceTypeName]
+	if !ok {
+		writeError(w, http.StatusNotFound, lambda.ErrCodeObjectDoesNotExist, fmt.Sprintf("Instance type '%s' does not exist.", req.InstanceTypeName), "")
+		return
+	}
+	if !regions[req.RegionName] {
+		writeError(w, http.StatusBadRequest, lambda.ErrCodeInvalidParameters, fmt.Sprintf("Region '%s' does not exist.", req.RegionName), "")
+		return
+	}
+	if len(req.SSHKeyNames) != 1 {
+		writeError(w, http.StatusBadRequest, lambda.ErrCodeInvalidParameters, "Exactly one SSH key must be specified.", "")
+		return
+	}
+	if !s.hasSSHKey(req.SSHKeyNames[0]) {
+		writeError(w, http.StatusBadRequest, lambda.ErrCodeInvalidParameters, fmt.Sprintf("SSH key '%s' does not exist.", req.SSHKeyNames[0]), "Add the key with 'lm ssh-key add' first.")
+		return
+	}
+	for _, fsName := range req.FileSystemNames {
+		fs := s.findFileSystemByName(fsName)
+		if fs == nil || fs.Region.Name != req.RegionName {
+			writeError(w, http.StatusBadRequest, lambda.ErrCodeInvalidParameters, fmt.Sprintf("File system '%s' does not exist in region '%s'.", fsName, req.RegionName), "")
+			return
+		}
+	}
+	quantity := req.Quantity
+	if quantity <= 0 {
+		quantity = 1
+	}
+	if t.capacity[req.RegionName] < quantity {
+		writeError(w, http.StatusBadRequest, lambda.ErrCodeInsufficientCapacity, "Not enough capacity to fulfill launch request.", "Try a different region or instance type.")
+		return
+	}
+	t.capacity[req.RegionName] -= quantity
+
+	var ids []string
+	for range quantity {
+		inst := &instance{launchedAt: s.opts.Now()}
+		inst.ID = newID()
+		inst.Name = req.Name
+		inst.Status = StatusBooting
+		inst.SSHKeyNames = req.SSHKeyNames
+		inst.FileSystemNames = req.FileSystemNames
+		inst.Region.Name = req.RegionName
+		inst.InstanceType.Name = t.Name
+		inst.InstanceType.PriceCentsPerHour = t.PriceCentsPerHour
+		s.instances = append(s.instances, inst)
+		ids = append(ids, inst.ID)
+	}
+	writeData(w, map[string]any{"instance_ids": ids})
+}
+
+func (s *Server) terminate(w http.ResponseWriter, r *http.Request) {
+	var req lambda.TerminateRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance()
+
+	var targets []*instance
+	for _, id := range req.InstanceIDs {
+		inst := s.findInstance(id)
+		if inst == nil {
+			writeError(w, http.StatusNotFound, lambda.ErrCodeObjectDoesNotExist, fmt.Sprintf("Instance '%s' does not exist.", id), "")
+			return
+		}
+		targets = append(targets, inst)
+	}
+	terminated := make([]lambda.Instance, 0, len(targets))
+	for _, inst := range targets {
+		if inst.Status != StatusTerminating && inst.Status != StatusTerminated {
+			inst.Status = StatusTerminating
+			inst.terminatedAt = s.opts.Now()
+			if t, ok := s.types[inst.InstanceType.Name]; ok {
+				t.capacity[inst.Region.Name]++
+			}
+		}
+		terminated = append(terminated, inst.Instance)
+	}
+	writeData(w, map[string]any{"terminated_instances": terminated})
+}
+
+func (s *Server) restart(w http.ResponseWriter, r *http.Request) {
+	var req lambda.RestartRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance()
+
+	restarted := make([]lambda.Instance, 0, len(req.InstanceIDs))
+	for _, id := range req.InstanceIDs {
+		inst := s.findInstance(id)
+		if inst == nil || inst.Status != StatusActive {
+			writeError(w, http.StatusBadRequest, lambda.ErrCodeInvalidParameters, fmt.Sprintf("Instance '%s' is not active.", id), "")
+			return
+		}
+		restarted = append(restarted, inst.Instance)
+	}
+	writeData(w, map[string]any{"restarted_instances": restarted})
+}
+
+func (s *Server) listInstanceTypes(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := make(map[string]lambda.InstanceTypeDetails, len(s.types))
+	for name, t := range s.types {
+		details := lambda.InstanceTypeDetails{InstanceType: t.InstanceType, RegionsWithCapacity: []lambda.Region{}}
+		for region, n := range t.capacity {
+			if n > 0 {
+				details.RegionsWithCapacity = append(details.RegionsWithCapacity, lambda.Region{Name: region})
+			}
+		}
+		sort.Slice(details.RegionsWithCapacity, func(i, j int) bool {
+			return details.RegionsWithCapacity[i].Name < details.RegionsWithCapacity[j].Name
+		})
+		data[name] = details
+	}
+	writeData(w, data)
+}
+
+func (s *Server) findFileSystemByName(name string) *lambda.FileSystem {
+	for i := range s.fileSystems {
+		if s.fileSystems[i].Name == name {
+			return &s.fileSystems[i]
+		}
+	}
+	return nil
+}
+
+// fileSystemInUse reports whether a non-terminated instance mounts name.
+// Callers must hold s.mu.
+func (s *Server) fileSystemInUse(name string) bool {
+	for _, inst := range s.instances {
+		if inst.Status == StatusTerminated {
+			continue
+		}
+		for _, fs := range inst.FileSystemNames {
+			if fs == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Server) listFileSystems(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance()
+	data := make([]lambda.FileSystem, 0, len(s.fileSystems))
+	for _, fs := range s.fileSystems {
+		fs.IsInUse = s.fileSystemInUse(fs.Name)
+		data = append(data, fs)
+	}
+	writeData(w, data)
+}
+
+func (s *Server) createFileSystem(w http.ResponseWriter, r *http.Request) {
+	var req lambda.CreateFilesystemRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Name == "" || req.RegionName == "" {
+		writeError(w, http.StatusBadRequest, lambda.ErrCodeInvalidParameters, "Name and region are required.", "")
+		return
+	}
+	if !regions[req.RegionName] {
+		writeError(w, http.StatusBadRequest, lambda.ErrCodeInvalidParameters, fmt.Sprintf("Region '%s' does not exist.", req.RegionName), "")
+		return
+	}
+	if s.findFileSystemByName(req.Name) != nil {
+		writeError(w, http.StatusBadRequest, lambda.ErrCodeInvalidParameters, fmt.Sprintf("A file system named '%s' already exists.", req.Name), "")
+		return
+	}
+	fs := lambda.FileSystem{
+		ID:         newID(),
+		Name:       req.Name,
+		MountPoint: "/lambda/nfs/" + req.Name,
+		Created:    s.opts.Now().UTC().Format(time.RFC3339),
+	}
+	fs.Region.Name = req.RegionName
+	s.fileSystems = append(s.fileSystems, fs)
+	writeData(w, fs)
+}
+
+func (s *Server) deleteFileSystem(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance()
+	id := r.PathValue("id")
+	for i, fs := range s.fileSystems {
+		if fs.ID != id {
+			continue
+		}
+		if s.fileSystemInUse(fs.Name) {
+			writeError(w, http.StatusBadRequest, "filesystems/filesystem-in-use", fmt.Sprintf("File system '%s' is mounted by an instance.", fs.Name), "Terminate the instances using it first.")
+			return
+		}
+		s.fileSystems = append(s.fileSystems[:i], s.fileSystems[i+1:]...)
+		writeData(w, map[string]any{"deleted_ids": []string{id}})
+		return
+	}
+	writeError(w, http.StatusNotFound, lambda.ErrCodeObjectDoesNotExist, "Specified file system does not exist.", "")
+}
+
+func (s *Server) hasSSHKey(name string) bool {
+	for _, k := range s.sshKeys {
+		if k.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) listSSHKeys(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := make([]lambda.SSHKey, len(s.sshKeys))
+	copy(data, s.sshKeys)
+	writeData(w, data)
+}
+
+func (s *Server) addSSHKey(w http.ResponseWriter, r *http.Request) {
+	var req lambda.AddSSHKeyRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, lambda.ErrCodeInvalidParameters, "Name is required.", "")
+		return
+	}
+	if s.hasSSHKey(req.Name) {
+		writeError(w, http.StatusBadRequest, lambda.ErrCodeInvalidParameters, fmt.Sprintf("An SSH key named '%s' already exists.", req.Name), "")
+		return
+	}
+	key := lambda.SSHKey{ID: newID(), Name: req.Name, PublicKey: strings.TrimSpace(req.PublicKey)}
+	if key.PublicKey == "" {
+		// Like the real API, generate a key pair and hand back the private half once.
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "global/unknown", err.Error(), "")
+			return
+		}
+		sshPub, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "global/unknown", err.Error(), "")
+			return
+		}
+		block, err := ssh.MarshalPrivateKey(priv, req.Name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "global/unknown", err.Error(), "")
+			return
+		}
+		key.PublicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+		key.PrivateKey = string(pem.EncodeToMemory(block))
+	} else if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.PublicKey)); err != nil {
+		writeError(w, http.StatusBadRequest, lambda.ErrCodeInvalidParameters, "Invalid public key.", "")
+		return
+	}
+	stored := key
+	stored.PrivateKey = ""
+	s.sshKeys = append(s.sshKeys, stored)
+	writeData(w, key)
+}
+
+func (s *Server) deleteSSHKey(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := r.PathValue("id")
+	for i, k := range s.sshKeys {
+		if k.ID == id {
+			s.sshKeys = append(s.sshKeys[:i], s.sshKeys[i+1:]...)
+			writeData(w, map[string]any{})
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, lambda.ErrCodeObjectDoesNotExist, "Specified SSH key does not exist.", "")
+}
+
+func (s *Server) listImages(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeData(w, s.images)
+}
+
+func (s *Server) listFirewallRules(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeData(w, s.firewall)
+}
+
+func (s *Server) setFirewallRules(w http.ResponseWriter, r *http.Request) {
+	var req lambda.SetFirewallRulesRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.firewall = req.Data
+	writeData(w, s.firewall)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, lambda.ErrCodeInvalidParameters, "Invalid request body: "+err.Error(), "")
+		return false
+	}
+	return true
+}
+
+func writeData(w http.ResponseWriter, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
+
+func writeError(w http.ResponseWriter, status int, code, message, suggestion string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	errBody := map[string]string{"code": code, "message": message}
+	if suggestion != "" {
+		errBody["suggestion"] = suggestion
+	}
+	json.NewEncoder(w).Encode(map[string]any{"error": errBody})
+}
@@ -0,0 +1,156 @@
+package fakecloud
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+)
+
+// fakeClock is a manually advanced clock.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestServer(t *testing.T) (*Server, *lambda.Client, *fakeClock) {
+	t.Helper()
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	srv := New(Options{
+		BootDelay:      time.Minute,
+		TerminateDelay: 30 * time.Second,
+		SSHKeyNames:    []string{"laptop"},
+		Now:            clock.Now,
+	})
+	httpSrv := httptest.NewServer(srv)
+	t.Cleanup(httpSrv.Close)
+
+	client, err := lambda.NewClient("test-key", lambda.WithBaseURL(httpSrv.URL+BasePath))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return srv, client, clock
+}
+
+func TestInstanceLifecycle(t *testing.T) {
+	ctx := context.Background()
+	_, client, clock := newTestServer(t)
+
+	if _, err := client.CreateFileSystem(ctx, "team-us-east-1", "us-east-1"); err != nil {
+		t.Fatalf("CreateFileSystem: %v", err)
+	}
+	ids, err := client.Launch(ctx, lambda.LaunchRequest{
+		RegionName:       "us-east-1",
+		InstanceTypeName: "gpu_1x_a10",
+		SSHKeyNames:      []string{"laptop"},
+		FileSystemNames:  []string{"team-us-east-1"},
+		Name:             "demo",
+	})
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+
+	inst, err := client.GetInstance(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("GetInstance: %v", err)
+	}
+	if inst.Status != StatusBooting || inst.IP != "" {
+		t.Errorf("freshly launched instance: want booting without IP, got %s/%q", inst.Status, inst.IP)
+	}
+
+	clock.Advance(time.Minute)
+	inst, _ = client.GetInstance(ctx, ids[0])
+	if inst.Status != StatusActive || inst.IP == "" {
+		t.Errorf("after boot delay: want active with IP, got %s/%q", inst.Status, inst.IP)
+	}
+
+	fileSystems, _ := client.ListFileSystems(ctx)
+	if len(fileSystems) != 1 || !fileSystems[0].IsInUse {
+		t.Errorf("file system should be reported in use: %+v", fileSystems)
+	}
+	if err := client.DeleteFileSystem(ctx, fileSystems[0].ID); err == nil {
+		t.Error("deleting a mounted file system should fail")
+	}
+
+	if _, err := client.Terminate(ctx, ids[0]); err != nil {
+		t.Fatalf("Terminate: %v", err)
+	}
+	clock.Advance(30 * time.Second)
+	inst, _ = client.GetInstance(ctx, ids[0])
+	if inst.Status != StatusTerminated {
+		t.Errorf("after terminate delay: want terminated, got %s", inst.Status)
+	}
+	if err := client.DeleteFileSystem(ctx, fileSystems[0].ID); err != nil {
+		t.Errorf("DeleteFileSystem after terminate: %v", err)
+	}
+}
+
+func TestLaunchCapacity(t *testing.T) {
+	ctx := context.Background()
+	srv, client, _ := newTestServer(t)
+	if err := srv.SetCapacity("gpu_8x_h100_sxm5", "us-west-3", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := client.Launch(ctx, lambda.LaunchRequest{
+		RegionName:       "us-west-3",
+		InstanceTypeName: "gpu_8x_h100_sxm5",
+		SSHKeyNames:      []string{"laptop"},
+		Name:             "big",
+	})
+	if !lambda.IsCapacityError(err) {
+		t.Fatalf("want capacity error, got %v", err)
+	}
+
+	types, err := client.ListInstanceTypes(ctx)
+	if err != nil {
+		t.Fatalf("ListInstanceTypes: %v", err)
+	}
+	if n := len(types["gpu_8x_h100_sxm5"].RegionsWithCapacity); n != 0 {
+		t.Errorf("want no regions with capacity, got %d", n)
+	}
+}
+
+func TestRejectsUnknownRegions(t *testing.T) {
+	ctx := context.Background()
+	_, client, _ := newTestServer(t)
+	for _, region := range []string{"us-*", "mars-north-1"} {
+		_, err := client.CreateFileSystem(ctx, "data", region)
+		if apiErr, ok := lambda.AsAPIError(err); !ok || apiErr.Code != lambda.ErrCodeInvalidParameters {
+			t.Errorf("CreateFileSystem in '%s': %v, want invalid-parameters", region, err)
+		}
+		_, err = client.Launch(ctx, lambda.LaunchRequest{RegionName: region, InstanceTypeName: "gpu_1x_a10", SSHKeyNames: []string{"laptop"}, Name: "box"})
+		if apiErr, ok := lambda.AsAPIError(err); !ok || apiErr.Code != lambda.ErrCodeInvalidParameters {
+			t.Errorf("Launch in '%s': %v, want invalid-parameters", region, err)
+		}
+	}
+	if _, err := client.CreateFileSystem(ctx, "data", "europe-central-1"); err != nil {
+		t.Errorf("CreateFileSystem in a real region: %v", err)
+	}
+}
+
+func TestRejectsMissingAPIKey(t *testing.T) {
+	srv := New(Options{APIKey: "secret"})
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+
+	client, _ := lambda.NewClient("wrong", lambda.WithBaseURL(httpSrv.URL+BasePath))
+	if _, err := client.ListInstances(context.Background()); !lambda.IsAuthError(err) {
+		t.Errorf("want auth error, got %v", err)
+	}
+}
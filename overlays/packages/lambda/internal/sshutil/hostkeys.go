@@ -0,0 +1,252 @@
+package sshutil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/configutil"
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	hostKeyDialTimeout   = 10 * time.Second
+	hostKeyRetryInterval = 5 * time.Second
+)
+
+// knownHostsMu serializes rewrites of the lm-managed known_hosts file, which
+// create updates from one goroutine per launched instance.
+var knownHostsMu sync.Mutex
+
+// PinnedHost is an entry of the lm-managed known_hosts file. The instance
+// it was pinned for is kept in the line's comment.
+type PinnedHost struct {
+	Host         string        `json:"host"`
+	Key          ssh.PublicKey `json:"-"`
+	KeyType      string        `json:"key_type"`
+	Fingerprint  string        `json:"fingerprint"`
+	InstanceID   string        `json:"instance_id,omitempty"`
+	InstanceName string        `json:"instance_name,omitempty"`
+}
+
+// HostKeyChangedError reports a host presenting a key other than the one in
+// known_hosts. Previous is set when the old key was pinned by lm.
+type HostKeyChangedError struct {
+	Host     string
+	File     string
+	Previous *PinnedHost
+	Err      error
+}
+
+func (e *HostKeyChangedError) Error() string {
+	if e.Previous != nil && e.Previous.InstanceID != "" {
+		return fmt.Sprintf("host key of %s changed since it was pinned for instance '%s' (ID: %s): %v", e.Host, e.Previous.InstanceName, e.Previous.InstanceID, e.Err)
+	}
+	return fmt.Sprintf("host key of %s does not match the one in %s: %v", e.Host, e.File, e.Err)
+}
+
+func (e *HostKeyChangedError) Unwrap() error { return e.Err }
+
+// CaptureHostKey waits up to wait for the SSH server of inst to present its
+// host key and pins it in the lm-managed known_hosts file. This is trust on
+// first use: it must only be called for instances lm has just launched.
+func CaptureHostKey(ctx context.Context, inst lambda.Instance, wait time.Duration) (ssh.PublicKey, error) {
+	addr := net.JoinHostPort(inst.IP, "22")
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	for {
+		key, err := lambda.FetchHostKey(ctx, addr, hostKeyDialTimeout)
+		if err == nil {
+			if err := PinHostKey(inst, key); err != nil {
+				return nil, err
+			}
+			return key, nil
+		}
+		log.Debugf("Host key of %s not available yet: %v", inst.IP, err)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no host key from %s within %s: %w", inst.IP, wait, err)
+		case <-time.After(hostKeyRetryInterval):
+		}
+	}
+}
+
+// PinHostKey records key as the only host key of the instance's IP in the
+// lm-managed known_hosts file, replacing earlier entries. Cloud IPs are
+// reused, so an old entry for the same address belongs to a different machine.
+func PinHostKey(inst lambda.Instance, key ssh.PublicKey) error {
+	line := knownhosts.Line([]string{knownhosts.Normalize(inst.IP)}, key)
+	line += fmt.Sprintf(" instance=%s name=%s", inst.ID, inst.Name)
+	return rewriteKnownHosts(inst.IP, line)
+}
+
+// PinnedHosts returns the entries of the lm-managed known_hosts file.
+// Lines it cannot parse are skipped.
+func PinnedHosts() ([]PinnedHost, error) {
+	path, err := configutil.KnownHostsPath()
+	if err != nil {
+		return nil, fmt.Errorf("locating lm known_hosts file: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading known_hosts file '%s': %w", path, err)
+	}
+	var pinned []PinnedHost
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if p, ok := parsePinnedHost(scanner.Text()); ok {
+			pinned = append(pinned, p)
+		}
+	}
+	return pinned, nil
+}
+
+// parsePinnedHost parses a line written by PinHostKey. Lines written by
+// other tools parse too, without the instance.
+func parsePinnedHost(line string) (PinnedHost, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "@") {
+		return PinnedHost{}, false
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.Join(fields[1:3], " ")))
+	if err != nil {
+		return PinnedHost{}, false
+	}
+	p := PinnedHost{
+		Host:        fields[0],
+		Key:         key,
+		KeyType:     key.Type(),
+		Fingerprint: ssh.FingerprintSHA256(key),
+	}
+	comment := strings.Join(fields[3:], " ")
+	if rest, ok := strings.CutPrefix(comment, "instance="); ok {
+		p.InstanceID, p.InstanceName, _ = strings.Cut(rest, " name=")
+	}
+	return p, true
+}
+
+// pinnedHost returns the lm-managed entry for host, if any.
+func pinnedHost(host string) *PinnedHost {
+	pinned, err := PinnedHosts()
+	if err != nil {
+		log.Debugf("Reading pinned host keys: %v", err)
+		return nil
+	}
+	for i := range pinned {
+		if hostsMatch(pinned[i].Host, knownhosts.Normalize(host)) {
+			return &pinned[i]
+		}
+	}
+	return nil
+}
+
+// UnpinHostKey removes host from the lm-managed known_hosts file.
+func UnpinHostKey(host string) error {
+	return rewriteKnownHosts(host, "")
+}
+
+// rewriteKnownHosts drops the entries for host from the lm-managed
+// known_hosts file and appends line unless it is empty.
+func rewriteKnownHosts(host, line string) error {
+	path, err := configutil.KnownHostsPath()
+	if err != nil {
+		return fmt.Errorf("locating lm known_hosts file: %w", err)
+	}
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("reading known_hosts file '%s': %w", path, err)
+	}
+	if line == "" && len(data) == 0 {
+		return nil
+	}
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if !knownHostsLineMatches(scanner.Text(), knownhosts.Normalize(host)) {
+			fmt.Fprintln(&out, scanner.Text())
+		}
+	}
+	if line != "" {
+		fmt.Fprintln(&out, line)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("creating known_hosts directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".known_hosts-*")
+	if err != nil {
+		return fmt.Errorf("writing known_hosts file '%s': %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(out.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing known_hosts file '%s': %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing known_hosts file '%s': %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("writing known_hosts file '%s': %w", path, err)
+	}
+	return nil
+}
+
+// knownHostsLineMatches reports whether a known_hosts line lists host.
+func knownHostsLineMatches(line, host string) bool {
+	fields := strings.Fields(line)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return false
+	}
+	return hostsMatch(fields[0], host)
+}
+
+// hostsMatch reports whether the comma-separated host list of a known_hosts
+// line contains host.
+func hostsMatch(hosts, host string) bool {
+	for _, h := range strings.Split(hosts, ",") {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// knownHostsFiles returns the known_hosts files host keys are checked
+// against: the lm-managed file and ~/.ssh/known_hosts, if they exist.
+func knownHostsFiles() ([]string, error) {
+	managed, err := configutil.KnownHostsPath()
+	if err != nil {
+		return nil, fmt.Errorf("locating lm known_hosts file: %w", err)
+	}
+	user, err := configutil.ExpandPath("~/.ssh/known_hosts")
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand known_hosts path: %w", err)
+	}
+	var files []string
+	for _, f := range []string{managed, user} {
+		if _, err := os.Stat(f); err == nil {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,100 @@
+package sshutil
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestPinHostKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lm", "known_hosts")
+	t.Setenv("LM_KNOWN_HOSTS", path)
+
+	old, current, other := newHostKey(t), newHostKey(t), newHostKey(t)
+	for _, pin := range []struct {
+		inst lambda.Instance
+		key  ssh.PublicKey
+	}{
+		{lambda.Instance{ID: "i-old", Name: "old", IP: "192.0.2.10"}, old},
+		{lambda.Instance{ID: "i-other", Name: "other", IP: "192.0.2.11"}, other},
+		{lambda.Instance{ID: "i-new", Name: "new box", IP: "192.0.2.10"}, current},
+	} {
+		if err := PinHostKey(pin.inst, pin.key); err != nil {
+			t.Fatalf("PinHostKey(%s): %v", pin.inst.Name, err)
+		}
+	}
+
+	pinned, err := PinnedHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pinned) != 2 {
+		t.Fatalf("got %d pinned hosts, want 2: %+v", len(pinned), pinned)
+	}
+	if p := pinned[1]; p.Host != "192.0.2.10" || p.InstanceID != "i-new" || p.InstanceName != "new box" || p.Fingerprint != ssh.FingerprintSHA256(current) {
+		t.Errorf("pinned[1] = %+v", p)
+	}
+
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 22}
+	if err := callback("192.0.2.10:22", addr, current); err != nil {
+		t.Errorf("current key rejected: %v", err)
+	}
+	if err := callback("192.0.2.10:22", addr, old); err == nil {
+		t.Error("replaced key still accepted")
+	}
+
+	if err := UnpinHostKey("192.0.2.10"); err != nil {
+		t.Fatalf("UnpinHostKey: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 || !strings.HasPrefix(lines[0], "192.0.2.11 ") {
+		t.Errorf("after unpin got:\n%s", data)
+	}
+}
+
+func TestKnownHostsLineMatches(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"192.0.2.10 ssh-ed25519 AAAA", true},
+		{"example.com,192.0.2.10 ssh-ed25519 AAAA", true},
+		{"@revoked 192.0.2.10 ssh-ed25519 AAAA", true},
+		{"192.0.2.100 ssh-ed25519 AAAA", false},
+		{"# 192.0.2.10", false},
+		{"", false},
+	}
+	for _, tc := range tests {
+		if got := knownHostsLineMatches(tc.line, "192.0.2.10"); got != tc.want {
+			t.Errorf("knownHostsLineMatches(%q) = %v, want %v", tc.line, got, tc.want)
+		}
+	}
+}
@@ -1,336 +1,153 @@
+// Package sshutil adapts the SSH helpers from pkg/lambda to the CLI: it
+// expands ~ in paths, prompts for key passphrases on the terminal, streams
+// remote output to the local terminal and logs through logrus.
 package sshutil
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/configutil"
+	"github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
 	"golang.org/x/term"
 )
 
-// getSSHKey reads and parses an SSH private key, handling passphrases.
-func getSSHKey(keyPath string) (ssh.Signer, error) {
+// signers caches the keys loaded by LoadSSHKey by path. signersMu is held
+// while a key loads, so concurrent callers wait for one passphrase prompt.
+var (
+	signersMu sync.Mutex
+	signers   = make(map[string]ssh.Signer)
+)
+
+// LoadSSHKey reads and parses an SSH private key, prompting for its
+// passphrase if it is encrypted. Keys are cached for the life of the process,
+// so the passphrase is asked for at most once per key.
+func LoadSSHKey(keyPath string) (ssh.Signer, error) {
 	expandedPath, err := configutil.ExpandPath(keyPath)
 	if err != nil {
 		return nil, fmt.Errorf("expanding SSH key path '%s': %w", keyPath, err)
 	}
-	log.Debugf("Attempting to read SSH private key from: %s", expandedPath)
-	keyBytes, err := os.ReadFile(expandedPath)
-	if err != nil {
-		return nil, fmt.Errorf("reading SSH key file '%s': %w", expandedPath, err)
+	signersMu.Lock()
+	defer signersMu.Unlock()
+	if signer, ok := signers[expandedPath]; ok {
+		return signer, nil
 	}
-	log.Debugf("Read %d bytes from key file.", len(keyBytes))
-
-	signer, err := ssh.ParsePrivateKey(keyBytes)
+	log.Debugf("Attempting to read SSH private key from: %s", expandedPath)
+	signer, err := lambda.LoadSigner(expandedPath, func() ([]byte, error) {
+		log.Infof("SSH key %s seems to be encrypted.", expandedPath)
+		fmt.Fprintf(os.Stderr, "Enter passphrase for key %s: ", expandedPath)
+		bytePassword, err := term.ReadPassword(int(syscall.Stdin))
+		fmt.Fprintln(os.Stderr) // Add newline after password entry
+		return bytePassword, err
+	})
 	if err != nil {
-		log.Debugf("Parsing private key failed initially: %v", err)
-		// Check if it needs a passphrase
-		var passphraseMissingErr *ssh.PassphraseMissingError
-		if errors.As(err, &passphraseMissingErr) { // Use errors.As for type checking
-			log.Infof("SSH key %s seems to be encrypted.", expandedPath)
-			fmt.Printf("Enter passphrase for key %s: ", expandedPath)
-			bytePassword, err := term.ReadPassword(int(syscall.Stdin))
-			if err != nil {
-				return nil, fmt.Errorf("reading passphrase: %w", err)
-			}
-			fmt.Println() // Add newline after password entry
-			log.Debug("Attempting to parse key with passphrase.")
-			signer, err = ssh.ParsePrivateKeyWithPassphrase(keyBytes, bytePassword)
-			if err != nil {
-				return nil, fmt.Errorf("parsing private key with passphrase: %w", err)
-			}
-			log.Debug("Successfully parsed key with passphrase.")
-		} else {
-			return nil, fmt.Errorf("parsing private key '%s': %w", expandedPath, err)
-		}
-	} else {
-		log.Debugf("Successfully parsed unencrypted private key.")
+		return nil, err
 	}
 
 	// Log the public key fingerprint being used
-	if signer != nil {
-		pubKey := signer.PublicKey()
-		fingerprint := ssh.FingerprintSHA256(pubKey)
-		log.Debugf("Using public key %s with fingerprint: %s", pubKey.Type(), fingerprint)
-	}
-
+	pubKey := signer.PublicKey()
+	fingerprint := ssh.FingerprintSHA256(pubKey)
+	log.Debugf("Using public key %s with fingerprint: %s", pubKey.Type(), fingerprint)
+	signers[expandedPath] = signer
 	return signer, nil
 }
 
-// RunRemoteCommand executes a command on the remote host via SSH.
-func RunRemoteCommand(client *ssh.Client, command string) error {
-	session, err := client.NewSession()
-	if err != nil {
-		return fmt.Errorf("failed to create SSH session: %w", err)
-	}
-	defer session.Close()
-
-	// Pipe remote stdout and stderr to local stdout/stderr
-	session.Stdout = os.Stdout
-	session.Stderr = os.Stderr
-
+// RunRemoteCommand executes a command on the remote host via SSH, piping its
+// output to the local stdout and stderr.
+// The remote command is signalled and the session closed if ctx is cancelled.
+func RunRemoteCommand(ctx context.Context, client *ssh.Client, command string) error {
 	log.Debugf("Running remote command: %s", command)
-	err = session.Run(command) // Use Run for non-interactive commands
-	if err != nil {
-		// Check if it's an ExitError to potentially get more details
-		var exitError *ssh.ExitError
-		if errors.As(err, &exitError) {
-			return fmt.Errorf("remote command '%s' failed with exit status %d: %w", command, exitError.ExitStatus(), err)
-		}
-		return fmt.Errorf("failed to run remote command '%s': %w", command, err)
-	}
-	return nil
+	return lambda.RunCommand(ctx, client, command, os.Stdout, os.Stderr)
 }
 
-// copySingleFileInternal handles the SCP process for a single file.
-// expandedLocalPath must be an existing file. localFileInfo is its os.FileInfo.
-// remotePath is the full target path for the file on the remote server.
-func copySingleFileInternal(client *ssh.Client, expandedLocalPath string, remotePath string, localFileInfo os.FileInfo) error {
-	if localFileInfo.IsDir() {
-		// This function should not be called for directories directly by external callers.
-		return fmt.Errorf("copySingleFileInternal called with a directory: '%s', this should be handled by directory-specific copy logic", expandedLocalPath)
-	}
-
-	fileBytes, err := os.ReadFile(expandedLocalPath)
-	if err != nil {
-		return fmt.Errorf("reading local file '%s': %w", expandedLocalPath, err)
-	}
-
-	content := fileBytes
-	perms := fmt.Sprintf("%04o", localFileInfo.Mode().Perm())
-	filename := filepath.Base(remotePath)
-	directory := filepath.Dir(remotePath)
-
-	// Ensure remote target directory for the file exists
-	if directory != "." && directory != "/" { // Avoid "mkdir -p ." or "mkdir -p /"
-		mkdirCmd := fmt.Sprintf("mkdir -p %s", directory)
-		if err := RunRemoteCommand(client, mkdirCmd); err != nil {
-			log.Warnf("Failed to ensure remote directory %s exists for file copy (might be okay): %v", directory, err)
-		}
-	}
-
-	session, err := client.NewSession()
-	if err != nil {
-		return fmt.Errorf("failed to create SSH session for file copy: %w", err)
-	}
-	defer session.Close()
-
-	stdin, err := session.StdinPipe()
-	if err != nil {
-		return fmt.Errorf("failed to get stdin pipe: %w", err)
-	}
-
-	go func() {
-		defer stdin.Close()
-		fmt.Fprintf(stdin, "C%s %d %s\n", perms, len(content), filename)
-		stdin.Write([]byte(content))
-		fmt.Fprint(stdin, "\x00")
-	}()
-
-	remoteCmd := fmt.Sprintf("scp -qt %s", directory)
-	log.Debugf("Copying local file '%s' (%d bytes, perm: %s) to remote '%s' via `scp`", expandedLocalPath, len(content), perms, remotePath)
-
-	var stderrBuf bytes.Buffer
-	session.Stderr = &stderrBuf
-
-	err = session.Run(remoteCmd)
-	if err != nil {
-		stderrStr := strings.TrimSpace(stderrBuf.String())
-		if stderrStr != "" {
-			return fmt.Errorf("failed to run remote scp command '%s' for '%s': %w. Stderr: %s", remoteCmd, remotePath, err, stderrStr)
-		}
-		return fmt.Errorf("failed to run remote scp command '%s' for '%s': %w", remoteCmd, remotePath, err)
-	}
-
-	log.Debugf("Successfully copied '%s' to '%s'", expandedLocalPath, remotePath)
-	return nil
+// StreamRemoteCommand executes a command on the remote host via SSH, writing
+// its output to stdout and stderr. It is RunRemoteCommand for callers that
+// run commands on several hosts at once and need to tell the output apart.
+func StreamRemoteCommand(ctx context.Context, client *ssh.Client, command string, stdout, stderr io.Writer) error {
+	log.Debugf("Running remote command: %s", command)
+	return lambda.RunCommand(ctx, client, command, stdout, stderr)
 }
 
 // CopyFileToRemote copies a single local file to the remote host.
 // remoteFilePath is the full path where the file should be saved on the remote.
-func CopyFileToRemote(client *ssh.Client, localFilePath, remoteFilePath string) error {
+// A missing local file is logged and skipped.
+func CopyFileToRemote(ctx context.Context, client *ssh.Client, localFilePath, remoteFilePath string) error {
 	expandedLocalPath, err := configutil.ExpandPath(localFilePath)
 	if err != nil {
 		return fmt.Errorf("expanding local file path '%s': %w", localFilePath, err)
 	}
-
-	fileInfo, err := os.Stat(expandedLocalPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			log.Warnf("Local file '%s' does not exist, skipping copy.", expandedLocalPath)
-			return nil // Not a fatal error if the source file doesn't exist
-		}
-		return fmt.Errorf("stat local file '%s': %w", expandedLocalPath, err)
+	if _, err := os.Stat(expandedLocalPath); os.IsNotExist(err) {
+		log.Warnf("Local file '%s' does not exist, skipping copy.", expandedLocalPath)
+		return nil // Not a fatal error if the source file doesn't exist
 	}
 
-	if fileInfo.IsDir() {
-		return fmt.Errorf("local path '%s' is a directory, expected a file. Use CopyDirToRemote for directories", expandedLocalPath)
+	log.Debugf("Copying local file '%s' to remote '%s' via `scp`", expandedLocalPath, remoteFilePath)
+	if err := lambda.CopyFile(ctx, client, expandedLocalPath, remoteFilePath); err != nil {
+		return err
 	}
-
-	return copySingleFileInternal(client, expandedLocalPath, remoteFilePath, fileInfo)
+	log.Debugf("Successfully copied '%s' to '%s'", expandedLocalPath, remoteFilePath)
+	return nil
 }
 
 // CopyDirToRemote copies the contents of a local directory to a specified remote directory.
 // remoteDestDirPath is the path on the remote server where the contents of localDirPath will be placed.
-func CopyDirToRemote(client *ssh.Client, localDirPath, remoteDestDirPath string) error {
+// A missing local directory is logged and skipped.
+func CopyDirToRemote(ctx context.Context, client *ssh.Client, localDirPath, remoteDestDirPath string) error {
 	expandedLocalDirPath, err := configutil.ExpandPath(localDirPath)
 	if err != nil {
 		return fmt.Errorf("expanding local directory path '%s': %w", localDirPath, err)
 	}
-
-	dirInfo, err := os.Stat(expandedLocalDirPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			log.Warnf("Local directory '%s' does not exist, skipping copy.", expandedLocalDirPath)
-			return nil
-		}
-		return fmt.Errorf("stat local directory '%s': %w", expandedLocalDirPath, err)
-	}
-
-	if !dirInfo.IsDir() {
-		return fmt.Errorf("local path '%s' is not a directory, expected a directory. Use CopyFileToRemote for files", expandedLocalDirPath)
+	if _, err := os.Stat(expandedLocalDirPath); os.IsNotExist(err) {
+		log.Warnf("Local directory '%s' does not exist, skipping copy.", expandedLocalDirPath)
+		return nil
 	}
 
 	log.Debugf("Copying local directory '%s' contents to remote directory '%s'", expandedLocalDirPath, remoteDestDirPath)
-
-	// Ensure the base remote destination directory exists.
-	err = RunRemoteCommand(client, fmt.Sprintf("mkdir -p %s", remoteDestDirPath))
-	if err != nil {
-		return fmt.Errorf("failed to create base remote directory '%s': %w", remoteDestDirPath, err)
-	}
-
-	return filepath.WalkDir(expandedLocalDirPath, func(currentLocalItemPath string, d os.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return fmt.Errorf("error walking local path '%s': %w", currentLocalItemPath, walkErr)
-		}
-
-		relativePath, err := filepath.Rel(expandedLocalDirPath, currentLocalItemPath)
-		if err != nil {
-			return fmt.Errorf("failed to get relative path for '%s' from base '%s': %w", currentLocalItemPath, expandedLocalDirPath, err)
-		}
-
-		currentRemoteItemPath := filepath.Join(remoteDestDirPath, relativePath)
-
-		if d.IsDir() {
-			// If it's the root of the walk (relativePath is "."), it's already created by mkdir -p above.
-			// For other subdirectories, create them.
-			if relativePath != "." {
-				log.Debugf("Creating remote directory: %s", currentRemoteItemPath)
-				mkDirCmd := fmt.Sprintf("mkdir -p %s", currentRemoteItemPath)
-				if err := RunRemoteCommand(client, mkDirCmd); err != nil {
-					// Log warning but continue, as it might exist or not be critical for subsequent file copies
-					log.Warnf("Failed to create remote subdirectory %s (might be okay): %v", currentRemoteItemPath, err)
-				}
-			}
-		} else { // It's a file
-			itemInfo, statErr := d.Info()
-			if statErr != nil {
-				return fmt.Errorf("failed to get FileInfo for '%s': %w", currentLocalItemPath, statErr)
-			}
-			log.Debugf("Copying file %s to %s", currentLocalItemPath, currentRemoteItemPath)
-			copyErr := copySingleFileInternal(client, currentLocalItemPath, currentRemoteItemPath, itemInfo)
-			if copyErr != nil {
-				return fmt.Errorf("failed to copy file '%s' to '%s': %w", currentLocalItemPath, currentRemoteItemPath, copyErr)
-			}
-		}
-		return nil
-	})
+	return lambda.CopyDir(ctx, client, expandedLocalDirPath, remoteDestDirPath)
 }
 
 // CopyContentToRemote copies byte content to a remote file using SCP protocol over SSH.
-func CopyContentToRemote(client *ssh.Client, content []byte, remotePath string, perms string) error {
-	filename := filepath.Base(remotePath)
-	directory := filepath.Dir(remotePath)
-
-	// Validate perms format
-	if len(perms) != 4 {
+// perms is an octal permission string such as "0755".
+func CopyContentToRemote(ctx context.Context, client *ssh.Client, content []byte, remotePath string, perms string) error {
+	mode, err := strconv.ParseUint(perms, 8, 32)
+	if len(perms) != 4 || err != nil {
 		log.Warnf("Invalid permissions format '%s' provided for CopyContentToRemote, using default '0644'", perms)
-		perms = "0644"
+		mode = 0o644
 	}
 
-	// Ensure remote directory exists (optional, add if needed)
-	mkdirCmd := fmt.Sprintf("mkdir -p %s", directory)
-	if err := RunRemoteCommand(client, mkdirCmd); err != nil {
-		log.Warnf("Failed to ensure remote directory %s exists (might be okay): %v", directory, err)
-	}
-
-	session, err := client.NewSession()
-	if err != nil {
-		return fmt.Errorf("failed to create SSH session for content copy: %w", err)
-	}
-	defer session.Close()
-
-	stdin, err := session.StdinPipe()
-	if err != nil {
-		return fmt.Errorf("failed to get stdin pipe: %w", err)
-	}
-
-	go func() {
-		defer stdin.Close()
-		fmt.Fprintf(stdin, "C%s %d %s\n", perms, len(content), filename)
-		stdin.Write([]byte(content))
-		fmt.Fprint(stdin, "\x00")
-	}()
-
-	remoteCmd := fmt.Sprintf("scp -qt %s", directory)
 	log.Infof("Copying %d bytes of content to remote '%s' via `scp`", len(content), remotePath)
-
-	// Capture stderr for better error reporting
-	var stderrBuf bytes.Buffer
-	session.Stderr = &stderrBuf
-
-	err = session.Run(remoteCmd)
-	if err != nil {
-		stderrStr := strings.TrimSpace(stderrBuf.String())
-		if stderrStr != "" {
-			return fmt.Errorf("failed to run remote scp command '%s' for '%s': %w. Stderr: %s", remoteCmd, remotePath, err, stderrStr)
-		}
-		return fmt.Errorf("failed to run remote scp command '%s' for '%s': %w", remoteCmd, remotePath, err)
+	if err := lambda.CopyContent(ctx, client, content, remotePath, os.FileMode(mode)); err != nil {
+		return err
 	}
 	log.Debugf("Successfully copied content to '%s'", remotePath)
 	return nil
 }
 
-// EstablishSSHConnection dials and configures an SSH client connection.
-func EstablishSSHConnection(ipAddress, sshKeyPath, user, sshKeyName string, useKnownHosts bool) (*ssh.Client, error) {
+// EstablishSSHConnection dials and configures an SSH client connection,
+// checking the host key against known_hosts. Cancelling ctx aborts the dial
+// and handshake; it does not affect the returned client.
+func EstablishSSHConnection(ctx context.Context, ipAddress, sshKeyPath, user, sshKeyName string) (*ssh.Client, error) {
 	// Get SSH key signer using the local function
-	signer, err := getSSHKey(sshKeyPath)
+	signer, err := LoadSSHKey(sshKeyPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get SSH key from '%s': %w", sshKeyPath, err)
 	}
 
-	// Configure HostKeyCallback
-	var hostKeyCallback ssh.HostKeyCallback
-	if useKnownHosts {
-		knownHostsPath, err := configutil.ExpandPath("~/.ssh/known_hosts")
-		if err != nil {
-			return nil, fmt.Errorf("failed to expand known_hosts path: %w", err)
-		}
-		callback, err := knownhosts.New(knownHostsPath)
-		if err != nil {
-			if os.IsNotExist(err) {
-				log.Warnf("known_hosts file '%s' not found. Allowing first connection (insecure). Consider connecting manually once.", knownHostsPath)
-				// Allow first connection if known_hosts doesn't exist - less secure
-				hostKeyCallback = ssh.InsecureIgnoreHostKey()
-			} else {
-				return nil, fmt.Errorf("failed to read known_hosts file '%s': %w", knownHostsPath, err)
-			}
-		} else {
-			log.Debugf("Using known_hosts file: %s", knownHostsPath)
-			hostKeyCallback = callback
-		}
-	} else {
-		log.Warn("Host key checking is disabled (InsecureIgnoreHostKey).")
-		hostKeyCallback = ssh.InsecureIgnoreHostKey() // Use insecure for setup or when explicitly disabled
+	hostKeyCallback, knownHostsPaths, err := knownHostsCallback(ipAddress, user)
+	if err != nil {
+		return nil, err
 	}
 
 	// Configure SSH client
@@ -347,18 +164,24 @@ func EstablishSSHConnection(ipAddress, sshKeyPath, user, sshKeyName string, useK
 	// Dial the SSH server
 	serverAddr := fmt.Sprintf("%s:22", ipAddress)
 	log.Debugf("Dialing SSH server %s", serverAddr)
-	sshClient, err := ssh.Dial("tcp", serverAddr, sshConfig)
+	sshClient, err := lambda.DialSSH(ctx, serverAddr, sshConfig)
 	if err != nil {
-		// Specific error handling for knownhosts missing key
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("SSH connection to %s cancelled: %w", serverAddr, err)
+		}
+		// Specific error handling for unknown or mismatched host keys
 		var keyErr *knownhosts.KeyError
-		if useKnownHosts && errors.As(err, &keyErr) && len(keyErr.Want) > 0 {
-			knownHostsPath, _ := configutil.ExpandPath("~/.ssh/known_hosts") // Ignore error as we checked earlier
-			log.Errorf("SSH host key verification failed for %s.", ipAddress)
-			log.Errorf("The host key presented by the server is not in '%s'.", knownHostsPath)
-			log.Infof("Server offered key types: %v", keyErr.Want) // Log offered key types
-			log.Infof("Please connect manually using 'ssh %s@%s' once to add the host key, then try again.", user, ipAddress)
-			// Return a distinct error message
-			return nil, fmt.Errorf("host key verification failed: key mismatch or not found in known_hosts (%w)", err)
+		if errors.As(err, &keyErr) {
+			if len(keyErr.Want) > 0 {
+				changed := &HostKeyChangedError{Host: ipAddress, File: keyErr.Want[0].Filename, Err: err}
+				if managed, _ := configutil.KnownHostsPath(); changed.File == managed {
+					changed.Previous = pinnedHost(ipAddress)
+				}
+				return nil, changed
+			}
+			log.Errorf("No host key known for %s in %s.", ipAddress, strings.Join(knownHostsPaths, ", "))
+			log.Infof("lm pins the host keys of instances it creates. For other instances connect once with 'ssh %s@%s' to add the host key, then try again.", user, ipAddress)
+			return nil, fmt.Errorf("host key verification failed: host key not found in known_hosts (%w)", err)
 		}
 		// Handle other potential dial errors (timeout, connection refused, auth failed etc.)
 		if strings.Contains(err.Error(), "unable to authenticate") {
@@ -375,3 +198,42 @@ func EstablishSSHConnection(ipAddress, sshKeyPath, user, sshKeyName string, useK
 	log.Infof("SSH connection established successfully to %s@%s", user, ipAddress)
 	return sshClient, nil
 }
+
+// ProbeSSH logs in to ipAddress as user with signer, checking the host key
+// against known_hosts like EstablishSSHConnection, and closes the connection
+// again. Errors are returned unlogged so that callers can retry quietly.
+func ProbeSSH(ctx context.Context, ipAddress string, signer ssh.Signer, user string, timeout time.Duration) error {
+	hostKeyCallback, _, err := knownHostsCallback(ipAddress, user)
+	if err != nil {
+		return err
+	}
+	sshClient, err := lambda.DialSSH(ctx, net.JoinHostPort(ipAddress, "22"), &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         timeout,
+	})
+	if err != nil {
+		return err
+	}
+	return sshClient.Close()
+}
+
+// knownHostsCallback checks host keys against the host keys lm pinned when it
+// launched the instance and ~/.ssh/known_hosts; unknown hosts are rejected.
+// It also returns the files it reads.
+func knownHostsCallback(ipAddress, user string) (ssh.HostKeyCallback, []string, error) {
+	paths, err := knownHostsFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(paths) == 0 {
+		return nil, nil, fmt.Errorf("no host key known for %s: no known_hosts file exists. lm pins the host keys of instances it creates; for other instances run 'ssh %s@%s' once", ipAddress, user, ipAddress)
+	}
+	callback, err := knownhosts.New(paths...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read known_hosts files %s: %w", strings.Join(paths, ", "), err)
+	}
+	log.Debugf("Using known_hosts files: %s", strings.Join(paths, ", "))
+	return callback, paths, nil
+}
@@ -0,0 +1,369 @@
+// Package state keeps the local history of the instances lm manages. The
+// Lambda API reports neither when an instance was launched nor by whom, and
+// nothing remote records whether it was set up, so lm appends these facts to
+// an event log under $XDG_STATE_HOME/lm as it acts. Several lm processes may
+// write to the log at once; appends and reads take a file lock, and Compact
+// replaces the log under the same lock.
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// Event kinds.
+const (
+	Launched   = "launched"
+	Setup      = "setup"
+	Restarted  = "restarted"
+	Terminated = "terminated"
+	// TTL sets or, with a zero ExpiresAt, clears when the instance expires.
+	TTL = "ttl"
+	// Sampled records how busy the instance was when 'lm reap --idle'
+	// looked.
+	Sampled = "sampled"
+	// Protected sets or clears the instance's exemption from idle reaping.
+	Protected = "protected"
+	// BudgetOverride records a launch let through by --override-budget.
+	BudgetOverride = "budget_override"
+	// ClusterNode records that 'lm cluster create' launched the instance
+	// as a node of a cluster.
+	ClusterNode = "cluster_node"
+)
+
+// Event is one line of the event log. Fields other than Time, Kind and
+// InstanceID are only set for the kinds they describe.
+type Event struct {
+	Time         time.Time `json:"time"`
+	Kind         string    `json:"kind"`
+	InstanceID   string    `json:"instance_id"`
+	InstanceName string    `json:"instance_name,omitempty"`
+
+	// Launched
+	InstanceType      string `json:"instance_type,omitempty"`
+	Region            string `json:"region,omitempty"`
+	PriceCentsPerHour int    `json:"price_cents_per_hour,omitempty"`
+	User              string `json:"user,omitempty"`
+	Profile           string `json:"profile,omitempty"`
+	APIURL            string `json:"api_url,omitempty"`
+	Template          string `json:"template,omitempty"`
+
+	// Setup: the error the run ended with, empty if it succeeded.
+	Error string `json:"error,omitempty"`
+
+	// TTL
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+
+	// Sampled: the utilization and memory of the busiest GPU, the login
+	// sessions, the 1-minute load average, and since when the instance has
+	// been idle, zero if it was busy.
+	GPUUtilization int       `json:"gpu_utilization,omitempty"`
+	GPUMemoryMiB   int       `json:"gpu_memory_mib,omitempty"`
+	Sessions       int       `json:"sessions,omitempty"`
+	Load           float64   `json:"load,omitempty"`
+	IdleSince      time.Time `json:"idle_since,omitzero"`
+
+	// Protected
+	Protected bool `json:"protected,omitempty"`
+
+	// BudgetOverride: the reason given and the caps the launch broke.
+	Reason     string   `json:"reason,omitempty"`
+	Violations []string `json:"violations,omitempty"`
+
+	// ClusterNode
+	Cluster     string `json:"cluster,omitempty"`
+	ClusterRank int    `json:"cluster_rank,omitempty"`
+}
+
+// SetupRun is one run of 'lm setup' against an instance.
+type SetupRun struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+}
+
+// Instance is what the event log knows about one instance.
+type Instance struct {
+	ID                string      `json:"instance_id"`
+	Name              string      `json:"instance_name"`
+	InstanceType      string      `json:"instance_type,omitempty"`
+	Region            string      `json:"region,omitempty"`
+	PriceCentsPerHour int         `json:"price_cents_per_hour,omitempty"`
+	User              string      `json:"user,omitempty"`
+	Profile           string      `json:"profile,omitempty"`
+	APIURL            string      `json:"api_url,omitempty"`
+	Template          string      `json:"template,omitempty"`
+	LaunchedAt        time.Time   `json:"launched_at,omitzero"`
+	TerminatedAt      time.Time   `json:"terminated_at,omitzero"`
+	ExpiresAt         time.Time   `json:"expires_at,omitzero"`
+	Restarts          []time.Time `json:"restarts,omitempty"`
+	Setups            []SetupRun  `json:"setups,omitempty"`
+	// SampledAt is the last time 'lm reap --idle' sampled the instance, and
+	// IdleSince when the run of idle samples up to then began.
+	SampledAt time.Time `json:"sampled_at,omitzero"`
+	IdleSince time.Time `json:"idle_since,omitzero"`
+	// Protected exempts the instance from idle reaping.
+	Protected bool `json:"protected,omitempty"`
+	// BudgetOverride is the reason the instance was launched over budget.
+	BudgetOverride string `json:"budget_override,omitempty"`
+	// Cluster is the cluster the instance is node ClusterRank of, if any.
+	Cluster     string `json:"cluster,omitempty"`
+	ClusterRank int    `json:"cluster_rank,omitempty"`
+}
+
+// Uptime returns how long the instance has been running at now, or 0 if its
+// launch was not recorded.
+func (i *Instance) Uptime(now time.Time) time.Duration {
+	if i.LaunchedAt.IsZero() {
+		return 0
+	}
+	end := now
+	if !i.TerminatedAt.IsZero() && i.TerminatedAt.Before(now) {
+		end = i.TerminatedAt
+	}
+	if end.Before(i.LaunchedAt) {
+		return 0
+	}
+	return end.Sub(i.LaunchedAt)
+}
+
+// CostCents returns what the instance has cost up to now at its launch
+// price, in cents.
+func (i *Instance) CostCents(now time.Time) float64 {
+	return i.Uptime(now).Hours() * float64(i.PriceCentsPerHour)
+}
+
+// Expired reports whether the instance is running past its TTL at now.
+func (i *Instance) Expired(now time.Time) bool {
+	return !i.ExpiresAt.IsZero() && !now.Before(i.ExpiresAt) && i.TerminatedAt.IsZero()
+}
+
+// SetUp reports whether a setup run succeeded.
+func (i *Instance) SetUp() bool {
+	for _, run := range i.Setups {
+		if run.Error == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Store is the event log in a state directory.
+type Store struct {
+	path string
+}
+
+// Open returns the store in dir. The directory is created on first write.
+func Open(dir string) *Store {
+	return &Store{path: filepath.Join(dir, "events.jsonl")}
+}
+
+// Path returns the event log file.
+func (s *Store) Path() string { return s.path }
+
+// Append adds events to the log, stamping those without a time.
+func (s *Store) Append(events ...Event) error {
+	var buf bytes.Buffer
+	now := time.Now().UTC()
+	for _, e := range events {
+		if e.Time.IsZero() {
+			e.Time = now
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("encoding state event: %w", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("creating state directory: %w", err)
+	}
+	f, err := s.lock(os.O_WRONLY|os.O_APPEND|os.O_CREATE, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("writing state file '%s': %w", s.path, err)
+	}
+	return nil
+}
+
+// lock opens the log with flag and locks it with how. Compact may replace the
+// log while lock waits, so it retries until it holds the current file.
+func (s *Store) lock(flag, how int) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(s.path, flag, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("opening state file '%s': %w", s.path, err)
+		}
+		if err := syscall.Flock(int(f.Fd()), how); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("locking state file '%s': %w", s.path, err)
+		}
+		locked, errL := f.Stat()
+		current, errC := os.Stat(s.path)
+		if errL == nil && errC == nil && os.SameFile(locked, current) {
+			return f, nil
+		}
+		f.Close()
+		if errL != nil {
+			return nil, fmt.Errorf("reading state file '%s': %w", s.path, errL)
+		}
+	}
+}
+
+// Events returns the events in the log, oldest first. Lines that do not
+// parse, such as one cut short by a crash, are skipped.
+func (s *Store) Events() ([]Event, error) {
+	f, err := s.lock(os.O_RDONLY, syscall.LOCK_SH)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return s.read(f)
+}
+
+// read parses the events in f, oldest first.
+func (s *Store) read(f *os.File) ([]Event, error) {
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.InstanceID == "" {
+			continue
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading state file '%s': %w", s.path, err)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events, nil
+}
+
+// Compact rewrites the log without the events Compacted drops.
+func (s *Store) Compact() error {
+	f, err := s.lock(os.O_RDONLY, syscall.LOCK_EX)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	events, err := s.read(f)
+	if err != nil {
+		return err
+	}
+	kept := Compacted(events)
+	if len(kept) == len(events) {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, e := range kept {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("encoding state event: %w", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("writing state file '%s': %w", tmp, err)
+	}
+	// Rename while still holding the lock on the old file, so that waiting
+	// appends notice the replacement and retry on the new one.
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("replacing state file '%s': %w", s.path, err)
+	}
+	return nil
+}
+
+// Compacted returns events, which must be oldest first, without the Sampled
+// events a later sample of the same instance supersedes. Idle sampling adds
+// one per instance and pass, and Fold only keeps the latest.
+func Compacted(events []Event) []Event {
+	last := make(map[string]int)
+	for i, e := range events {
+		if e.Kind == Sampled {
+			last[e.InstanceID] = i
+		}
+	}
+	kept := make([]Event, 0, len(events))
+	for i, e := range events {
+		if e.Kind != Sampled || last[e.InstanceID] == i {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// Instances folds the event log into one record per instance ID.
+func (s *Store) Instances() (map[string]*Instance, error) {
+	events, err := s.Events()
+	if err != nil {
+		return nil, err
+	}
+	return Fold(events), nil
+}
+
+// Fold builds one record per instance ID from events, which must be
+// oldest first.
+func Fold(events []Event) map[string]*Instance {
+	instances := make(map[string]*Instance)
+	for _, e := range events {
+		inst, ok := instances[e.InstanceID]
+		if !ok {
+			inst = &Instance{ID: e.InstanceID}
+			instances[e.InstanceID] = inst
+		}
+		if e.InstanceName != "" {
+			inst.Name = e.InstanceName
+		}
+		switch e.Kind {
+		case Launched:
+			inst.LaunchedAt = e.Time
+			inst.InstanceType = e.InstanceType
+			inst.Region = e.Region
+			inst.PriceCentsPerHour = e.PriceCentsPerHour
+			inst.User = e.User
+			inst.Profile = e.Profile
+			inst.APIURL = e.APIURL
+			inst.Template = e.Template
+		case Setup:
+			inst.Setups = append(inst.Setups, SetupRun{Time: e.Time, Error: e.Error})
+		case TTL:
+			inst.ExpiresAt = e.ExpiresAt
+		case Sampled:
+			inst.SampledAt = e.Time
+			inst.IdleSince = e.IdleSince
+		case Protected:
+			inst.Protected = e.Protected
+		case BudgetOverride:
+			inst.BudgetOverride = e.Reason
+		case ClusterNode:
+			inst.Cluster, inst.ClusterRank = e.Cluster, e.ClusterRank
+		case Restarted:
+			inst.Restarts = append(inst.Restarts, e.Time)
+		case Terminated:
+			if inst.TerminatedAt.IsZero() {
+				inst.TerminatedAt = e.Time
+			}
+		}
+	}
+	return instances
+}
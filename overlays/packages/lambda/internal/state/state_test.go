@@ -0,0 +1,173 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStoreConcurrentAppends(t *testing.T) {
+	store := Open(t.TempDir())
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := fmt.Sprintf("i-%d", i)
+			err := store.Append(
+				Event{Kind: Launched, InstanceID: id, InstanceName: id, PriceCentsPerHour: 75},
+				Event{Kind: Setup, InstanceID: id},
+			)
+			if err != nil {
+				t.Errorf("Append: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	instances, err := store.Instances()
+	if err != nil {
+		t.Fatalf("Instances: %v", err)
+	}
+	if len(instances) != 20 {
+		t.Fatalf("got %d instances, want 20", len(instances))
+	}
+	for id, inst := range instances {
+		if inst.LaunchedAt.IsZero() || !inst.SetUp() || inst.Name != id {
+			t.Errorf("instance %s: incomplete record %+v", id, inst)
+		}
+	}
+}
+
+func TestStoreSkipsTruncatedLines(t *testing.T) {
+	store := Open(t.TempDir())
+	if err := store.Append(Event{Kind: Launched, InstanceID: "i-1"}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	f, err := os.OpenFile(store.Path(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2026-10-01T00:00:00Z","kind":"lau`)
+	f.Close()
+
+	events, err := store.Events()
+	if err != nil {
+		t.Fatalf("Events: %v", err)
+	}
+	if len(events) != 1 || events[0].InstanceID != "i-1" {
+		t.Errorf("got %+v, want only the complete event", events)
+	}
+}
+
+func TestStoreCompact(t *testing.T) {
+	store := Open(t.TempDir())
+	start := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	events := []Event{
+		{Time: start, Kind: Launched, InstanceID: "i-1", InstanceName: "train"},
+		{Time: start.Add(time.Minute), Kind: Sampled, InstanceID: "i-1", InstanceName: "train", IdleSince: start},
+		{Time: start.Add(time.Minute), Kind: Sampled, InstanceID: "i-2", InstanceName: "other"},
+		{Time: start.Add(2 * time.Minute), Kind: Sampled, InstanceID: "i-1", InstanceName: "train", IdleSince: start},
+		{Time: start.Add(3 * time.Minute), Kind: Setup, InstanceID: "i-1"},
+		{Time: start.Add(4 * time.Minute), Kind: Sampled, InstanceID: "i-1", InstanceName: "train", GPUUtilization: 90},
+	}
+	if err := store.Append(events...); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	before, err := store.Instances()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := store.Compact(); err != nil {
+				t.Errorf("Compact: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := store.Append(Event{Time: start.Add(time.Hour), Kind: Setup, InstanceID: fmt.Sprintf("j-%d", i)}); err != nil {
+				t.Errorf("Append: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := store.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+
+	got, err := store.Events()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4+10 {
+		t.Fatalf("got %d events after compaction, want the 4 that matter and the 10 concurrent appends: %+v", len(got), got)
+	}
+	after := Fold(got)
+	for _, id := range []string{"i-1", "i-2"} {
+		b, a := before[id], after[id]
+		if a.Name != b.Name || !a.SampledAt.Equal(b.SampledAt) || !a.IdleSince.Equal(b.IdleSince) || len(a.Setups) != len(b.Setups) || !a.LaunchedAt.Equal(b.LaunchedAt) {
+			t.Errorf("%s: record %+v after compaction, want %+v", id, a, b)
+		}
+	}
+}
+
+func TestFold(t *testing.T) {
+	launch := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	events := []Event{
+		{Time: launch, Kind: Launched, InstanceID: "i-1", InstanceName: "train", PriceCentsPerHour: 200, User: "alice", Profile: "work", APIURL: "https://example.test/api/v1", Template: "big"},
+		{Time: launch, Kind: BudgetOverride, InstanceID: "i-1", Reason: "paper deadline", Violations: []string{"over the $50.00/h cap"}},
+		{Time: launch.Add(10 * time.Minute), Kind: Setup, InstanceID: "i-1", Error: "bw locked"},
+		{Time: launch.Add(20 * time.Minute), Kind: Setup, InstanceID: "i-1"},
+		{Time: launch.Add(time.Hour), Kind: Restarted, InstanceID: "i-1"},
+		{Time: launch.Add(3 * time.Hour), Kind: Terminated, InstanceID: "i-1"},
+		{Time: launch.Add(4 * time.Hour), Kind: Terminated, InstanceID: "i-1"},
+		{Time: launch, Kind: Setup, InstanceID: "i-2", Error: "no route to host"},
+		{Time: launch, Kind: ClusterNode, InstanceID: "i-2", Cluster: "train", ClusterRank: 3},
+		{Time: launch, Kind: Launched, InstanceID: "i-3"},
+		{Time: launch, Kind: TTL, InstanceID: "i-3", ExpiresAt: launch.Add(time.Hour)},
+		{Time: launch.Add(time.Hour), Kind: TTL, InstanceID: "i-3", ExpiresAt: launch.Add(2 * time.Hour)},
+		{Time: launch.Add(time.Hour), Kind: Sampled, InstanceID: "i-3", IdleSince: launch.Add(time.Hour)},
+		{Time: launch.Add(2 * time.Hour), Kind: Sampled, InstanceID: "i-3", IdleSince: launch.Add(time.Hour)},
+		{Time: launch, Kind: Protected, InstanceID: "i-3", Protected: true},
+		{Time: launch.Add(3 * time.Hour), Kind: Sampled, InstanceID: "i-3", GPUUtilization: 97},
+		{Time: launch.Add(3 * time.Hour), Kind: Protected, InstanceID: "i-3"},
+	}
+	instances := Fold(events)
+
+	inst := instances["i-1"]
+	if inst.Name != "train" || inst.User != "alice" || inst.Profile != "work" || inst.APIURL != "https://example.test/api/v1" || inst.Template != "big" || inst.BudgetOverride != "paper deadline" {
+		t.Errorf("unexpected record %+v", inst)
+	}
+	if len(inst.Setups) != 2 || !inst.SetUp() || len(inst.Restarts) != 1 {
+		t.Errorf("setups %+v, restarts %v", inst.Setups, inst.Restarts)
+	}
+	now := launch.Add(10 * time.Hour)
+	if inst.Expired(now) {
+		t.Error("a terminated instance is not expired")
+	}
+	if got := inst.Uptime(now); got != 3*time.Hour {
+		t.Errorf("Uptime = %s, want 3h (until the first termination)", got)
+	}
+	if got := inst.CostCents(now); got != 600 {
+		t.Errorf("CostCents = %v, want 600", got)
+	}
+
+	if other := instances["i-2"]; other.SetUp() || other.Uptime(now) != 0 || other.Cluster != "train" || other.ClusterRank != 3 {
+		t.Errorf("instance without a recorded launch: %+v", other)
+	}
+
+	extended := instances["i-3"]
+	if !extended.ExpiresAt.Equal(launch.Add(2*time.Hour)) || extended.Expired(launch.Add(time.Hour)) || !extended.Expired(launch.Add(2*time.Hour)) {
+		t.Errorf("extended TTL: expires %s", extended.ExpiresAt)
+	}
+	if !extended.SampledAt.Equal(launch.Add(3*time.Hour)) || !extended.IdleSince.IsZero() || extended.Protected {
+		t.Errorf("sampled %s, idle since %s, protected %v; want busy and unprotected at the last events", extended.SampledAt, extended.IdleSince, extended.Protected)
+	}
+}
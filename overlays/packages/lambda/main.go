@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/cli"
 	"github.com/aarnphm/detachtools/overlays/packages/lambda/internal/configutil"
+	api "github.com/aarnphm/detachtools/overlays/packages/lambda/pkg/lambda"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -20,23 +26,66 @@ var (
 		Long:          `lm is a command-line tool to interact with the Lambda Cloud API for creating, connecting, setting up, and deleting instances.`,
 		SilenceUsage:  true,
 		SilenceErrors: true,
-		PersistentPreRun: func(cmd *cobra.Command, args []string) {
-			// Read API key from flag or environment variable
-			if apiKey == "" {
-				apiKey = configutil.GetEnvWithDefault("LAMBDA_API_KEY", "")
-				log.Debugf("API key loaded from environment variable LAMBDA_API_KEY: %s", apiKey)
-			} else {
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Settings resolve as flag > environment > profile > built-in default.
+			// The API key itself is resolved lazily when a client is built, so
+			// api_key_command only runs for commands that talk to the API.
+			profileName, profile, err := cli.ActiveProfile(cmd)
+			if err != nil {
+				return err
+			}
+			log.Debugf("Using profile '%s'", profileName)
+
+			if apiKey != "" {
 				log.Debugf("API key loaded from --api-key: %s", apiKey)
+			} else if apiKey = configutil.GetEnvWithDefault("LAMBDA_API_KEY", ""); apiKey != "" {
+				log.Debugf("API key loaded from environment variable LAMBDA_API_KEY: %s", apiKey)
+			}
+
+			resolveString(cmd, "api-url", "LAMBDA_API_URL", profile.APIURL, &apiURL)
+			if apiURL != api.DefaultBaseURL {
+				log.Debugf("Using API endpoint %s", apiURL)
+			}
+			resolveString(cmd, "ssh-key-name", "LM_SSH_KEY_NAME", profile.SSHKeyName, &sshKeyName)
+			resolveString(cmd, "ssh-key-path", "LM_SSH_KEY_PATH", profile.SSHKeyPath, &sshKeyPath)
+
+			// Retry settings fall back to the environment, then the profile
+			if !cmd.Flags().Changed("max-retries") {
+				if v := configutil.GetEnvWithDefault("LAMBDA_MAX_RETRIES", ""); v != "" {
+					n, err := strconv.Atoi(v)
+					if err != nil {
+						log.Warnf("Invalid LAMBDA_MAX_RETRIES '%s', using %d: %v", v, maxRetries, err)
+					} else {
+						maxRetries = n
+					}
+				} else if profile.MaxRetries != nil {
+					maxRetries = *profile.MaxRetries
+				}
 			}
-			if apiKey == "" {
-				log.Warn("API key not provided via --api-key flag or LAMBDA_API_KEY environment variable.")
+			if !cmd.Flags().Changed("retry-budget") {
+				v := configutil.GetEnvWithDefault("LAMBDA_RETRY_BUDGET", profile.RetryBudget)
+				if v != "" {
+					d, err := time.ParseDuration(v)
+					if err != nil {
+						log.Warnf("Invalid retry budget '%s', using %s: %v", v, retryBudget, err)
+					} else {
+						retryBudget = d
+					}
+				}
 			}
+			return nil
 		},
 	}
 	apiKey       string
+	apiURL       string
 	sshKeyName   string
 	sshKeyPath   string
 	outputFormat string
+	maxRetries   int
+	retryBudget  time.Duration
+
+	configPath  string
+	profileName string
 
 	// version of the CLI, set during build via ldflags
 	version = "dev"
@@ -52,10 +101,15 @@ var (
 
 func init() {
 	// Add persistent flags
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "Path to the config file (env: LM_CONFIG, default: $XDG_CONFIG_HOME/lm/config.json)")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "Config profile to use (env: LM_PROFILE)")
 	rootCmd.PersistentFlags().StringVar(&apiKey, "api-key", "", "Lambda Cloud API key (env: LAMBDA_API_KEY)")
-	rootCmd.PersistentFlags().StringVar(&sshKeyName, "ssh-key-name", configutil.SSHKeyName, "SSH key name to use for instances")
-	rootCmd.PersistentFlags().StringVar(&sshKeyPath, "ssh-key-path", configutil.DefaultSSHKeyPath, "Path to the SSH private key")
-	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "table", "Output format. One of: json|table")
+	rootCmd.PersistentFlags().StringVar(&apiURL, "api-url", api.DefaultBaseURL, "Lambda Cloud API base URL (env: LAMBDA_API_URL)")
+	rootCmd.PersistentFlags().StringVar(&sshKeyName, "ssh-key-name", configutil.DefaultProfile().SSHKeyName, "SSH key name to use for instances (env: LM_SSH_KEY_NAME, default: the local user name)")
+	rootCmd.PersistentFlags().StringVar(&sshKeyPath, "ssh-key-path", configutil.DefaultSSHKeyPath, "Path to the SSH private key (env: LM_SSH_KEY_PATH)")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "table", "Output format. One of: json|table (lm cost also takes csv)")
+	rootCmd.PersistentFlags().IntVar(&maxRetries, "max-retries", api.DefaultRetryPolicy().MaxAttempts-1, "Maximum number of retries for transient API failures (env: LAMBDA_MAX_RETRIES)")
+	rootCmd.PersistentFlags().DurationVar(&retryBudget, "retry-budget", api.DefaultRetryPolicy().Budget, "Maximum time spent on a single API call across retries, 0 for no limit (env: LAMBDA_RETRY_BUDGET)")
 
 	// Add commands
 	rootCmd.AddCommand(cli.CreateCmd)
@@ -65,9 +119,34 @@ func init() {
 	rootCmd.AddCommand(cli.CompletionCmd)
 	rootCmd.AddCommand(cli.ListCmd)
 	rootCmd.AddCommand(cli.RestartCmd)
+	rootCmd.AddCommand(cli.DevCmd)
+	rootCmd.AddCommand(cli.ConfigCmd)
+	rootCmd.AddCommand(cli.SSHKeyCmd)
+	rootCmd.AddCommand(cli.FSCmd)
+	rootCmd.AddCommand(cli.TypesCmd)
+	rootCmd.AddCommand(cli.ClusterCmd)
+	rootCmd.AddCommand(cli.KnownHostsCmd)
+	rootCmd.AddCommand(cli.TemplateCmd)
+	rootCmd.AddCommand(cli.CostCmd)
+	rootCmd.AddCommand(cli.TTLCmd)
+	rootCmd.AddCommand(cli.ReapCmd)
+	rootCmd.AddCommand(cli.ProtectCmd)
 	rootCmd.AddCommand(VersionCmd)
 }
 
+// resolveString fills target for a root flag that was not set explicitly,
+// from the environment variable key or else the profile value.
+func resolveString(cmd *cobra.Command, flag, key, profileValue string, target *string) {
+	if cmd.Flags().Changed(flag) {
+		return
+	}
+	if v := configutil.GetEnvWithDefault(key, ""); v != "" {
+		*target = v
+	} else if profileValue != "" {
+		*target = profileValue
+	}
+}
+
 func main() {
 	formatter := &log.TextFormatter{
 		FullTimestamp:   true,
@@ -104,7 +183,19 @@ func main() {
 	log.SetFormatter(formatter)
 	log.Debugf("Log level set to %s based on DEBUG=%d", log.GetLevel(), debugLevel)
 
-	if err := rootCmd.Execute(); err != nil {
+	// Cancel in-flight API calls and SSH operations on Ctrl-C or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		if ctx.Err() != nil {
+			stop()
+			log.Errorf("Interrupted: %v", err)
+			os.Exit(130)
+		}
+		if api.IsAuthError(err) {
+			log.Error("The Lambda Cloud API rejected the API key. Check --api-key, LAMBDA_API_KEY or the api key of the active profile.")
+		}
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,188 @@
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultBaseURL is the public Lambda Cloud v1 API endpoint.
+	DefaultBaseURL = "https://cloud.lambda.ai/api/v1"
+	// DefaultUserAgent is sent unless overridden with WithUserAgent.
+	DefaultUserAgent = "lambda-go"
+)
+
+// Client wraps the HTTP client and API key. A Client is safe for concurrent use.
+type Client struct {
+	apiKey    string
+	baseURL   string
+	userAgent string
+	client    *http.Client
+	retry     RetryPolicy
+	logger    Logger
+}
+
+// NewClient creates a new client for interacting with the Lambda Cloud API.
+func NewClient(apiKey string, opts ...Option) (*Client, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("API key cannot be empty")
+	}
+	c := &Client{
+		apiKey:    apiKey,
+		baseURL:   DefaultBaseURL,
+		userAgent: DefaultUserAgent,
+		client:    &http.Client{Timeout: 60 * time.Second}, // Increased timeout slightly
+		retry:     DefaultRetryPolicy(),
+		logger:    nopLogger{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.baseURL = strings.TrimRight(c.baseURL, "/")
+	if c.logger == nil {
+		c.logger = nopLogger{}
+	}
+	return c, nil
+}
+
+// BaseURL returns the API endpoint the client talks to.
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// Request makes a request to the Lambda Cloud API.
+//
+// Non-2xx responses are returned as *APIError; see IsCapacityError,
+// IsAuthError and IsRetryable for classifying them.
+//
+// Transient failures (429, 5xx, connection errors) are retried with
+// exponential backoff according to the client's RetryPolicy. GET requests
+// are always considered idempotent; other methods are only retried on
+// 5xx and connection errors when marked with Idempotent.
+//
+// The context bounds the whole call: cancelling it aborts the in-flight HTTP
+// request as well as any pending backoff wait.
+func (c *Client) Request(ctx context.Context, method, endpoint string, body any, result any, opts ...RequestOption) error {
+	url := c.baseURL + endpoint
+	var reqBody []byte
+	var err error
+
+	o := requestOptions{idempotent: method == http.MethodGet || method == http.MethodHead}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// Marshal request body if provided
+	if body != nil {
+		reqBody, err = json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		c.logger.Tracef("%s %s: %s", method, url, string(reqBody))
+	}
+
+	policy := c.retry
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		res := c.do(ctx, method, url, reqBody, body != nil, result, o.idempotent)
+		if res.err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || !res.retryable || attempt >= policy.MaxAttempts {
+			return res.err
+		}
+
+		wait := policy.backoff(attempt)
+		if res.retryAfter > 0 {
+			wait = res.retryAfter
+		}
+		if policy.Budget > 0 && time.Since(start)+wait > policy.Budget {
+			c.logger.Debugf("%s %s: retry budget of %s exhausted after %d attempt(s)", method, url, policy.Budget, attempt)
+			return res.err
+		}
+		c.logger.Warnf("%s %s failed (attempt %d/%d): %v. Retrying in %s", method, url, attempt, policy.MaxAttempts, res.err, wait.Round(time.Millisecond))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%s %s cancelled while waiting to retry: %w", method, url, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// attemptResult describes the outcome of a single HTTP round trip.
+type attemptResult struct {
+	err        error
+	retryable  bool
+	retryAfter time.Duration
+}
+
+// do performs a single HTTP round trip and decodes the response into result.
+func (c *Client) do(ctx context.Context, method, url string, reqBody []byte, hasBody bool, result any, idempotent bool) attemptResult {
+	// Create HTTP request
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(reqBody))
+	if err != nil {
+		return attemptResult{err: fmt.Errorf("failed to create request: %w", err)}
+	}
+
+	// Set headers
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	if hasBody {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+
+	c.logger.Debugf("%s %s", method, url)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		// The request may or may not have reached the server, so only
+		// retry when repeating it is harmless.
+		return attemptResult{
+			err:       fmt.Errorf("failed to execute request to %s: %w", url, err),
+			retryable: idempotent && IsRetryable(err),
+		}
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, readErr := io.ReadAll(resp.Body)
+	if readErr != nil {
+		c.logger.Warnf("Failed to read response body from %s %s: %v", method, url, readErr)
+		// Don't return yet, maybe we can still use status code or headers
+	}
+	c.logger.Tracef("%s %s: %s", method, url, string(bodyBytes))
+
+	// Check status code
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := newAPIError(method, url, resp, bodyBytes)
+		return attemptResult{
+			err:        apiErr,
+			retryable:  isRetryableStatus(resp.StatusCode, idempotent),
+			retryAfter: apiErr.RetryAfter,
+		}
+	}
+
+	// Decode successful response if result pointer is provided
+	if result != nil {
+		if len(bodyBytes) == 0 {
+			// Handle cases like 204 No Content where body is expectedly empty
+			if resp.StatusCode == http.StatusNoContent {
+				c.logger.Debugf("%s %s returned %s, no body to decode.", method, url, resp.Status)
+				return attemptResult{}
+			}
+			return attemptResult{err: fmt.Errorf("%s %s succeeded (%s) but response body was empty", method, url, resp.Status)}
+		}
+		if err := json.Unmarshal(bodyBytes, result); err != nil {
+			return attemptResult{err: fmt.Errorf("failed to decode successful response body from %s %s: %w", method, url, err)}
+		}
+	}
+
+	c.logger.Debugf("%s %s (%s)", method, url, resp.Status)
+	return attemptResult{}
+}
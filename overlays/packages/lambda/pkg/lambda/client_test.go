@@ -0,0 +1,181 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *httptest.Server) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	c, err := NewClient("test-key",
+		WithBaseURL(srv.URL),
+		WithRetryPolicy(RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     5 * time.Millisecond,
+			Budget:         time.Second,
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return c, srv
+}
+
+func TestRequestRetriesTransientGET(t *testing.T) {
+	var calls atomic.Int32
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"data":[]}`))
+	})
+
+	var resp InstancesResponse
+	if err := c.Request(context.Background(), "GET", "/instances", nil, &resp); err != nil {
+		t.Fatalf("expected success after retries, got %v", err)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("want 3 attempts, got %d", got)
+	}
+}
+
+func TestRequestDoesNotRetryNonIdempotent(t *testing.T) {
+	var calls atomic.Int32
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	err := c.Request(context.Background(), "POST", "/instance-operations/launch", LaunchRequest{Name: "x"}, nil)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("launch must not be retried on 5xx, got %d attempts", got)
+	}
+
+	calls.Store(0)
+	err = c.Request(context.Background(), "POST", "/instance-operations/terminate", TerminateRequest{InstanceIDs: []string{"i"}}, nil, Idempotent())
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("idempotent terminate should use all attempts, got %d", got)
+	}
+}
+
+func TestRequestRetryBudget(t *testing.T) {
+	var calls atomic.Int32
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	start := time.Now()
+	if err := c.Request(context.Background(), "GET", "/instances", nil, nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Retry-After beyond budget should stop retries, got %d attempts", got)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("request should fail fast when Retry-After exceeds the budget")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"7", 7 * time.Second},
+		{"-1", 0},
+		{"garbage", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+	}
+	for _, tc := range tests {
+		if got := parseRetryAfter(tc.in, now); got != tc.want {
+			t.Errorf("parseRetryAfter(%q): want %s, got %s", tc.in, tc.want, got)
+		}
+	}
+}
+
+func TestRequestCancelledDuringBackoff(t *testing.T) {
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	c.retry = RetryPolicy{MaxAttempts: 3}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := c.Request(ctx, "GET", "/instances", nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want context.DeadlineExceeded, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("cancellation should interrupt the backoff wait")
+	}
+}
+
+func TestRequestReturnsAPIError(t *testing.T) {
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "req-123")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"code":"instance-operations/launch/insufficient-capacity","message":"Not enough capacity","suggestion":"Try another region"}}`))
+	})
+
+	err := c.Request(context.Background(), "POST", "/instance-operations/launch", LaunchRequest{}, nil)
+	apiErr, ok := AsAPIError(err)
+	if !ok {
+		t.Fatalf("want *APIError, got %T: %v", err, err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.RequestID != "req-123" || apiErr.Suggestion != "Try another region" {
+		t.Errorf("unexpected APIError fields: %+v", apiErr)
+	}
+	if !IsCapacityError(err) {
+		t.Error("IsCapacityError: want true")
+	}
+	if IsAuthError(err) || IsRetryable(err) {
+		t.Error("capacity error must not be classified as auth or retryable")
+	}
+}
+
+func TestErrorClassification(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		auth      bool
+		retryable bool
+	}{
+		{"invalid key", &APIError{StatusCode: 401, Code: ErrCodeInvalidAPIKey}, true, false},
+		{"rate limited", &APIError{StatusCode: 429}, false, true},
+		{"bad gateway", fmt.Errorf("wrapped: %w", &APIError{StatusCode: 502}), false, true},
+		{"cancelled", context.Canceled, false, false},
+		{"plain", errors.New("boom"), false, false},
+	}
+	for _, tc := range tests {
+		if got := IsAuthError(tc.err); got != tc.auth {
+			t.Errorf("%s: IsAuthError want %v, got %v", tc.name, tc.auth, got)
+		}
+		if got := IsRetryable(tc.err); got != tc.retryable {
+			t.Errorf("%s: IsRetryable want %v, got %v", tc.name, tc.retryable, got)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+// Package lambda is a Go client for the Lambda Cloud v1 API.
+//
+// It covers instances, instance types, file systems, SSH keys, images and
+// firewall rules, retries transient failures according to a RetryPolicy, and
+// reports API failures as *APIError. The SSH helpers (DialSSH, RunCommand,
+// CopyFile, ...) work with golang.org/x/crypto/ssh clients connected to
+// launched instances.
+//
+// The package never logs through global loggers or writes to stdout; pass
+// WithLogger to observe requests and retries.
+//
+//	client, err := lambda.NewClient(os.Getenv("LAMBDA_API_KEY"))
+//	if err != nil {
+//		return err
+//	}
+//	inst, err := client.ResolveInstance(ctx, "my-instance")
+package lambda
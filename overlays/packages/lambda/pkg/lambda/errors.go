@@ -0,0 +1,161 @@
+package lambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Lambda Cloud error codes callers commonly need to distinguish.
+const (
+	ErrCodeInvalidAPIKey        = "global/invalid-api-key"
+	ErrCodeAccountInactive      = "global/account-inactive"
+	ErrCodeInvalidParameters    = "global/invalid-parameters"
+	ErrCodeObjectDoesNotExist   = "global/object-does-not-exist"
+	ErrCodeQuotaExceeded        = "global/quota-exceeded"
+	ErrCodeInsufficientCapacity = "instance-operations/launch/insufficient-capacity"
+)
+
+// APIError is returned by Request when the API answers with a non-2xx status.
+type APIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	// Code, Message and Suggestion come from the Lambda error envelope
+	// ({"error": {"code", "message", "suggestion"}}) when present.
+	Code       string
+	Message    string
+	Suggestion string
+	// RequestID is the server-assigned request ID, if the response carried one.
+	RequestID string
+	// Body holds the (truncated) raw response body when it was not a Lambda
+	// error envelope.
+	Body string
+	// RetryAfter is the delay requested through the Retry-After header.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s failed [%s]", e.Method, e.URL, e.Status)
+	switch {
+	case e.Message != "":
+		fmt.Fprintf(&b, ": [%s] %s", e.Code, e.Message)
+		if e.Suggestion != "" {
+			fmt.Fprintf(&b, " (%s)", e.Suggestion)
+		}
+	case e.Body != "":
+		fmt.Fprintf(&b, ". Response body: %s", e.Body)
+	}
+	if e.RequestID != "" {
+		fmt.Fprintf(&b, " (request ID: %s)", e.RequestID)
+	}
+	return b.String()
+}
+
+// Retryable reports whether the failure is transient: rate limiting or a
+// server-side error. It says nothing about whether repeating the call is safe.
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests ||
+		(e.StatusCode >= 500 && e.StatusCode != http.StatusNotImplemented)
+}
+
+// newAPIError builds an APIError from a failed response and its body.
+func newAPIError(method, url string, resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		Method:     method,
+		URL:        url,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+	for _, h := range []string{"X-Request-Id", "X-Amzn-Requestid", "Cf-Ray"} {
+		if v := resp.Header.Get(h); v != "" {
+			apiErr.RequestID = v
+			break
+		}
+	}
+
+	// Try to parse standard Lambda error response
+	var errResp struct {
+		Error struct {
+			Code       string `json:"code"`
+			Message    string `json:"message"`
+			Suggestion string `json:"suggestion"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error.Message != "" {
+		apiErr.Code = errResp.Error.Code
+		apiErr.Message = errResp.Error.Message
+		apiErr.Suggestion = errResp.Error.Suggestion
+	} else if len(body) > 0 {
+		truncatedBody := string(body)
+		if len(truncatedBody) > 200 {
+			truncatedBody = truncatedBody[:200] + "..."
+		}
+		apiErr.Body = truncatedBody
+	}
+	return apiErr
+}
+
+// AsAPIError returns the APIError wrapped in err, if any.
+func AsAPIError(err error) (*APIError, bool) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr, true
+	}
+	return nil, false
+}
+
+// IsCapacityError reports whether err means the requested instance type has
+// no capacity left in the requested region.
+func IsCapacityError(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	return ok && apiErr.Code == ErrCodeInsufficientCapacity
+}
+
+// IsAuthError reports whether err was caused by a missing, invalid or
+// inactive API key.
+func IsAuthError(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	if !ok {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusUnauthorized ||
+		apiErr.StatusCode == http.StatusForbidden ||
+		apiErr.Code == ErrCodeInvalidAPIKey ||
+		apiErr.Code == ErrCodeAccountInactive
+}
+
+// IsQuotaError reports whether err was caused by an account quota.
+func IsQuotaError(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	return ok && apiErr.Code == ErrCodeQuotaExceeded
+}
+
+// IsNotFound reports whether err means the requested object does not exist.
+func IsNotFound(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	return ok && (apiErr.StatusCode == http.StatusNotFound || apiErr.Code == ErrCodeObjectDoesNotExist)
+}
+
+// IsRetryable reports whether err is a transient failure: a retryable API
+// status or a network-level error. Context cancellation is never retryable.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if apiErr, ok := AsAPIError(err); ok {
+		return apiErr.Retryable()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
@@ -0,0 +1,50 @@
+package lambda
+
+import (
+	"net/http"
+)
+
+// Logger receives diagnostic output from the client. It is satisfied by
+// *logrus.Logger and *logrus.Entry among others.
+type Logger interface {
+	Tracef(format string, args ...any)
+	Debugf(format string, args ...any)
+	Warnf(format string, args ...any)
+}
+
+// nopLogger discards everything. It is the default so that embedding the
+// client never writes to the caller's stdout or stderr.
+type nopLogger struct{}
+
+func (nopLogger) Tracef(string, ...any) {}
+func (nopLogger) Debugf(string, ...any) {}
+func (nopLogger) Warnf(string, ...any)  {}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithBaseURL points the client at a different API endpoint, such as a fake
+// server used in tests.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) { c.baseURL = baseURL }
+}
+
+// WithHTTPClient replaces the HTTP client used for requests.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.client = httpClient }
+}
+
+// WithLogger sets the logger used for request tracing and retry warnings.
+func WithLogger(logger Logger) Option {
+	return func(c *Client) { c.logger = logger }
+}
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) { c.userAgent = userAgent }
+}
+
+// WithRetryPolicy sets the retry policy; see DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) { c.retry = policy }
+}
@@ -0,0 +1,82 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInstanceNotFound is returned when no instance matches a name or ID.
+	ErrInstanceNotFound = errors.New("instance not found")
+	// ErrAmbiguousInstance is returned when several instances share a name.
+	ErrAmbiguousInstance = errors.New("instance name is ambiguous")
+	// ErrFileSystemNotFound is returned when no file system matches a name or ID.
+	ErrFileSystemNotFound = errors.New("file system not found")
+)
+
+// FindInstance looks up an instance by ID first, then by name. Names are not
+// unique in Lambda Cloud, so a name matching several instances is an error.
+func FindInstance(instances []Instance, nameOrID string) (*Instance, error) {
+	for i := range instances {
+		if instances[i].ID == nameOrID {
+			return &instances[i], nil
+		}
+	}
+
+	var match *Instance
+	for i := range instances {
+		if instances[i].Name == nameOrID {
+			if match != nil {
+				return nil, fmt.Errorf("%w: multiple instances found with the name '%s'. Please use the unique instance ID instead", ErrAmbiguousInstance, nameOrID)
+			}
+			match = &instances[i]
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("%w: no instance found with name or ID '%s'", ErrInstanceNotFound, nameOrID)
+	}
+	return match, nil
+}
+
+// ResolveInstance fetches the instance list and resolves nameOrID with FindInstance.
+func (c *Client) ResolveInstance(ctx context.Context, nameOrID string) (*Instance, error) {
+	instances, err := c.ListInstances(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching instances: %w", err)
+	}
+	return FindInstance(instances, nameOrID)
+}
+
+// FindFileSystem looks up a file system by ID first, then by name.
+func FindFileSystem(fileSystems []FileSystem, nameOrID string) (*FileSystem, error) {
+	for i := range fileSystems {
+		if fileSystems[i].ID == nameOrID {
+			return &fileSystems[i], nil
+		}
+	}
+	for i := range fileSystems {
+		if fileSystems[i].Name == nameOrID {
+			return &fileSystems[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no file system found with name or ID '%s'", ErrFileSystemNotFound, nameOrID)
+}
+
+// MountedBy returns the instances that are not terminated and have fs
+// attached. File systems are regional, so only instances in fs's region match.
+func MountedBy(fs FileSystem, instances []Instance) []Instance {
+	var mounted []Instance
+	for _, inst := range instances {
+		if inst.Status == "terminated" || inst.Region.Name != fs.Region.Name {
+			continue
+		}
+		for _, name := range inst.FileSystemNames {
+			if name == fs.Name {
+				mounted = append(mounted, inst)
+				break
+			}
+		}
+	}
+	return mounted
+}
@@ -0,0 +1,80 @@
+package lambda
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFindInstance(t *testing.T) {
+	instances := []Instance{
+		{ID: "id-1", Name: "train"},
+		{ID: "id-2", Name: "eval"},
+		{ID: "id-3", Name: "eval"},
+		{ID: "id-4", Name: "id-1"}, // a name that collides with another instance's ID
+	}
+
+	tests := []struct {
+		nameOrID string
+		wantID   string
+		wantErr  error
+	}{
+		{"id-2", "id-2", nil},
+		{"train", "id-1", nil},
+		{"id-1", "id-1", nil}, // IDs take precedence over names
+		{"eval", "", ErrAmbiguousInstance},
+		{"missing", "", ErrInstanceNotFound},
+	}
+	for _, tc := range tests {
+		got, err := FindInstance(instances, tc.nameOrID)
+		if tc.wantErr != nil {
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("%s: want %v, got %v", tc.nameOrID, tc.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.nameOrID, err)
+			continue
+		}
+		if got.ID != tc.wantID {
+			t.Errorf("%s: want %s, got %s", tc.nameOrID, tc.wantID, got.ID)
+		}
+	}
+}
+
+func TestFindFileSystem(t *testing.T) {
+	fileSystems := []FileSystem{{ID: "fs-1", Name: "data"}, {ID: "fs-2", Name: "fs-1"}}
+	if got, err := FindFileSystem(fileSystems, "fs-1"); err != nil || got.ID != "fs-1" {
+		t.Errorf("by ID: got %+v, %v", got, err)
+	}
+	if got, err := FindFileSystem(fileSystems, "data"); err != nil || got.ID != "fs-1" {
+		t.Errorf("by name: got %+v, %v", got, err)
+	}
+	if _, err := FindFileSystem(fileSystems, "missing"); !errors.Is(err, ErrFileSystemNotFound) {
+		t.Errorf("missing: want ErrFileSystemNotFound, got %v", err)
+	}
+}
+
+func TestMountedBy(t *testing.T) {
+	fs := FileSystem{Name: "data"}
+	fs.Region.Name = "us-east-1"
+	inst := func(id, status, region string, fileSystems ...string) Instance {
+		i := Instance{ID: id, Status: status, FileSystemNames: fileSystems}
+		i.Region.Name = region
+		return i
+	}
+	instances := []Instance{
+		inst("a", "active", "us-east-1", "data"),
+		inst("b", "terminated", "us-east-1", "data"),
+		inst("c", "active", "us-west-1", "data"),
+		inst("d", "booting", "us-east-1", "other", "data"),
+		inst("e", "active", "us-east-1"),
+	}
+	var ids []string
+	for _, i := range MountedBy(fs, instances) {
+		ids = append(ids, i.ID)
+	}
+	if len(ids) != 2 || ids[0] != "a" || ids[1] != "d" {
+		t.Errorf("MountedBy = %v, want [a d]", ids)
+	}
+}
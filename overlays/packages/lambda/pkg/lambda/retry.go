@@ -0,0 +1,101 @@
+package lambda
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how Request retries transient failures.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	// Values <= 1 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the base delay before the first retry. It doubles on
+	// every subsequent attempt, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Budget bounds the wall time spent on a single call across all attempts,
+	// including waits. Zero means no budget.
+	Budget time.Duration
+}
+
+// DefaultRetryPolicy returns the policy used by NewClient.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		Budget:         2 * time.Minute,
+	}
+}
+
+// backoff returns the jittered delay to wait after the given (1-based) failed attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	if p.InitialBackoff <= 0 {
+		return 0
+	}
+	d := p.InitialBackoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	// Equal jitter: keep half of the delay, randomize the other half so that
+	// concurrent lm processes don't retry in lockstep.
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+// RequestOption customizes a single Request call.
+type RequestOption func(*requestOptions)
+
+type requestOptions struct {
+	idempotent bool
+}
+
+// Idempotent marks a non-GET call as safe to retry on transient failures.
+// Use it for operations that can be repeated without side effects, such as
+// terminating or restarting a known set of instance IDs.
+func Idempotent() RequestOption {
+	return func(o *requestOptions) { o.idempotent = true }
+}
+
+// isRetryableStatus reports whether an HTTP status is worth retrying.
+// 429 means the request was rejected before being processed, so it is safe
+// to retry regardless of idempotency; 5xx responses are only retried for
+// idempotent calls because the server may have acted on the request.
+func isRetryableStatus(status int, idempotent bool) bool {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return true
+	case status >= 500 && status != http.StatusNotImplemented:
+		return idempotent
+	default:
+		return false
+	}
+}
+
+// parseRetryAfter parses a Retry-After header value given either in seconds
+// or as an HTTP date. It returns 0 when the header is absent or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
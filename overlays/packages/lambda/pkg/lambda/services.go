@@ -0,0 +1,171 @@
+package lambda
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// Endpoints of the Lambda Cloud v1 API. Note that file systems are listed
+// under /file-systems but created and deleted under /filesystems; this
+// mirrors the upstream API.
+const (
+	instancesPath       = "/instances"
+	instanceTypesPath   = "/instance-types"
+	launchPath          = "/instance-operations/launch"
+	terminatePath       = "/instance-operations/terminate"
+	restartPath         = "/instance-operations/restart"
+	listFileSystemsPath = "/file-systems"
+	fileSystemsPath     = "/filesystems"
+	sshKeysPath         = "/ssh-keys"
+	imagesPath          = "/images"
+	firewallRulesPath   = "/firewall-rules"
+)
+
+// ListInstances returns all instances on the account, in any status.
+func (c *Client) ListInstances(ctx context.Context) ([]Instance, error) {
+	var resp InstancesResponse
+	if err := c.Request(ctx, "GET", instancesPath, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// GetInstance returns the instance with the given ID.
+func (c *Client) GetInstance(ctx context.Context, id string) (*Instance, error) {
+	var resp InstanceResponse
+	if err := c.Request(ctx, "GET", instancesPath+"/"+url.PathEscape(id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// RenameInstance sets the name of the instance with the given ID.
+func (c *Client) RenameInstance(ctx context.Context, id, name string) (*Instance, error) {
+	var resp InstanceResponse
+	req := UpdateInstanceRequest{Name: name}
+	if err := c.Request(ctx, "POST", instancesPath+"/"+url.PathEscape(id), req, &resp, Idempotent()); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// Launch launches instances and returns their IDs. Launch is never retried
+// on server errors since the instances may already have been created.
+func (c *Client) Launch(ctx context.Context, req LaunchRequest) ([]string, error) {
+	var resp LaunchResponse
+	if err := c.Request(ctx, "POST", launchPath, req, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Data.InstanceIDs) == 0 {
+		return nil, fmt.Errorf("instance launch initiated, but no instance ID returned")
+	}
+	return resp.Data.InstanceIDs, nil
+}
+
+// Terminate terminates the given instances and returns the instances the API
+// acknowledged.
+func (c *Client) Terminate(ctx context.Context, ids ...string) ([]Instance, error) {
+	var resp TerminateResponse
+	if err := c.Request(ctx, "POST", terminatePath, TerminateRequest{InstanceIDs: ids}, &resp, Idempotent()); err != nil {
+		return nil, err
+	}
+	return resp.Data.TerminatedInstances, nil
+}
+
+// Restart restarts the given instances and returns the instances the API
+// acknowledged.
+func (c *Client) Restart(ctx context.Context, ids ...string) ([]Instance, error) {
+	var resp RestartResponse
+	if err := c.Request(ctx, "POST", restartPath, RestartRequest{InstanceIDs: ids}, &resp, Idempotent()); err != nil {
+		return nil, err
+	}
+	return resp.Data.RestartedInstances, nil
+}
+
+// ListInstanceTypes returns all instance types keyed by name, along with the
+// regions that currently have capacity for each.
+func (c *Client) ListInstanceTypes(ctx context.Context) (map[string]InstanceTypeDetails, error) {
+	var resp InstanceTypesResponse
+	if err := c.Request(ctx, "GET", instanceTypesPath, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// ListFileSystems returns all persistent file systems on the account.
+func (c *Client) ListFileSystems(ctx context.Context) ([]FileSystem, error) {
+	var resp FileSystemsResponse
+	if err := c.Request(ctx, "GET", listFileSystemsPath, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// CreateFileSystem creates a persistent file system in the given region.
+func (c *Client) CreateFileSystem(ctx context.Context, name, region string) (*FileSystem, error) {
+	var resp CreateFilesystemResponse
+	req := CreateFilesystemRequest{Name: name, RegionName: region}
+	if err := c.Request(ctx, "POST", fileSystemsPath, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// DeleteFileSystem deletes the file system with the given ID.
+func (c *Client) DeleteFileSystem(ctx context.Context, id string) error {
+	var resp DeleteFilesystemResponse
+	return c.Request(ctx, "DELETE", fileSystemsPath+"/"+url.PathEscape(id), nil, &resp, Idempotent())
+}
+
+// ListSSHKeys returns the SSH keys registered on the account.
+func (c *Client) ListSSHKeys(ctx context.Context) ([]SSHKey, error) {
+	var resp SSHKeysResponse
+	if err := c.Request(ctx, "GET", sshKeysPath, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// AddSSHKey registers a public key under name. If publicKey is empty, the API
+// generates a new key pair and the returned key carries the private half.
+func (c *Client) AddSSHKey(ctx context.Context, name, publicKey string) (*SSHKey, error) {
+	var resp AddSSHKeyResponse
+	req := AddSSHKeyRequest{Name: name, PublicKey: publicKey}
+	if err := c.Request(ctx, "POST", sshKeysPath, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// DeleteSSHKey deletes the SSH key with the given ID.
+func (c *Client) DeleteSSHKey(ctx context.Context, id string) error {
+	return c.Request(ctx, "DELETE", sshKeysPath+"/"+url.PathEscape(id), nil, nil, Idempotent())
+}
+
+// ListImages returns the machine images available for launching.
+func (c *Client) ListImages(ctx context.Context) ([]Image, error) {
+	var resp ImagesResponse
+	if err := c.Request(ctx, "GET", imagesPath, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// ListFirewallRules returns the inbound firewall rules applied to instances.
+func (c *Client) ListFirewallRules(ctx context.Context) ([]FirewallRule, error) {
+	var resp FirewallRulesResponse
+	if err := c.Request(ctx, "GET", firewallRulesPath, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// SetFirewallRules replaces all inbound firewall rules with rules.
+func (c *Client) SetFirewallRules(ctx context.Context, rules []FirewallRule) ([]FirewallRule, error) {
+	var resp FirewallRulesResponse
+	if err := c.Request(ctx, "PUT", firewallRulesPath, SetFirewallRulesRequest{Data: rules}, &resp, Idempotent()); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
@@ -0,0 +1,73 @@
+package lambda
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestServiceEndpoints(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		call   func(c *Client) error
+	}{
+		{"ListInstances", "GET", "/instances", func(c *Client) error { _, err := c.ListInstances(ctx); return err }},
+		{"GetInstance", "GET", "/instances/abc", func(c *Client) error { _, err := c.GetInstance(ctx, "abc"); return err }},
+		{"RenameInstance", "POST", "/instances/abc", func(c *Client) error { _, err := c.RenameInstance(ctx, "abc", "new"); return err }},
+		{"Launch", "POST", "/instance-operations/launch", func(c *Client) error { _, err := c.Launch(ctx, LaunchRequest{}); return err }},
+		{"Terminate", "POST", "/instance-operations/terminate", func(c *Client) error { _, err := c.Terminate(ctx, "abc"); return err }},
+		{"Restart", "POST", "/instance-operations/restart", func(c *Client) error { _, err := c.Restart(ctx, "abc"); return err }},
+		{"ListInstanceTypes", "GET", "/instance-types", func(c *Client) error { _, err := c.ListInstanceTypes(ctx); return err }},
+		{"ListFileSystems", "GET", "/file-systems", func(c *Client) error { _, err := c.ListFileSystems(ctx); return err }},
+		{"CreateFileSystem", "POST", "/filesystems", func(c *Client) error { _, err := c.CreateFileSystem(ctx, "fs", "us-east-1"); return err }},
+		{"DeleteFileSystem", "DELETE", "/filesystems/fs-1", func(c *Client) error { return c.DeleteFileSystem(ctx, "fs-1") }},
+		{"ListSSHKeys", "GET", "/ssh-keys", func(c *Client) error { _, err := c.ListSSHKeys(ctx); return err }},
+		{"AddSSHKey", "POST", "/ssh-keys", func(c *Client) error { _, err := c.AddSSHKey(ctx, "k", "ssh-ed25519 AAAA"); return err }},
+		{"DeleteSSHKey", "DELETE", "/ssh-keys/key-1", func(c *Client) error { return c.DeleteSSHKey(ctx, "key-1") }},
+		{"ListImages", "GET", "/images", func(c *Client) error { _, err := c.ListImages(ctx); return err }},
+		{"ListFirewallRules", "GET", "/firewall-rules", func(c *Client) error { _, err := c.ListFirewallRules(ctx); return err }},
+		{"SetFirewallRules", "PUT", "/firewall-rules", func(c *Client) error { _, err := c.SetFirewallRules(ctx, nil); return err }},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotMethod, gotPath string
+			c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				gotMethod, gotPath = r.Method, r.URL.Path
+				// Every endpoint wraps its payload in "data"; launch needs an ID to succeed.
+				json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"instance_ids": []string{"abc"}}})
+			})
+			// Responses are shaped for launch only, so decoding may fail for
+			// other endpoints; only the request line matters here.
+			_ = tc.call(c)
+			if gotMethod != tc.method || gotPath != tc.path {
+				t.Errorf("want %s %s, got %s %s", tc.method, tc.path, gotMethod, gotPath)
+			}
+		})
+	}
+}
+
+func TestLaunchReturnsInstanceIDs(t *testing.T) {
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req LaunchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding launch request: %v", err)
+		}
+		if req.Quantity != 2 || req.InstanceTypeName != "gpu_1x_a10" {
+			t.Errorf("unexpected launch request: %+v", req)
+		}
+		w.Write([]byte(`{"data":{"instance_ids":["a","b"]}}`))
+	})
+
+	ids, err := c.Launch(context.Background(), LaunchRequest{InstanceTypeName: "gpu_1x_a10", Quantity: 2})
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != "a" || ids[1] != "b" {
+		t.Errorf("want [a b], got %v", ids)
+	}
+}
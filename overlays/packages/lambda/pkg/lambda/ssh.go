@@ -0,0 +1,261 @@
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultSSHUser is the login user on Lambda Cloud images.
+const DefaultSSHUser = "ubuntu"
+
+// LoadSigner reads and parses an SSH private key. If the key is encrypted,
+// passphrase is called to obtain the passphrase; a nil passphrase function
+// makes encrypted keys an error.
+func LoadSigner(keyPath string, passphrase func() ([]byte, error)) (ssh.Signer, error) {
+	keyBytes, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading SSH key file '%s': %w", keyPath, err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	if err == nil {
+		return signer, nil
+	}
+	var passphraseMissingErr *ssh.PassphraseMissingError
+	if !errors.As(err, &passphraseMissingErr) || passphrase == nil {
+		return nil, fmt.Errorf("parsing private key '%s': %w", keyPath, err)
+	}
+	pass, err := passphrase()
+	if err != nil {
+		return nil, fmt.Errorf("reading passphrase: %w", err)
+	}
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(keyBytes, pass)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key with passphrase: %w", err)
+	}
+	return signer, nil
+}
+
+// DialSSH is ssh.Dial with cancellation: ctx aborts both the TCP dial and the
+// SSH handshake. Cancelling ctx after DialSSH returns does not affect the client.
+func DialSSH(ctx context.Context, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	dialer := net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if config.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(config.Timeout))
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if !stop() || err != nil {
+		conn.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// errHostKeyCaptured aborts the handshake in FetchHostKey once the server
+// has presented its key.
+var errHostKeyCaptured = errors.New("host key captured")
+
+// FetchHostKey connects to the SSH server at addr and returns the host key it
+// presents, without authenticating. The key is not verified; the caller
+// decides whether to trust it. timeout bounds the dial and handshake.
+func FetchHostKey(ctx context.Context, addr string, timeout time.Duration) (ssh.PublicKey, error) {
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User: DefaultSSHUser,
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errHostKeyCaptured
+		},
+		Timeout: timeout,
+	}
+	client, err := DialSSH(ctx, addr, config)
+	if client != nil {
+		client.Close()
+	}
+	if hostKey != nil {
+		return hostKey, nil
+	}
+	if err == nil {
+		err = errors.New("server presented no host key")
+	}
+	return nil, fmt.Errorf("fetching host key from %s: %w", addr, err)
+}
+
+// DialInstance opens an SSH connection to port 22 of the instance's public IP.
+func DialInstance(ctx context.Context, inst *Instance, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if inst.IP == "" || inst.IP == "null" {
+		return nil, fmt.Errorf("instance '%s' (ID: %s) has no IP address yet", inst.Name, inst.ID)
+	}
+	return DialSSH(ctx, net.JoinHostPort(inst.IP, "22"), config)
+}
+
+// runSession runs command on session, tearing the session down if ctx is
+// cancelled before the command completes.
+func runSession(ctx context.Context, session *ssh.Session, command string) error {
+	done := make(chan error, 1)
+	go func() { done <- session.Run(command) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// Best effort: ask the remote process to stop, then drop the channel.
+		_ = session.Signal(ssh.SIGTERM)
+		session.Close()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// RunCommand executes command on the remote host, streaming its output to
+// stdout and stderr (either may be nil to discard). The remote command is
+// signalled and the session closed if ctx is cancelled.
+func RunCommand(ctx context.Context, client *ssh.Client, command string, stdout, stderr io.Writer) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	err = runSession(ctx, session, command)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("remote command '%s' cancelled: %w", command, err)
+		}
+		// Check if it's an ExitError to potentially get more details
+		var exitError *ssh.ExitError
+		if errors.As(err, &exitError) {
+			return fmt.Errorf("remote command '%s' failed with exit status %d: %w", command, exitError.ExitStatus(), err)
+		}
+		return fmt.Errorf("failed to run remote command '%s': %w", command, err)
+	}
+	return nil
+}
+
+// CopyContent writes content to remotePath with the given permissions using
+// the SCP protocol, creating the parent directory if needed.
+func CopyContent(ctx context.Context, client *ssh.Client, content []byte, remotePath string, perm os.FileMode) error {
+	filename := path.Base(remotePath)
+	directory := path.Dir(remotePath)
+
+	// Ensure remote target directory exists; failures surface in the scp step.
+	if directory != "." && directory != "/" {
+		_ = RunCommand(ctx, client, "mkdir -p "+directory, nil, nil)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session for copy: %w", err)
+	}
+	defer session.Close()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stdin pipe: %w", err)
+	}
+
+	go func() {
+		defer stdin.Close()
+		fmt.Fprintf(stdin, "C%04o %d %s\n", perm.Perm(), len(content), filename)
+		stdin.Write(content)
+		fmt.Fprint(stdin, "\x00")
+	}()
+
+	// Capture stderr for better error reporting
+	var stderrBuf bytes.Buffer
+	session.Stderr = &stderrBuf
+
+	remoteCmd := "scp -qt " + directory
+	if err := runSession(ctx, session, remoteCmd); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("copy to '%s' cancelled: %w", remotePath, err)
+		}
+		if stderrStr := strings.TrimSpace(stderrBuf.String()); stderrStr != "" {
+			return fmt.Errorf("failed to run remote scp command '%s' for '%s': %w. Stderr: %s", remoteCmd, remotePath, err, stderrStr)
+		}
+		return fmt.Errorf("failed to run remote scp command '%s' for '%s': %w", remoteCmd, remotePath, err)
+	}
+	return nil
+}
+
+// CopyFile copies a single local file to remotePath, preserving its permissions.
+func CopyFile(ctx context.Context, client *ssh.Client, localPath, remotePath string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("stat local file '%s': %w", localPath, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("local path '%s' is a directory, expected a file. Use CopyDir for directories", localPath)
+	}
+	content, err := os.ReadFile(localPath)
+	if err != nil {
+		return fmt.Errorf("reading local file '%s': %w", localPath, err)
+	}
+	return CopyContent(ctx, client, content, remotePath, info.Mode())
+}
+
+// CopyDir copies the contents of a local directory into remoteDir, recreating
+// the directory structure.
+func CopyDir(ctx context.Context, client *ssh.Client, localDir, remoteDir string) error {
+	info, err := os.Stat(localDir)
+	if err != nil {
+		return fmt.Errorf("stat local directory '%s': %w", localDir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("local path '%s' is not a directory, expected a directory. Use CopyFile for files", localDir)
+	}
+
+	if err := RunCommand(ctx, client, "mkdir -p "+remoteDir, nil, nil); err != nil {
+		return fmt.Errorf("failed to create base remote directory '%s': %w", remoteDir, err)
+	}
+
+	return filepath.WalkDir(localDir, func(localPath string, d os.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return fmt.Errorf("error walking local path '%s': %w", localPath, walkErr)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(localDir, localPath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for '%s' from base '%s': %w", localPath, localDir, err)
+		}
+		if rel == "." {
+			return nil
+		}
+		remotePath := path.Join(remoteDir, filepath.ToSlash(rel))
+
+		if d.IsDir() {
+			// Missing directories are created by CopyContent anyway, so a
+			// failure here is not fatal.
+			_ = RunCommand(ctx, client, "mkdir -p "+remotePath, nil, nil)
+			return nil
+		}
+		if err := CopyFile(ctx, client, localPath, remotePath); err != nil {
+			return fmt.Errorf("failed to copy file '%s' to '%s': %w", localPath, remotePath, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,55 @@
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestFetchHostKey(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// The client hangs up after the key exchange, so this fails.
+		ssh.NewServerConn(conn, config)
+	}()
+
+	ctx := context.Background()
+	key, err := FetchHostKey(ctx, ln.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("FetchHostKey: %v", err)
+	}
+	if !bytes.Equal(key.Marshal(), signer.PublicKey().Marshal()) {
+		t.Errorf("got key %s, want %s", ssh.FingerprintSHA256(key), ssh.FingerprintSHA256(signer.PublicKey()))
+	}
+
+	ln.Close()
+	if _, err := FetchHostKey(ctx, ln.Addr().String(), time.Second); err == nil {
+		t.Error("expected an error with nothing listening")
+	}
+}
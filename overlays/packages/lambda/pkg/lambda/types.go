@@ -0,0 +1,207 @@
+package lambda
+
+// Instance represents the data structure for a single instance.
+type Instance struct {
+	ID              string   `json:"id"`
+	Name            string   `json:"name"`
+	IP              string   `json:"ip,omitempty"` // omitempty in case IP is null or missing
+	PrivateIP       string   `json:"private_ip,omitempty"`
+	Hostname        string   `json:"hostname,omitempty"`
+	Status          string   `json:"status"`
+	SSHKeyNames     []string `json:"ssh_key_names,omitempty"`
+	FileSystemNames []string `json:"file_system_names,omitempty"`
+	JupyterURL      string   `json:"jupyter_url,omitempty"`
+	Region          struct {
+		Name string `json:"name"`
+	} `json:"region"`
+	InstanceType struct {
+		Name              string `json:"name"`
+		PriceCentsPerHour int    `json:"price_cents_per_hour"`
+	} `json:"instance_type"`
+}
+
+// InstancesResponse represents the API response for listing instances.
+type InstancesResponse struct {
+	Data []Instance `json:"data"`
+}
+
+// InstanceResponse represents the API response for a single instance.
+type InstanceResponse struct {
+	Data Instance `json:"data"`
+}
+
+// UpdateInstanceRequest represents the payload for updating an instance.
+type UpdateInstanceRequest struct {
+	Name string `json:"name"`
+}
+
+// InstanceType represents the details of a specific instance type.
+type InstanceType struct {
+	Name              string `json:"name"`
+	PriceCentsPerHour int    `json:"price_cents_per_hour"`
+	Description       string `json:"description"`
+	Specs             struct {
+		Vcpus  int `json:"vcpus"`
+		MemGiB int `json:"mem_gib"`
+		Gpus   int `json:"gpus"`
+	} `json:"specs"`
+	GpuDescription string `json:"gpu_description"`
+}
+
+// Region represents a geographical region.
+type Region struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// InstanceTypeDetails includes the instance type and its availability.
+type InstanceTypeDetails struct {
+	InstanceType        InstanceType `json:"instance_type"`
+	RegionsWithCapacity []Region     `json:"regions_with_capacity_available"`
+}
+
+// InstanceTypesResponse represents the API response for listing instance types.
+type InstanceTypesResponse struct {
+	Data map[string]InstanceTypeDetails `json:"data"`
+}
+
+// FileSystem represents a shared filesystem.
+type FileSystem struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	MountPoint string `json:"mount_point,omitempty"`
+	Created    string `json:"created,omitempty"`
+	IsInUse    bool   `json:"is_in_use"`
+	BytesUsed  int64  `json:"bytes_used,omitempty"`
+	Region     struct {
+		Name string `json:"name"`
+	} `json:"region"`
+}
+
+// FileSystemsResponse represents the API response for listing filesystems.
+type FileSystemsResponse struct {
+	Data []FileSystem `json:"data"`
+}
+
+// LaunchRequest represents the payload for launching new instances.
+type LaunchRequest struct {
+	RegionName       string   `json:"region_name"`
+	InstanceTypeName string   `json:"instance_type_name"`
+	SSHKeyNames      []string `json:"ssh_key_names"`
+	FileSystemNames  []string `json:"file_system_names,omitempty"`
+	Name             string   `json:"name"`
+	Quantity         int      `json:"quantity,omitempty"` // Defaults to 1 if omitted
+	Image            *Image   `json:"image,omitempty"`    // Only ID or Family need to be set
+	UserData         string   `json:"user_data,omitempty"`
+}
+
+// LaunchResponse represents the API response after launching instances.
+type LaunchResponse struct {
+	Data struct {
+		InstanceIDs []string `json:"instance_ids"`
+	} `json:"data"`
+}
+
+// CreateFilesystemRequest represents the payload for creating a filesystem.
+type CreateFilesystemRequest struct {
+	RegionName string `json:"region"`
+	Name       string `json:"name"`
+}
+
+// CreateFilesystemResponse represents the API response after creating a filesystem.
+type CreateFilesystemResponse struct {
+	Data FileSystem `json:"data"`
+}
+
+// DeleteFilesystemResponse represents the API response after deleting a filesystem.
+type DeleteFilesystemResponse struct {
+	Data struct {
+		DeletedIDs []string `json:"deleted_ids"`
+	} `json:"data"`
+}
+
+// TerminateRequest represents the payload for terminating instances.
+type TerminateRequest struct {
+	InstanceIDs []string `json:"instance_ids"`
+}
+
+// TerminateResponse represents the API response after terminating instances.
+type TerminateResponse struct {
+	Data struct {
+		TerminatedInstances []Instance `json:"terminated_instances"` // API likely returns full instance details
+	} `json:"data"`
+}
+
+// RestartRequest represents the payload for restarting instances.
+type RestartRequest struct {
+	InstanceIDs []string `json:"instance_ids"`
+}
+
+// RestartResponse represents the API response after restarting instances.
+type RestartResponse struct {
+	Data struct {
+		RestartedInstances []Instance `json:"restarted_instances"`
+	} `json:"data"`
+}
+
+// SSHKey represents an SSH key registered with Lambda Cloud.
+type SSHKey struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key"`
+	// PrivateKey is only set when the key pair was generated by the API.
+	PrivateKey string `json:"private_key,omitempty"`
+}
+
+// SSHKeysResponse represents the API response for listing SSH keys.
+type SSHKeysResponse struct {
+	Data []SSHKey `json:"data"`
+}
+
+// AddSSHKeyRequest represents the payload for registering an SSH key.
+// When PublicKey is empty the API generates a new key pair.
+type AddSSHKeyRequest struct {
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+// AddSSHKeyResponse represents the API response after registering an SSH key.
+type AddSSHKeyResponse struct {
+	Data SSHKey `json:"data"`
+}
+
+// Image represents a machine image instances can be launched from.
+type Image struct {
+	ID           string  `json:"id,omitempty"`
+	Name         string  `json:"name,omitempty"`
+	Description  string  `json:"description,omitempty"`
+	Family       string  `json:"family,omitempty"`
+	Version      string  `json:"version,omitempty"`
+	Architecture string  `json:"architecture,omitempty"`
+	CreatedTime  string  `json:"created_time,omitempty"`
+	UpdatedTime  string  `json:"updated_time,omitempty"`
+	Region       *Region `json:"region,omitempty"`
+}
+
+// ImagesResponse represents the API response for listing images.
+type ImagesResponse struct {
+	Data []Image `json:"data"`
+}
+
+// FirewallRule represents an inbound firewall rule.
+type FirewallRule struct {
+	Protocol      string `json:"protocol"`             // tcp, udp, icmp or all
+	PortRange     []int  `json:"port_range,omitempty"` // [from, to], omitted for icmp
+	SourceNetwork string `json:"source_network"`       // CIDR, e.g. 0.0.0.0/0
+	Description   string `json:"description"`
+}
+
+// FirewallRulesResponse represents the API response for listing firewall rules.
+type FirewallRulesResponse struct {
+	Data []FirewallRule `json:"data"`
+}
+
+// SetFirewallRulesRequest represents the payload replacing all firewall rules.
+type SetFirewallRulesRequest struct {
+	Data []FirewallRule `json:"data"`
+}